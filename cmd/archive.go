@@ -6,17 +6,19 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
 	"github.com/MichielBijland/uncomplicated-registry/internal/module"
+	registrystorage "github.com/MichielBijland/uncomplicated-registry/internal/storage"
 	"github.com/MichielBijland/uncomplicated-registry/internal/utils"
 
 	"github.com/hashicorp/go-version"
 )
 
-func archiveModules(root string, metadata module.Metadata, storage module.Storage) error {
-	return processModule(root, metadata, storage)
+func archiveModules(root string, metadata module.Metadata, publish core.PublishMetadata, storage module.Storage) error {
+	return processModule(root, metadata, publish, storage)
 }
 
-func processModule(path string, metadata module.Metadata, storage module.Storage) error {
+func processModule(path string, metadata module.Metadata, publish core.PublishMetadata, storage module.Storage) error {
 
 	// Check if the module meets version constraints
 	if versionConstraintsSemver != nil {
@@ -38,6 +40,7 @@ func processModule(path string, metadata module.Metadata, storage module.Storage
 		}
 	}
 
+	// Fail fast before archiving; the storage backend still guarantees that a published version is never overwritten.
 	ctx := context.Background()
 	if res, err := storage.GetModule(ctx, metadata.Namespace, metadata.Name, metadata.Provider, metadata.Version); err == nil {
 		logger.Error().Str("download_url", res.DownloadURL).Msg("module already exists")
@@ -46,13 +49,16 @@ func processModule(path string, metadata module.Metadata, storage module.Storage
 
 	moduleRoot := filepath.Dir(path)
 
-	buf, err := utils.ArchiveModule(moduleRoot, logger)
+	buf, err := utils.ArchiveModule(moduleRoot, flagArchiveFormat, logger)
 	if err != nil {
 		return err
 	}
 
-	res, err := storage.UploadModule(ctx, metadata.Namespace, metadata.Name, metadata.Provider, metadata.Version, buf)
+	res, err := storage.UploadModule(ctx, metadata.Namespace, metadata.Name, metadata.Provider, metadata.Version, buf, publish)
 	if err != nil {
+		if errors.Is(err, registrystorage.ErrModuleAlreadyExists) {
+			logger.Error().Str("metadata", metadata.String()).Msg("module was published concurrently")
+		}
 		return err
 	}
 
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/audit"
+	"github.com/MichielBijland/uncomplicated-registry/internal/retention"
+	"github.com/MichielBijland/uncomplicated-registry/internal/storage"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var (
+	// Audit sink options.
+	flagAuditFile          string
+	flagAuditStdout        bool
+	flagAuditStorage       bool
+	flagAuditFlushInterval time.Duration
+
+	// Audit query options.
+	flagAuditModule   string
+	flagAuditIdentity string
+	flagAuditAction   string
+	flagAuditSince    time.Duration
+	flagAuditJSON     bool
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspects the audit log of the registry",
+}
+
+var auditQueryCmd = &cobra.Command{
+	Use:          "query",
+	Short:        "Prints the audit events of a module or identity",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+
+		filter := audit.Filter{
+			Module:   flagAuditModule,
+			Identity: flagAuditIdentity,
+			Action:   flagAuditAction,
+		}
+		if flagAuditSince > 0 {
+			filter.Since = time.Now().Add(-flagAuditSince)
+		}
+
+		events, err := queryAudit(ctx, filter)
+		if err != nil {
+			return errors.Wrap(err, "failed to query audit log")
+		}
+
+		if flagAuditJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			for _, event := range events {
+				if err := encoder.Encode(event); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tACTION\tMODULE\tIDENTITY\tRESULT\tSTATUS\tSOURCE IP\tREASON")
+		for _, e := range events {
+			identity := e.Identity
+			if identity == "" {
+				identity = "anonymous"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\n", e.Time.Format(time.RFC3339), e.Action, e.Module,
+				identity, e.Result, e.Status, e.SourceIP, e.Reason)
+		}
+
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditQueryCmd)
+
+	auditQueryCmd.Flags().StringVar(&flagAuditFile, "audit-file", "", "JSON lines audit file to query, the audit objects in the storage are queried without it")
+	auditQueryCmd.Flags().StringVar(&flagAuditModule, "module", "", "Module ID (namespace/name/provider[/version]) or path.Match pattern to select the events of")
+	auditQueryCmd.Flags().StringVar(&flagAuditIdentity, "identity", "", "Identity name or path.Match pattern to select the events of")
+	auditQueryCmd.Flags().StringVar(&flagAuditAction, "action", "", "Action to select the events of (e.g. module.download or module.publish)")
+	auditQueryCmd.Flags().DurationVar(&flagAuditSince, "since", 0, "Only select the events of this recent period (e.g. 24h)")
+	auditQueryCmd.Flags().BoolVar(&flagAuditJSON, "json", false, "Print the events as JSON lines")
+}
+
+// addAuditFlags adds the flags configuring the audit sinks to a command emitting audit events.
+func addAuditFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&flagAuditFile, "audit-file", "", "File to append the audit events to as JSON lines")
+	flags.BoolVar(&flagAuditStdout, "audit-stdout", false, "Write the audit events to stdout as JSON lines")
+	flags.BoolVar(&flagAuditStorage, "audit-storage", false, "Write the audit events as append-only objects next to the modules in the storage")
+	flags.DurationVar(&flagAuditFlushInterval, "audit-flush-interval", audit.DefaultFlushInterval, "Interval at which the audit events are written to the storage")
+}
+
+// setupAuditor returns an audit.Auditor for the configured sinks, or nil without sinks.
+func setupAuditor(s storage.Storage) (*audit.Auditor, error) {
+	var sinks []audit.Sink
+
+	if flagAuditStdout {
+		sinks = append(sinks, audit.NewWriterSink(os.Stdout))
+	}
+
+	if flagAuditFile != "" {
+		sink, err := audit.NewFileSink(flagAuditFile)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if flagAuditStorage {
+		metadata, ok := s.(storage.MetadataStorage)
+		if !ok {
+			return nil, errors.New("the storage backend cannot keep audit events, please specify --audit-file")
+		}
+		sinks = append(sinks, audit.NewStorageSink(metadata, logger.With().Str("component", "audit").Logger(),
+			audit.WithFlushInterval(flagAuditFlushInterval),
+		))
+	}
+
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+
+	return audit.New(logger.With().Str("component", "audit").Logger(), sinks...), nil
+}
+
+// auditDeletion records the deletions of the retention policy.
+func auditDeletion(auditor *audit.Auditor) retention.DeletionHook {
+	return func(ctx context.Context, deletion retention.Deletion, err error) {
+		event := audit.Event{
+			Action:   audit.ActionDelete,
+			Module:   deletion.Module.ID(true),
+			Identity: "retention",
+			Result:   audit.ResultSuccess,
+			Reason:   deletion.Reason,
+		}
+		if err != nil {
+			event.Result = audit.ResultFailure
+			event.Reason = err.Error()
+		}
+
+		auditor.Record(ctx, event)
+	}
+}
+
+func queryAudit(ctx context.Context, filter audit.Filter) ([]audit.Event, error) {
+	if flagAuditFile != "" {
+		return audit.QueryFile(flagAuditFile, filter)
+	}
+
+	s, err := setupStorage(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to setup storage")
+	}
+
+	metadata, ok := s.(storage.MetadataStorage)
+	if !ok {
+		return nil, errors.New("the storage backend cannot keep audit events, please specify --audit-file")
+	}
+
+	return audit.QueryStorage(ctx, metadata, filter)
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/audit"
+	"github.com/MichielBijland/uncomplicated-registry/internal/retention"
+	"github.com/MichielBijland/uncomplicated-registry/internal/storage"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagRetentionPolicy   string
+	flagRetentionDryRun   bool
+	flagRetentionInterval time.Duration
+)
+
+var gcCmd = &cobra.Command{
+	Use:          "gc",
+	Short:        "Deletes module versions that are not retained by the retention policy",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+
+		s, err := setupStorage(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to setup storage")
+		}
+
+		auditor, err := setupAuditor(s)
+		if err != nil {
+			return errors.Wrap(err, "failed to setup audit log")
+		}
+		if auditor != nil {
+			defer auditor.Close()
+		}
+
+		collector, err := setupCollector(s, nil, auditor)
+		if err != nil {
+			return err
+		}
+
+		deletions, err := collector.Run(ctx, flagRetentionDryRun)
+		logger.Info().Int("count", len(deletions)).Bool("dry_run", flagRetentionDryRun).Msg("garbage collection finished")
+
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(gcCmd)
+
+	gcCmd.Flags().StringVar(&flagRetentionPolicy, "retention-policy", "", "YAML file with the retention rules")
+	gcCmd.MarkFlagRequired("retention-policy")
+	gcCmd.Flags().BoolVar(&flagRetentionDryRun, "dry-run", false, "Only report the module versions that would be deleted")
+	addAuditFlags(gcCmd.Flags())
+}
+
+// setupCollector returns a retention.Collector for the configured retention policy.
+// Without a download history, versions covered by a downloaded_within_days rule are never deleted.
+func setupCollector(s storage.Storage, downloads retention.DownloadHistory, auditor *audit.Auditor) (*retention.Collector, error) {
+	policy, err := retention.LoadPolicy(flagRetentionPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	var options []retention.CollectorOption
+	if downloads != nil {
+		options = append(options, retention.WithDownloadHistory(downloads))
+	}
+	if auditor != nil {
+		options = append(options, retention.WithDeletionHook(auditDeletion(auditor)))
+	}
+
+	return retention.NewCollector(s, policy, logger.With().Str("component", "retention").Logger(), options...), nil
+}
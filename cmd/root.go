@@ -6,6 +6,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/MichielBijland/uncomplicated-registry/internal/storage"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -22,12 +24,52 @@ var (
 	flagDebug bool
 
 	// S3 options.
-	flagS3Bucket          string
-	flagS3Prefix          string
-	flagS3Region          string
-	flagS3Endpoint        string
-	flagS3PathStyle       bool
-	flagS3SignedURLExpiry time.Duration
+	flagS3Bucket           string
+	flagS3Prefix           string
+	flagS3Region           string
+	flagS3Endpoint         string
+	flagS3PathStyle        bool
+	flagS3KeyLayout        string
+	flagS3SignedURLExpiry  time.Duration
+	flagS3ContentAddressed bool
+
+	// S3 client options.
+	flagS3Profile                string
+	flagS3AccessKeyID            string
+	flagS3SecretAccessKey        string
+	flagS3SessionToken           string
+	flagS3RoleARN                string
+	flagS3RoleExternalID         string
+	flagS3RoleSessionName        string
+	flagS3PresignRoleARN         string
+	flagS3PresignRoleExternalID  string
+	flagS3PresignRoleSessionName string
+	flagS3MaxRetries             int
+	flagS3Timeout                time.Duration
+	flagS3CABundle               string
+
+	// S3 upload options.
+	flagS3SSE            string
+	flagS3SSEKMSKeyID    string
+	flagS3SSECustomerKey string
+	flagS3ObjectTags     map[string]string
+	flagS3StorageClass   string
+	flagS3ACL            string
+
+	// In-memory options.
+	flagInmem         bool
+	flagInmemSnapshot string
+
+	// OCI options.
+	flagOCIRepository     string
+	flagOCIInsecure       bool
+	flagOCIUsername       string
+	flagOCIPassword       string
+	flagOCIProxyDownloads bool
+
+	// Git options.
+	flagGitConfig    string
+	flagGitCacheSize int
 )
 
 var (
@@ -66,7 +108,37 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&flagS3Region, "storage-s3-region", "", "S3 bucket region to use for the registry")
 	rootCmd.PersistentFlags().StringVar(&flagS3Endpoint, "storage-s3-endpoint", "", "S3 bucket endpoint URL (required for MINIO)")
 	rootCmd.PersistentFlags().BoolVar(&flagS3PathStyle, "storage-s3-pathstyle", false, "S3 use PathStyle (required for MINIO)")
+	rootCmd.PersistentFlags().StringVar(&flagS3KeyLayout, "storage-s3-key-layout", storage.DefaultModuleKeyLayout, "Object key layout of modules relative to the bucket prefix, supports {namespace}, {name}, {provider}, {version} and {ext}, layouts without {ext} store the format of their extension (e.g. module.zip)")
 	rootCmd.PersistentFlags().DurationVar(&flagS3SignedURLExpiry, "storage-s3-signedurl-expiry", 30*time.Second, "Generate S3 signed URL valid for X seconds. Only meaningful if used in combination with --storage-s3-signedurl")
+	rootCmd.PersistentFlags().BoolVar(&flagS3ContentAddressed, "storage-s3-content-addressed", false, "Store identical module archives once under blobs/sha256/<digest>, referenced by a manifest per version")
+	rootCmd.PersistentFlags().StringVar(&flagS3Profile, "storage-s3-profile", "", "Shared config profile to load the S3 credentials and settings from")
+	rootCmd.PersistentFlags().StringVar(&flagS3AccessKeyID, "storage-s3-access-key-id", "", "Static S3 access key ID")
+	rootCmd.PersistentFlags().StringVar(&flagS3SecretAccessKey, "storage-s3-secret-access-key", "", "Static S3 secret access key")
+	rootCmd.PersistentFlags().StringVar(&flagS3SessionToken, "storage-s3-session-token", "", "Static S3 session token")
+	rootCmd.PersistentFlags().StringVar(&flagS3RoleARN, "storage-s3-role-arn", "", "IAM role to assume for S3 requests")
+	rootCmd.PersistentFlags().StringVar(&flagS3RoleExternalID, "storage-s3-role-external-id", "", "External ID used when assuming --storage-s3-role-arn")
+	rootCmd.PersistentFlags().StringVar(&flagS3RoleSessionName, "storage-s3-role-session-name", projectName, "Session name used when assuming --storage-s3-role-arn")
+	rootCmd.PersistentFlags().StringVar(&flagS3PresignRoleARN, "storage-s3-presign-role-arn", "", "IAM role to assume for signing download URLs")
+	rootCmd.PersistentFlags().StringVar(&flagS3PresignRoleExternalID, "storage-s3-presign-role-external-id", "", "External ID used when assuming --storage-s3-presign-role-arn")
+	rootCmd.PersistentFlags().StringVar(&flagS3PresignRoleSessionName, "storage-s3-presign-role-session-name", projectName, "Session name used when assuming --storage-s3-presign-role-arn")
+	rootCmd.PersistentFlags().IntVar(&flagS3MaxRetries, "storage-s3-max-retries", 0, "Maximum number of attempts per S3 request (0 uses the SDK default)")
+	rootCmd.PersistentFlags().DurationVar(&flagS3Timeout, "storage-s3-timeout", 0, "Timeout per S3 HTTP request (0 disables the timeout)")
+	rootCmd.PersistentFlags().StringVar(&flagS3CABundle, "storage-s3-ca-bundle", "", "PEM file with the certificate authorities trusted for the S3 endpoint")
+	rootCmd.PersistentFlags().StringVar(&flagS3SSE, "storage-s3-sse", "", "Server-side encryption of uploaded modules (s3, kms or customer), enforced when modules are read")
+	rootCmd.PersistentFlags().StringVar(&flagS3SSEKMSKeyID, "storage-s3-sse-kms-key-id", "", "KMS key to encrypt uploaded modules with. Only meaningful if used in combination with --storage-s3-sse=kms")
+	rootCmd.PersistentFlags().StringVar(&flagS3SSECustomerKey, "storage-s3-sse-customer-key", "", "Base64 encoded 256-bit key to encrypt uploaded modules with, the modules are served through the registry. Only meaningful if used in combination with --storage-s3-sse=customer")
+	rootCmd.PersistentFlags().StringToStringVar(&flagS3ObjectTags, "storage-s3-object-tags", nil, "Tags added to uploaded modules (key=value pairs)")
+	rootCmd.PersistentFlags().StringVar(&flagS3StorageClass, "storage-s3-storage-class", "", "Storage class of uploaded modules")
+	rootCmd.PersistentFlags().StringVar(&flagS3ACL, "storage-s3-acl", "", "Canned ACL of uploaded modules")
+	rootCmd.PersistentFlags().BoolVar(&flagInmem, "storage-inmem", false, "Keep modules in memory and serve them through the registry (for demos and testing)")
+	rootCmd.PersistentFlags().StringVar(&flagInmemSnapshot, "storage-inmem-snapshot", "", "File to persist the in-memory storage to, restored on startup")
+	rootCmd.PersistentFlags().StringVar(&flagOCIRepository, "storage-oci-repository", "", "OCI registry host and path to store modules in as artifacts, e.g. ghcr.io/example/modules")
+	rootCmd.PersistentFlags().BoolVar(&flagOCIInsecure, "storage-oci-insecure", false, "Allow plain HTTP connections to the OCI registry")
+	rootCmd.PersistentFlags().StringVar(&flagOCIUsername, "storage-oci-username", "", "OCI registry username, the Docker config is used if not set")
+	rootCmd.PersistentFlags().StringVar(&flagOCIPassword, "storage-oci-password", "", "OCI registry password")
+	rootCmd.PersistentFlags().BoolVar(&flagOCIProxyDownloads, "storage-oci-proxy-downloads", false, "Serve module archives through the registry instead of the OCI registry (required without anonymous pulls)")
+	rootCmd.PersistentFlags().StringVar(&flagGitConfig, "storage-git-config", "", "YAML file mapping namespace/name/provider to git repositories, whose semver tags are served as module versions")
+	rootCmd.PersistentFlags().IntVar(&flagGitCacheSize, "storage-git-cache-size", storage.DefaultGitArchiveCacheSize, "Number of module archives built from git tags to keep in memory")
 }
 
 func initializeConfig(cmd *cobra.Command) error {
@@ -2,14 +2,25 @@ package cmd
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/MichielBijland/uncomplicated-registry/internal/audit"
 	"github.com/MichielBijland/uncomplicated-registry/internal/auth"
+	"github.com/MichielBijland/uncomplicated-registry/internal/exchange"
+	"github.com/MichielBijland/uncomplicated-registry/internal/login"
+	"github.com/MichielBijland/uncomplicated-registry/internal/metrics"
+	"github.com/MichielBijland/uncomplicated-registry/internal/retention"
 	"github.com/MichielBijland/uncomplicated-registry/internal/storage"
+	"github.com/MichielBijland/uncomplicated-registry/internal/token"
+	"github.com/MichielBijland/uncomplicated-registry/internal/tracing"
 	"github.com/rs/zerolog"
 
 	"github.com/pkg/errors"
@@ -18,6 +29,7 @@ import (
 
 	"github.com/MichielBijland/uncomplicated-registry/internal/discovery"
 	"github.com/MichielBijland/uncomplicated-registry/internal/module"
+	"github.com/MichielBijland/uncomplicated-registry/internal/ratelimit"
 
 	"github.com/spf13/cobra"
 
@@ -35,6 +47,7 @@ const (
 var (
 	prefix        = fmt.Sprintf("/%s", apiVersion)
 	prefixModules = fmt.Sprintf("%s/modules", prefix)
+	prefixAdmin   = fmt.Sprintf("%s/admin", prefix)
 )
 
 var (
@@ -42,6 +55,13 @@ var (
 	flagTLSCertFile string
 	flagTLSKeyFile  string
 	flagListenAddr  string
+	flagProxyHeader string
+	flagProxies     []string
+
+	// Client certificate options.
+	flagTLSClientCAFile       string
+	flagTLSClientCertRequired bool
+	flagAuthClientCertSubject string
 
 	// Login options.
 	flagLoginClient     string
@@ -51,8 +71,63 @@ var (
 	flagLoginToken      string
 	flagLoginPorts      []int
 
+	// Built-in login server options.
+	flagLoginServerURL        string
+	flagLoginSigningKeyFile   string
+	flagLoginTokenTTL         time.Duration
+	flagLoginOIDCIssuer       string
+	flagLoginOIDCClientID     string
+	flagLoginOIDCClientSecret string
+	flagLoginOIDCScopes       []string
+
+	// CI token exchange options.
+	flagExchangePolicy         string
+	flagExchangeURL            string
+	flagExchangeSigningKeyFile string
+	flagExchangeTokenTTL       time.Duration
+
+	// Authorization.
+	flagAuthPolicy            string
+	flagAuthAnonymousReads    bool
+	flagAuthPrivateNamespaces []string
+
+	// Publish API.
+	flagPublishAPI     bool
+	flagPublishMaxSize int64
+
+	// Rate limiting.
+	flagRateLimitPolicy string
+
+	// Metrics.
+	flagMetrics bool
+
 	// Static auth.
 	flagAuthStaticTokens []string
+
+	// Basic auth.
+	flagAuthHtpasswdFile string
+
+	// LDAP auth.
+	flagAuthLDAPURL                string
+	flagAuthLDAPBindDN             string
+	flagAuthLDAPBindPassword       string
+	flagAuthLDAPUserBaseDN         string
+	flagAuthLDAPUserFilter         string
+	flagAuthLDAPEmailAttribute     string
+	flagAuthLDAPGroupBaseDN        string
+	flagAuthLDAPGroupFilter        string
+	flagAuthLDAPGroupNameAttribute string
+	flagAuthLDAPStartTLS           bool
+	flagAuthLDAPCAFile             string
+	flagAuthLDAPCacheTTL           time.Duration
+
+	// JWT auth.
+	flagAuthJWTIssuer       string
+	flagAuthJWTAudience     string
+	flagAuthJWTKeySetFile   string
+	flagAuthJWTSubjectClaim string
+	flagAuthJWTEmailClaim   string
+	flagAuthJWTGroupsClaim  string
 )
 
 var serverCmd = &cobra.Command{
@@ -64,7 +139,45 @@ var serverCmd = &cobra.Command{
 
 		group, ctx := errgroup.WithContext(ctx)
 
-		server, err := createFiber(logger)
+		provider, err := setupTracing(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to setup tracing")
+		}
+		if provider != nil {
+			// Flushes the remaining spans, the server context is cancelled by then
+			defer func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+
+				if err := provider.Shutdown(ctx); err != nil {
+					logger.Error().Err(err).Msg("failed to flush spans")
+				}
+			}()
+		}
+
+		s, err := setupStorage(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to setup storage")
+		}
+
+		auditor, err := setupAuditor(s)
+		if err != nil {
+			return errors.Wrap(err, "failed to setup audit log")
+		}
+		if auditor != nil {
+			defer auditor.Close()
+		}
+
+		downloads := retention.NewDownloads()
+		serviceOptions := []module.ServiceOption{module.WithDownloadHook(downloads.Record)}
+
+		var m *metrics.Metrics
+		if flagMetrics {
+			m = metrics.New()
+			serviceOptions = append(serviceOptions, module.WithDownloadHook(m.RecordDownload))
+		}
+
+		server, err := createFiber(logger, s, auditor, m, serviceOptions...)
 		if err != nil {
 			return errors.Wrap(err, "failed to setup server")
 		}
@@ -96,13 +209,43 @@ var serverCmd = &cobra.Command{
 			return nil
 		})
 
+		// Retention handler.
+		if flagRetentionPolicy != "" && flagRetentionInterval > 0 {
+			collector, err := setupCollector(s, downloads, auditor)
+			if err != nil {
+				return err
+			}
+
+			group.Go(func() error {
+				ticker := time.NewTicker(flagRetentionInterval)
+				defer ticker.Stop()
+
+				for {
+					select {
+					case <-ticker.C:
+						if _, err := collector.Run(ctx, flagRetentionDryRun); err != nil {
+							logger.Error().Err(err).Msg("failed to apply retention policy")
+						}
+					case <-ctx.Done():
+						return nil
+					}
+				}
+			})
+		}
+
 		// Main server.
 		group.Go(func() error {
 			sublogger := logger.With().Str("listen", flagListenAddr).Logger()
 			sublogger.Info().Msg("starting server")
 			defer sublogger.Info().Msg("shutting down server")
 
-			if flagTLSCertFile != "" || flagTLSKeyFile != "" {
+			if flagTLSClientCAFile != "" {
+				if err := listenMutualTLS(server); err != nil {
+					if err != http.ErrServerClosed {
+						return err
+					}
+				}
+			} else if flagTLSCertFile != "" || flagTLSKeyFile != "" {
 				if err := server.ListenTLS(flagListenAddr, flagTLSCertFile, flagTLSKeyFile); err != nil {
 					if err != http.ErrServerClosed {
 						return err
@@ -129,8 +272,65 @@ func init() {
 	serverCmd.Flags().StringVar(&flagTLSKeyFile, "tls-key-file", "", "TLS private key to serve")
 	serverCmd.Flags().StringVar(&flagTLSCertFile, "tls-cert-file", "", "TLS certificate to serve")
 	serverCmd.Flags().StringVar(&flagListenAddr, "listen-address", ":5601", "Address to listen on")
+	serverCmd.Flags().StringVar(&flagProxyHeader, "proxy-header", "", "Header holding the client IP address set by the trusted proxies (e.g. X-Forwarded-For)")
+	serverCmd.Flags().StringSliceVar(&flagProxies, "trusted-proxy", nil, "IP address or CIDR of a proxy whose --proxy-header is trusted")
+	// Client certificate options.
+	serverCmd.Flags().StringVar(&flagTLSClientCAFile, "tls-client-ca-file", "", "PEM bundle of the CAs client certificates are verified with, requests with a verified certificate are authenticated by it")
+	serverCmd.Flags().BoolVar(&flagTLSClientCertRequired, "tls-client-cert-required", false, "Reject connections without a verified client certificate, instead of falling back to bearer tokens")
+	serverCmd.Flags().StringVar(&flagAuthClientCertSubject, "auth-client-cert-subject", auth.ClientCertSubjectCommonName, "Client certificate field the subject of the identity is taken from (cn, dns, uri or email)")
+	// Audit options.
+	addAuditFlags(serverCmd.Flags())
+	// Retention options.
+	serverCmd.Flags().StringVar(&flagRetentionPolicy, "retention-policy", "", "YAML file with the retention rules applied in the background")
+	serverCmd.Flags().DurationVar(&flagRetentionInterval, "retention-interval", 24*time.Hour, "Interval at which the retention policy is applied")
+	serverCmd.Flags().BoolVar(&flagRetentionDryRun, "retention-dry-run", false, "Only report the module versions that would be deleted by the retention policy")
+	// CI token exchange options.
+	serverCmd.Flags().StringVar(&flagExchangePolicy, "exchange-policy", "", "YAML file with the trusted CI issuers and the namespaces their jobs may publish to, enables the token exchange at "+exchange.Path)
+	serverCmd.Flags().StringVar(&flagExchangeURL, "exchange-url", "", "Public URL of the registry, the issuer and audience of the exchanged tokens")
+	serverCmd.Flags().StringVar(&flagExchangeSigningKeyFile, "exchange-signing-key-file", "", "PEM encoded private key to sign the exchanged tokens with, a generated key invalidates them on restart")
+	serverCmd.Flags().DurationVar(&flagExchangeTokenTTL, "exchange-token-ttl", exchange.DefaultTokenTTL, "Lifetime of the exchanged tokens")
+	// Publish options.
+	serverCmd.Flags().BoolVar(&flagPublishAPI, "publish-api", false, "Accept module uploads with PUT /v1/modules/:namespace/:name/:provider/:version, use it with authentication")
+	serverCmd.Flags().Int64Var(&flagPublishMaxSize, "publish-max-size", module.DefaultPublishMaxSize, "Maximum size in bytes of the module archives uploaded with the publish API")
+	// Metrics options.
+	serverCmd.Flags().BoolVar(&flagMetrics, "metrics", false, "Serve Prometheus metrics at "+metrics.Path)
+	// Tracing options.
+	serverCmd.Flags().StringVar(&flagTracingOTLPEndpoint, "tracing-otlp-endpoint", "", "host:port of the OTLP/HTTP endpoint to export the spans of the requests, module service calls and S3 calls to")
+	serverCmd.Flags().BoolVar(&flagTracingInsecure, "tracing-insecure", false, "Export the spans over plain HTTP instead of HTTPS")
+	serverCmd.Flags().Float64Var(&flagTracingSampleRatio, "tracing-sample-ratio", 1, "Fraction of the traces started by the registry that are sampled, traces of callers follow their traceparent header")
+	// Rate limit options.
+	serverCmd.Flags().StringVar(&flagRateLimitPolicy, "rate-limit-policy", "", "YAML file with the token bucket limits per identity and IP address of the route groups")
+	// Authorization options.
+	serverCmd.Flags().StringVar(&flagAuthPolicy, "auth-policy", "", "YAML file with the roles granted on namespaces, all authenticated requests are allowed without it")
+	serverCmd.Flags().BoolVar(&flagAuthAnonymousReads, "auth-anonymous-reads", false, "Serve version listings and downloads without authentication, publishing and the admin API still require it, requires an authentication provider")
+	serverCmd.Flags().StringSliceVar(&flagAuthPrivateNamespaces, "auth-private-namespace", nil, "Namespace pattern whose modules require authentication to read, even with --auth-anonymous-reads")
+	// Token auth options.
+	serverCmd.Flags().BoolVar(&flagAuthTokens, "auth-tokens", false, "Accept the API tokens managed with the token command, and serve the admin API at /v1/admin/tokens")
+	serverCmd.Flags().DurationVar(&flagAuthTokenCacheTTL, "auth-token-cache-ttl", time.Minute, "Duration tokens are cached for, revocations by other instances take effect after it")
 	// Static auth options.
 	serverCmd.Flags().StringSliceVar(&flagAuthStaticTokens, "auth-static-token", nil, "Static API token to protect the uncomplicated-registry")
+	// Basic auth options.
+	serverCmd.Flags().StringVar(&flagAuthHtpasswdFile, "auth-htpasswd-file", "", "htpasswd file with bcrypt hashes to accept Basic credentials from, it is read again when it changes")
+	// LDAP auth options.
+	serverCmd.Flags().StringVar(&flagAuthLDAPURL, "auth-ldap-url", "", "ldap:// or ldaps:// URL of the directory to verify Basic credentials against")
+	serverCmd.Flags().StringVar(&flagAuthLDAPBindDN, "auth-ldap-bind-dn", "", "DN of the service account searching the users and groups, they are searched anonymously without it")
+	serverCmd.Flags().StringVar(&flagAuthLDAPBindPassword, "auth-ldap-bind-password", "", "Password of the service account")
+	serverCmd.Flags().StringVar(&flagAuthLDAPUserBaseDN, "auth-ldap-user-base-dn", "", "Base DN the users are searched in")
+	serverCmd.Flags().StringVar(&flagAuthLDAPUserFilter, "auth-ldap-user-filter", auth.DefaultLDAPUserFilter, "Filter the user is searched with, {username} is replaced with the escaped username (e.g. (sAMAccountName={username}))")
+	serverCmd.Flags().StringVar(&flagAuthLDAPEmailAttribute, "auth-ldap-email-attribute", auth.DefaultLDAPEmailAttribute, "Attribute of the user holding the email of the identity")
+	serverCmd.Flags().StringVar(&flagAuthLDAPGroupBaseDN, "auth-ldap-group-base-dn", "", "Base DN the groups of the user are searched in, groups are not resolved without it")
+	serverCmd.Flags().StringVar(&flagAuthLDAPGroupFilter, "auth-ldap-group-filter", auth.DefaultLDAPGroupFilter, "Filter the groups are searched with, {dn} is replaced with the escaped DN of the user")
+	serverCmd.Flags().StringVar(&flagAuthLDAPGroupNameAttribute, "auth-ldap-group-name-attribute", auth.DefaultLDAPGroupNameAttribute, "Attribute of the group holding its name")
+	serverCmd.Flags().BoolVar(&flagAuthLDAPStartTLS, "auth-ldap-start-tls", false, "Upgrade ldap:// connections with StartTLS")
+	serverCmd.Flags().StringVar(&flagAuthLDAPCAFile, "auth-ldap-ca-file", "", "PEM encoded CAs to verify the certificate of the directory with, instead of the system roots")
+	serverCmd.Flags().DurationVar(&flagAuthLDAPCacheTTL, "auth-ldap-cache-ttl", auth.DefaultLDAPCacheTTL, "Duration successful binds are cached for, 0 disables the cache")
+	// JWT auth options.
+	serverCmd.Flags().StringVar(&flagAuthJWTIssuer, "auth-jwt-issuer", "", "Issuer of the accepted JWT bearer tokens, its keys are discovered through /.well-known/openid-configuration")
+	serverCmd.Flags().StringVar(&flagAuthJWTAudience, "auth-jwt-audience", "", "Audience the JWT bearer tokens must be issued for")
+	serverCmd.Flags().StringVar(&flagAuthJWTKeySetFile, "auth-jwt-jwks-file", "", "Local JWKS file to verify the JWT bearer tokens with, instead of discovering the keys of the issuer")
+	serverCmd.Flags().StringVar(&flagAuthJWTSubjectClaim, "auth-jwt-subject-claim", auth.DefaultJWTSubjectClaim, "JWT claim holding the subject of the identity")
+	serverCmd.Flags().StringVar(&flagAuthJWTEmailClaim, "auth-jwt-email-claim", auth.DefaultJWTEmailClaim, "JWT claim holding the email of the identity")
+	serverCmd.Flags().StringVar(&flagAuthJWTGroupsClaim, "auth-jwt-groups-claim", auth.DefaultJWTGroupsClaim, "JWT claim holding the groups of the identity")
 
 	// Terraform Login Protocol options.
 	serverCmd.Flags().StringVar(&flagLoginClient, "login-client", "", "The client_id value to use when making requests")
@@ -139,6 +339,28 @@ func init() {
 	serverCmd.Flags().StringVar(&flagLoginToken, "login-token", "", "The server's token endpoint")
 	serverCmd.Flags().IntSliceVar(&flagLoginPorts, "login-ports", []int{10000, 10010}, "Inclusive range of TCP ports that Terraform may use")
 	serverCmd.Flags().StringSliceVar(&flagLoginScopes, "login-scopes", nil, "List of scopes")
+	// Built-in login server options.
+	serverCmd.Flags().StringVar(&flagLoginServerURL, "login-server-url", "", "Public URL of the registry, enables the built-in authorization server for terraform login")
+	serverCmd.Flags().StringVar(&flagLoginSigningKeyFile, "login-signing-key-file", "", "PEM encoded private key to sign the issued tokens with, a generated key invalidates them on restart")
+	serverCmd.Flags().DurationVar(&flagLoginTokenTTL, "login-token-ttl", login.DefaultTokenTTL, "Lifetime of the tokens issued by the built-in authorization server")
+	serverCmd.Flags().StringVar(&flagLoginOIDCIssuer, "login-oidc-issuer", "", "Issuer of the OpenID Connect provider users log in with")
+	serverCmd.Flags().StringVar(&flagLoginOIDCClientID, "login-oidc-client-id", "", "Client ID of the registry at the OpenID Connect provider")
+	serverCmd.Flags().StringVar(&flagLoginOIDCClientSecret, "login-oidc-client-secret", "", "Client secret of the registry at the OpenID Connect provider")
+	serverCmd.Flags().StringSliceVar(&flagLoginOIDCScopes, "login-oidc-scopes", login.DefaultUpstreamScopes, "Scopes requested from the OpenID Connect provider")
+}
+
+// storageBackend returns the name of the storage configured by the flags, as setupStorage selects it.
+func storageBackend() string {
+	switch {
+	case flagS3Bucket != "":
+		return "s3"
+	case flagOCIRepository != "":
+		return "oci"
+	case flagGitConfig != "":
+		return "git"
+	default:
+		return "inmem"
+	}
 }
 
 func setupStorage(ctx context.Context) (storage.Storage, error) {
@@ -150,75 +372,266 @@ func setupStorage(ctx context.Context) (storage.Storage, error) {
 			storage.WithS3StorageBucketRegion(flagS3Region),
 			storage.WithS3StorageBucketEndpoint(flagS3Endpoint),
 			storage.WithS3StoragePathStyle(flagS3PathStyle),
+			storage.WithS3StorageKeyLayout(flagS3KeyLayout),
 			storage.WithS3ArchiveFormat(storage.DefaultModuleArchiveFormat),
 			storage.WithS3StorageSignedUrlExpiry(flagS3SignedURLExpiry),
+			storage.WithS3StorageContentAddressed(flagS3ContentAddressed),
+			storage.WithS3StorageDownloadPrefix(prefixModules),
+			storage.WithS3StorageProfile(flagS3Profile),
+			storage.WithS3StorageStaticCredentials(flagS3AccessKeyID, flagS3SecretAccessKey, flagS3SessionToken),
+			storage.WithS3StorageAssumeRole(flagS3RoleARN, flagS3RoleExternalID, flagS3RoleSessionName),
+			storage.WithS3StoragePresignAssumeRole(flagS3PresignRoleARN, flagS3PresignRoleExternalID, flagS3PresignRoleSessionName),
+			storage.WithS3StorageMaxRetries(flagS3MaxRetries),
+			storage.WithS3StorageTimeout(flagS3Timeout),
+			storage.WithS3StorageCABundle(flagS3CABundle),
+			storage.WithS3StorageServerSideEncryption(flagS3SSE, flagS3SSEKMSKeyID),
+			storage.WithS3StorageSSECustomerKey(flagS3SSECustomerKey),
+			storage.WithS3StorageObjectTags(flagS3ObjectTags),
+			storage.WithS3StorageStorageClass(flagS3StorageClass),
+			storage.WithS3StorageACL(flagS3ACL),
+			storage.WithS3StorageTracerProvider(tracerProvider),
+			storage.WithS3StorageLogger(logger.With().Str("component", "storage").Logger()),
 		)
+	case flagOCIRepository != "":
+		options := []storage.OCIStorageOption{
+			storage.WithOCIStorageInsecure(flagOCIInsecure),
+			storage.WithOCIStorageBasicAuth(flagOCIUsername, flagOCIPassword),
+		}
+		if flagOCIProxyDownloads {
+			options = append(options, storage.WithOCIStorageDownloadPrefix(prefixModules))
+		}
+		return storage.NewOCIStorage(flagOCIRepository, options...)
+	case flagGitConfig != "":
+		config, err := storage.LoadGitConfig(flagGitConfig)
+		if err != nil {
+			return nil, err
+		}
+		return storage.NewGitStorage(config,
+			storage.WithGitStorageArchiveFormat(storage.DefaultModuleArchiveFormat),
+			storage.WithGitStorageDownloadPrefix(prefixModules),
+			storage.WithGitStorageCacheSize(flagGitCacheSize),
+		)
+	case flagInmem:
+		s := module.NewInmemStorage(
+			module.WithInmemArchiveFormat(storage.DefaultModuleArchiveFormat),
+			module.WithInmemDownloadPrefix(prefixModules),
+			module.WithInmemSnapshotPath(flagInmemSnapshot),
+		)
+		if err := s.Restore(); err != nil {
+			return nil, err
+		}
+		return s, nil
 	default:
 		return nil, errors.New("please specify a valid storage provider")
 	}
 }
 
-func createFiber(logger zerolog.Logger) (*fiber.App, error) {
-	app := fiber.New()
+func createFiber(logger zerolog.Logger, s storage.Storage, auditor *audit.Auditor, m *metrics.Metrics, serviceOptions ...module.ServiceOption) (*fiber.App, error) {
+	app := fiber.New(fiber.Config{
+		// The client IP address limits requests, it is only taken from the header when set by a trusted proxy
+		ProxyHeader:             flagProxyHeader,
+		EnableTrustedProxyCheck: flagProxies != nil,
+		TrustedProxies:          flagProxies,
+		// Bodies above the body limit are streamed, so published archives are not held in memory
+		StreamRequestBody: true,
+	})
+
+	// Started first, so the span covers the whole request
+	if tracerProvider != nil {
+		app.Use(tracing.Middleware(tracerProvider))
+	}
 
 	app.Use(recover.New())
+	app.Use(limitBodies)
+
+	// Measures the requests including the time spent compressing the responses
+	var moduleStorage module.Storage = s
+	var authFailureHook auth.FailureHook
+	if m != nil {
+		app.Use(m.Middleware)
+		app.Get(metrics.Path, m.Handler())
+		moduleStorage = m.Storage(s, storageBackend())
+		authFailureHook = m.RecordAuthFailure
+	}
+
 	app.Use(etag.New())
 	app.Use(compress.New(compress.Config{
 		Level: compress.LevelBestSpeed,
 	}))
 
 	app.Use(fiberzerolog.New(fiberzerolog.Config{
-		Logger: &logger,
+		// Called after the request is handled, when the identity of the request is known
+		GetLogger: func(c *fiber.Ctx) zerolog.Logger {
+			if identity, ok := auth.IdentityFromContext(c); ok {
+				return logger.With().Object("identity", identity).Logger()
+			}
+			return logger
+		},
 	}))
 
-	if err := registerDiscovery(app); err != nil {
+	var loginServer *login.Server
+	if flagLoginServerURL != "" {
+		var err error
+		if loginServer, err = setupLogin(logger); err != nil {
+			return nil, errors.Wrap(err, "failed to setup login server")
+		}
+		login.Register(loginServer, app)
+	}
+
+	if err := registerDiscovery(app, loginServer); err != nil {
 		return nil, err
 	}
 
-	s, err := setupStorage(context.TODO())
+	var exchangeServer *exchange.Server
+	if flagExchangePolicy != "" {
+		var err error
+		if exchangeServer, err = setupExchange(logger); err != nil {
+			return nil, errors.Wrap(err, "failed to setup token exchange")
+		}
+		var exchangeMiddleware []fiber.Handler
+		if auditor != nil {
+			exchangeMiddleware = append(exchangeMiddleware, audit.Middleware(auditor))
+		}
+		exchange.Register(exchangeServer, app, exchangeMiddleware...)
+	}
+
+	var tokens token.Store
+	if flagAuthTokens {
+		store, err := setupTokenStore(context.Background())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to setup token store")
+		}
+		tokens = token.NewCachedStore(store, flagAuthTokenCacheTTL)
+	}
+
+	middleware, err := authMiddleware(logger, loginServer, exchangeServer, tokens, authFailureHook)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := registerModule(app, s); err != nil {
+	var limiter *ratelimit.Limiter
+	if flagRateLimitPolicy != "" {
+		policy, err := ratelimit.LoadPolicy(flagRateLimitPolicy)
+		if err != nil {
+			return nil, err
+		}
+		limiter = ratelimit.NewLimiter(logger, policy)
+	}
+
+	if err := registerModule(app, moduleStorage, middleware, limiter, auditor, serviceOptions...); err != nil {
 		return nil, err
 	}
 
+	if tokens != nil {
+		var adminMiddleware []fiber.Handler
+		if auditor != nil {
+			adminMiddleware = append(adminMiddleware, audit.Middleware(auditor))
+		}
+		if limiter != nil {
+			adminMiddleware = append(adminMiddleware, limiter.IPHandler)
+		}
+		adminMiddleware = append(adminMiddleware, middleware)
+		if limiter != nil {
+			adminMiddleware = append(adminMiddleware, limiter.IdentityHandler)
+		}
+		adminMiddleware = append(adminMiddleware, auth.RequireScope(logger, auth.ScopeAdmin))
+		token.Register(tokens, app.Group(prefixAdmin), adminMiddleware...)
+	}
+
 	return app, nil
 }
 
-func registerDiscovery(app *fiber.App) error {
+// limitBodies rejects bodies above the body limit, except for the publish API. As bodies are streamed,
+// the routes would read them into memory whatever their size.
+func limitBodies(c *fiber.Ctx) error {
+	if flagPublishAPI && c.Method() == fiber.MethodPut && strings.HasPrefix(c.Path(), prefixModules+"/") {
+		return c.Next()
+	}
+
+	// The rejected bodies are left unread, their connection cannot be reused
+	switch length := c.Request().Header.ContentLength(); {
+	case length == -1:
+		c.Context().SetConnectionClose()
+		return fiber.ErrLengthRequired
+	case length > fiber.DefaultBodyLimit:
+		c.Context().SetConnectionClose()
+		return fiber.ErrRequestEntityTooLarge
+	}
+
+	return c.Next()
+}
+
+func setupLogin(logger zerolog.Logger) (*login.Server, error) {
+	if flagLoginSigningKeyFile == "" {
+		logger.Warn().Msg("no login signing key configured, issued tokens are invalidated on restart")
+	}
+
+	// The upstream keys are refreshed for the lifetime of the server
+	return login.NewServer(context.Background(), logger, flagLoginServerURL,
+		login.WithClientID(flagLoginClient),
+		login.WithPorts(flagLoginPorts),
+		login.WithTokenTTL(flagLoginTokenTTL),
+		login.WithSigningKeyFile(flagLoginSigningKeyFile),
+		login.WithUpstream(flagLoginOIDCIssuer, flagLoginOIDCClientID, flagLoginOIDCClientSecret, flagLoginOIDCScopes...),
+	)
+}
+
+func setupExchange(logger zerolog.Logger) (*exchange.Server, error) {
+	policy, err := exchange.LoadPolicy(flagExchangePolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	if flagExchangeSigningKeyFile == "" {
+		logger.Warn().Msg("no exchange signing key configured, exchanged tokens are invalidated on restart")
+	}
+
+	// The keys of the CI issuers are refreshed for the lifetime of the server
+	return exchange.NewServer(context.Background(), logger, flagExchangeURL, policy,
+		exchange.WithTokenTTL(flagExchangeTokenTTL),
+		exchange.WithSigningKeyFile(flagExchangeSigningKeyFile),
+	)
+}
+
+func registerDiscovery(app *fiber.App, loginServer *login.Server) error {
 
 	options := []discovery.Option{
 		discovery.WithModulesV1(fmt.Sprintf("%s/", prefixModules)),
 	}
 
-	if flagLoginClient != "" {
-		login := &discovery.LoginV1{
+	if flagLoginClient != "" || loginServer != nil {
+		loginV1 := &discovery.LoginV1{
 			Client: flagLoginClient,
 		}
 
+		// The built-in authorization server provides the defaults, the flags can still point elsewhere
+		if loginServer != nil {
+			loginV1.Client = loginServer.ClientID()
+			loginV1.Authz = loginServer.AuthorizationURL()
+			loginV1.Token = loginServer.TokenURL()
+		}
+
 		if flagLoginGrantTypes != nil {
-			login.GrantTypes = flagLoginGrantTypes
+			loginV1.GrantTypes = flagLoginGrantTypes
 		}
 
 		if flagLoginAuthz != "" {
-			login.Authz = flagLoginAuthz
+			loginV1.Authz = flagLoginAuthz
 		}
 
 		if flagLoginToken != "" {
-			login.Token = flagLoginToken
+			loginV1.Token = flagLoginToken
 		}
 
 		if flagLoginPorts != nil {
-			login.Ports = flagLoginPorts
+			loginV1.Ports = flagLoginPorts
 		}
 
 		if flagLoginScopes != nil {
-			login.Scopes = flagLoginScopes
+			loginV1.Scopes = flagLoginScopes
 		}
 
-		options = append(options, discovery.WithLoginV1(login))
+		options = append(options, discovery.WithLoginV1(loginV1))
 	}
 
 	discovery := discovery.New(options...)
@@ -236,23 +649,171 @@ func registerDiscovery(app *fiber.App) error {
 	return nil
 }
 
-func registerModule(app *fiber.App, s storage.Storage) error {
-	service := module.NewService(s)
+func registerModule(app *fiber.App, s module.Storage, middleware fiber.Handler, limiter *ratelimit.Limiter, auditor *audit.Auditor, options ...module.ServiceOption) error {
+	service := module.NewService(s, options...)
+	if tracerProvider != nil {
+		service = tracing.Service(service, tracerProvider)
+	}
+
+	// The middleware needs the parameters and names of the routes, so it runs with the routes instead of the group
+	var routeMiddleware []fiber.Handler
 
-	api := app.Group(prefixModules)
-	api.Use(authMiddleware(logger))
+	// Audited in front of the authentication, so denied requests are recorded as well
+	if auditor != nil {
+		routeMiddleware = append(routeMiddleware, audit.Middleware(auditor))
+	}
 
-	module.Register(service, api)
+	// IP addresses are limited in front of the authentication, so failed authentications are limited as well
+	if limiter != nil {
+		routeMiddleware = append(routeMiddleware, limiter.IPHandler)
+	}
+
+	if flagAuthAnonymousReads {
+		routeMiddleware = append(routeMiddleware, auth.AnonymousReads(middleware, flagAuthPrivateNamespaces))
+	} else {
+		routeMiddleware = append(routeMiddleware, middleware)
+	}
+
+	// Identities are limited once they are known
+	if limiter != nil {
+		routeMiddleware = append(routeMiddleware, limiter.IdentityHandler)
+	}
+
+	if flagAuthPolicy != "" {
+		policy, err := auth.LoadPolicy(flagAuthPolicy)
+		if err != nil {
+			return err
+		}
+		routeMiddleware = append(routeMiddleware, auth.Authorize(logger, policy))
+	} else if flagAuthTokens || flagExchangePolicy != "" {
+		// Without a policy every identity has write access, restricted by the scopes and namespaces of its token
+		routeMiddleware = append(routeMiddleware, auth.Authorize(logger, auth.Policy{Default: auth.RoleWrite}))
+	}
+
+	api := app.Group(prefixModules)
+	module.Register(service, api, routeMiddleware...)
+	if flagPublishAPI {
+		module.RegisterPublish(service, api, flagPublishMaxSize, routeMiddleware...)
+	}
 
 	return nil
 }
 
-func authMiddleware(logger zerolog.Logger) func(c *fiber.Ctx) error {
+func authMiddleware(logger zerolog.Logger, loginServer *login.Server, exchangeServer *exchange.Server, tokens token.Store, failureHook auth.FailureHook) (fiber.Handler, error) {
 	var providers []auth.Provider
 
+	if flagTLSClientCAFile != "" {
+		provider, err := auth.NewClientCertProvider(auth.WithClientCertSubject(flagAuthClientCertSubject))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to setup client certificate auth")
+		}
+		providers = append(providers, provider)
+	}
+
 	if flagAuthStaticTokens != nil {
 		providers = append(providers, auth.NewStaticProvider(logger, flagAuthStaticTokens...))
 	}
 
-	return auth.Middleware(logger, providers...)
+	if flagAuthHtpasswdFile != "" {
+		provider, err := auth.NewHtpasswdProvider(logger, flagAuthHtpasswdFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to setup htpasswd auth")
+		}
+		providers = append(providers, provider)
+	}
+
+	if flagAuthLDAPURL != "" {
+		provider, err := auth.NewLDAPProvider(flagAuthLDAPURL,
+			auth.WithLDAPBind(flagAuthLDAPBindDN, flagAuthLDAPBindPassword),
+			auth.WithLDAPUserSearch(flagAuthLDAPUserBaseDN, flagAuthLDAPUserFilter),
+			auth.WithLDAPEmailAttribute(flagAuthLDAPEmailAttribute),
+			auth.WithLDAPGroupSearch(flagAuthLDAPGroupBaseDN, flagAuthLDAPGroupFilter, flagAuthLDAPGroupNameAttribute),
+			auth.WithLDAPStartTLS(flagAuthLDAPStartTLS),
+			auth.WithLDAPCAFile(flagAuthLDAPCAFile),
+			auth.WithLDAPCacheTTL(flagAuthLDAPCacheTTL),
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to setup ldap auth")
+		}
+		providers = append(providers, provider)
+	}
+
+	if flagAuthJWTIssuer != "" {
+		// The key set is refreshed for the lifetime of the server
+		provider, err := auth.NewJWTProvider(context.Background(), flagAuthJWTIssuer, flagAuthJWTAudience,
+			auth.WithJWTKeySetFile(flagAuthJWTKeySetFile),
+			auth.WithJWTClaims(flagAuthJWTSubjectClaim, flagAuthJWTEmailClaim, flagAuthJWTGroupsClaim),
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to setup jwt auth")
+		}
+		providers = append(providers, provider)
+	}
+
+	if loginServer != nil {
+		provider, err := loginServer.Provider(context.Background())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to setup login auth")
+		}
+		providers = append(providers, provider)
+	}
+
+	if exchangeServer != nil {
+		provider, err := exchangeServer.Provider(context.Background())
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to setup exchange auth")
+		}
+		providers = append(providers, provider)
+	}
+
+	if tokens != nil {
+		providers = append(providers, token.NewProvider(tokens))
+	}
+
+	// Without providers the middleware lets every request pass, writes must never be anonymous
+	if flagAuthAnonymousReads && len(providers) == 0 {
+		return nil, errors.New("--auth-anonymous-reads requires an authentication provider for the writes")
+	}
+
+	return auth.MiddlewareWithFailureHook(logger, failureHook, providers...), nil
+}
+
+// listenMutualTLS serves HTTPS and verifies the client certificates against the --tls-client-ca-file.
+// Unlike fiber.App.ListenMutualTLS, clients without a certificate can still connect unless it is required.
+func listenMutualTLS(server *fiber.App) error {
+	if flagTLSCertFile == "" || flagTLSKeyFile == "" {
+		return errors.New("--tls-client-ca-file requires --tls-cert-file and --tls-key-file")
+	}
+
+	cert, err := tls.LoadX509KeyPair(flagTLSCertFile, flagTLSKeyFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to load TLS key pair")
+	}
+
+	bundle, err := os.ReadFile(flagTLSClientCAFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to read client CA bundle")
+	}
+
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(bundle) {
+		return errors.Errorf("no certificates found in client CA bundle: %s", flagTLSClientCAFile)
+	}
+
+	clientAuth := tls.VerifyClientCertIfGiven
+	if flagTLSClientCertRequired {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	ln, err := tls.Listen("tcp", flagListenAddr, &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuth,
+		ClientCAs:    clientCAs,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to listen")
+	}
+
+	return server.Listener(ln)
 }
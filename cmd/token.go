@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/storage"
+	"github.com/MichielBijland/uncomplicated-registry/internal/token"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Token store options.
+	flagAuthTokens        bool
+	flagAuthTokenFile     string
+	flagAuthTokenCacheTTL time.Duration
+
+	// Token create options.
+	flagTokenName       string
+	flagTokenOwner      string
+	flagTokenScopes     []string
+	flagTokenNamespaces []string
+	flagTokenExpiresIn  time.Duration
+)
+
+var tokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manages the API tokens of the registry",
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:          "create",
+	Short:        "Creates an API token and prints its secret",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+
+		store, err := setupTokenStore(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, secret, err := token.Create(ctx, store, token.Options{
+			Name:       flagTokenName,
+			Owner:      flagTokenOwner,
+			Scopes:     flagTokenScopes,
+			Namespaces: flagTokenNamespaces,
+			ExpiresIn:  flagTokenExpiresIn,
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to create token")
+		}
+
+		fmt.Println(secret)
+
+		return nil
+	},
+}
+
+var tokenListCmd = &cobra.Command{
+	Use:          "list",
+	Short:        "Lists the API tokens",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+
+		store, err := setupTokenStore(ctx)
+		if err != nil {
+			return err
+		}
+
+		tokens, err := store.List(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to list tokens")
+		}
+
+		now := time.Now()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tOWNER\tSCOPES\tNAMESPACES\tEXPIRES\tSTATUS")
+		for _, t := range tokens {
+			status := "active"
+			switch {
+			case t.RevokedAt != nil:
+				status = "revoked"
+			case !t.Active(now):
+				status = "expired"
+			}
+
+			expires := "never"
+			if t.ExpiresAt != nil {
+				expires = t.ExpiresAt.Format(time.RFC3339)
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", t.ID, t.Name, t.Owner,
+				strings.Join(t.Scopes, ","), strings.Join(t.Namespaces, ","), expires, status)
+		}
+
+		return w.Flush()
+	},
+}
+
+var tokenRevokeCmd = &cobra.Command{
+	Use:          "revoke ID",
+	Short:        "Revokes an API token",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+
+		store, err := setupTokenStore(ctx)
+		if err != nil {
+			return err
+		}
+
+		if _, err := token.Revoke(ctx, store, args[0]); err != nil {
+			return errors.Wrap(err, "failed to revoke token")
+		}
+
+		logger.Info().Str("id", args[0]).Msg("token revoked")
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tokenCmd)
+	tokenCmd.AddCommand(tokenCreateCmd, tokenListCmd, tokenRevokeCmd)
+
+	rootCmd.PersistentFlags().StringVar(&flagAuthTokenFile, "auth-token-file", "", "JSON file to keep the API tokens in, instead of the storage backend")
+
+	tokenCreateCmd.Flags().StringVar(&flagTokenName, "name", "", "Name of the token")
+	tokenCreateCmd.MarkFlagRequired("name")
+	tokenCreateCmd.Flags().StringVar(&flagTokenOwner, "owner", "", "Owner the requests made with the token are attributed to")
+	tokenCreateCmd.Flags().StringSliceVar(&flagTokenScopes, "scope", nil, fmt.Sprintf("Scopes granted to the token (%s), read and write access without it", strings.Join(token.Scopes, ", ")))
+	tokenCreateCmd.Flags().StringSliceVar(&flagTokenNamespaces, "namespace", nil, "Namespace patterns the token is restricted to, all namespaces without it")
+	tokenCreateCmd.Flags().DurationVar(&flagTokenExpiresIn, "expires-in", 0, "Lifetime of the token, it never expires without it")
+}
+
+// setupTokenStore returns the token.Store of the --auth-token-file, or of the storage backend.
+func setupTokenStore(ctx context.Context) (token.Store, error) {
+	if flagAuthTokenFile != "" {
+		return token.NewFileStore(flagAuthTokenFile), nil
+	}
+
+	s, err := setupStorage(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to setup storage")
+	}
+
+	metadata, ok := s.(storage.MetadataStorage)
+	if !ok {
+		return nil, errors.New("the storage backend cannot keep tokens, please specify --auth-token-file")
+	}
+
+	return token.NewMetadataStore(metadata), nil
+}
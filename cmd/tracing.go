@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/tracing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// Tracing options.
+	flagTracingOTLPEndpoint string
+	flagTracingInsecure     bool
+	flagTracingSampleRatio  float64
+)
+
+// tracerProvider records the spans of the server, it is nil when tracing is disabled.
+var tracerProvider trace.TracerProvider
+
+// setupTracing sets up the tracer provider exporting to the configured OTLP endpoint, or nil without an endpoint.
+func setupTracing(ctx context.Context) (*sdktrace.TracerProvider, error) {
+	if flagTracingOTLPEndpoint == "" {
+		return nil, nil
+	}
+
+	provider, err := tracing.NewTracerProvider(ctx, flagTracingOTLPEndpoint,
+		tracing.WithInsecure(flagTracingInsecure),
+		tracing.WithSampleRatio(flagTracingSampleRatio),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	tracerProvider = provider
+	return provider, nil
+}
@@ -6,7 +6,9 @@ import (
 	"os"
 	"regexp"
 
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
 	"github.com/MichielBijland/uncomplicated-registry/internal/module"
+	"github.com/MichielBijland/uncomplicated-registry/internal/utils"
 	"github.com/hashicorp/go-version"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -19,6 +21,14 @@ var (
 	flagModuleVersion            string
 	flagVersionConstraintsRegex  string
 	flagVersionConstraintsSemver string
+	flagArchiveFormat            string
+
+	// Publish metadata.
+	flagPublisher string
+	flagSource    string
+	flagCommit    string
+	flagCIRunURL  string
+	flagLabels    map[string]string
 )
 
 var (
@@ -41,6 +51,12 @@ Can be combined with the -version-constraints-semver flag`)
 	uploadCmd.Flags().StringVar(&flagVersionConstraintsSemver, "version-constraints-semver", "", `Limit the module versions that are eligible for upload with version constraints.
 The version string has to be formatted as a string literal containing one or more conditions, which are separated by commas.
 Can be combined with the -version-constrained-regex flag`)
+	uploadCmd.Flags().StringVar(&flagArchiveFormat, "archive-format", utils.ArchiveFormatTarGz, "Archive format of the uploaded module (tar.gz, tgz or zip)")
+	uploadCmd.Flags().StringVar(&flagPublisher, "publisher", "", "Identity of the publisher, stored with the module")
+	uploadCmd.Flags().StringVar(&flagSource, "source", "", "Source repository of the module, stored with the module")
+	uploadCmd.Flags().StringVar(&flagCommit, "commit", "", "Commit SHA the module was built from, stored with the module")
+	uploadCmd.Flags().StringVar(&flagCIRunURL, "ci-run-url", "", "URL of the CI run publishing the module, stored with the module")
+	uploadCmd.Flags().StringToStringVar(&flagLabels, "label", nil, "Labels stored with the module (key=value pairs)")
 }
 
 var uploadCmd = &cobra.Command{
@@ -94,5 +110,13 @@ func uploadModule(cmd *cobra.Command, args []string) error {
 		versionConstraintsRegex = constraints
 	}
 
-	return archiveModules(args[0], metadata, storageBackend)
+	publish := core.PublishMetadata{
+		Publisher: flagPublisher,
+		Source:    flagSource,
+		Commit:    flagCommit,
+		CIRunURL:  flagCIRunURL,
+		Labels:    flagLabels,
+	}
+
+	return archiveModules(args[0], metadata, publish, storageBackend)
 }
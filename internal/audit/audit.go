@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/rs/zerolog"
+)
+
+// Actions of the audited registry operations, the audited routes are named by their action.
+const (
+	ActionList     = "module.list"
+	ActionGet      = "module.get"
+	ActionDownload = "module.download"
+	ActionArchive  = "module.archive"
+	ActionPublish  = "module.publish"
+	ActionDelete   = "module.delete"
+
+	ActionTokenList     = "token.list"
+	ActionTokenCreate   = "token.create"
+	ActionTokenRevoke   = "token.revoke"
+	ActionTokenExchange = "token.exchange"
+)
+
+// Results of the audited operations.
+const (
+	ResultSuccess = "success"
+	// ResultDenied is the result of unauthenticated, unauthorized and rate limited requests.
+	ResultDenied  = "denied"
+	ResultFailure = "failure"
+)
+
+// Event records who performed an operation on which module, and how it ended.
+type Event struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	// Module is the core.Module ID, with the version for operations on a version.
+	Module string `json:"module,omitempty"`
+	// Token is the ID of the API token an admin operation acts on.
+	Token string `json:"token,omitempty"`
+	// Identity is the name of the authenticated identity, empty for anonymous requests.
+	Identity string `json:"identity,omitempty"`
+	Provider string `json:"provider,omitempty"`
+	TokenID  string `json:"token_id,omitempty"`
+	Result   string `json:"result"`
+	Status   int    `json:"status,omitempty"`
+	// Reason explains the result, e.g. the retention rule deleting a version.
+	Reason    string `json:"reason,omitempty"`
+	SourceIP  string `json:"source_ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// Sink persists audit events.
+type Sink interface {
+	Write(ctx context.Context, event Event) error
+	// Close flushes the buffered events.
+	Close() error
+}
+
+// Auditor emits events to all of its sinks. Failing sinks are logged, they never fail the operation.
+type Auditor struct {
+	logger zerolog.Logger
+	sinks  []Sink
+	now    func() time.Time
+}
+
+// New returns an Auditor emitting to the sinks.
+func New(logger zerolog.Logger, sinks ...Sink) *Auditor {
+	return &Auditor{
+		logger: logger,
+		sinks:  sinks,
+		now:    time.Now,
+	}
+}
+
+// Record emits the event, its time is set when it is empty.
+func (a *Auditor) Record(ctx context.Context, event Event) {
+	if event.Time.IsZero() {
+		event.Time = a.now().UTC()
+	}
+
+	for _, sink := range a.sinks {
+		if err := sink.Write(ctx, event); err != nil {
+			a.logger.Error().Err(err).Str("action", event.Action).Str("module", event.Module).Msg("failed to write audit event")
+		}
+	}
+}
+
+// Close closes all sinks.
+func (a *Auditor) Close() error {
+	var result *multierror.Error
+	for _, sink := range a.sinks {
+		if err := sink.Close(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	return result.ErrorOrNil()
+}
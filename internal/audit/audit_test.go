@@ -0,0 +1,264 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/auth"
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+	"github.com/MichielBijland/uncomplicated-registry/internal/module"
+	"github.com/MichielBijland/uncomplicated-registry/internal/storage"
+	"github.com/MichielBijland/uncomplicated-registry/internal/token"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryMetadata is a storage.MetadataStorage keeping the data in memory.
+type memoryMetadata struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func (m *memoryMetadata) GetMetadata(_ context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, ok := m.data[key]
+	if !ok {
+		return nil, storage.ErrMetadataNotFound
+	}
+
+	return data, nil
+}
+
+func (m *memoryMetadata) PutMetadata(_ context.Context, key string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.data[key] = data
+	return nil
+}
+
+func (m *memoryMetadata) ListMetadata(_ context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []string
+	for key := range m.data {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	auditor := New(zerolog.Nop(), NewWriterSink(&buf))
+
+	s := module.NewInmemStorage()
+	_, err := s.UploadModule(ctx, "acme", "vpc", "aws", "1.0.0", bytes.NewBufferString("data"), core.PublishMetadata{})
+	require.NoError(t, err)
+
+	tokens := token.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	revoked, _, err := token.Create(ctx, tokens, token.Options{Name: "ci", Scopes: []string{auth.ScopeWrite}})
+	require.NoError(t, err)
+
+	authenticate := auth.Middleware(zerolog.Nop(), auth.NewStaticProvider(zerolog.Nop(), "static"))
+
+	app := fiber.New()
+	svc := module.NewService(s)
+	module.Register(svc, app.Group("/v1/modules"), Middleware(auditor), authenticate)
+	module.RegisterPublish(svc, app.Group("/v1/modules"), module.DefaultPublishMaxSize, Middleware(auditor), authenticate)
+	token.Register(tokens, app.Group("/v1/admin"), Middleware(auditor), authenticate)
+	app.Post("/v1/exchange/token", Middleware(auditor), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusBadRequest)
+	}).Name(ActionTokenExchange)
+	app.Get("/v1/unnamed", Middleware(auditor), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	testCases := []struct {
+		method   string
+		path     string
+		token    string
+		expected Event
+	}{
+		{
+			method:   http.MethodGet,
+			path:     "/v1/modules/acme/vpc/aws/versions",
+			token:    "static",
+			expected: Event{Action: ActionList, Module: "acme/vpc/aws", Identity: "static:2053dbbf", Provider: "static", TokenID: "2053dbbf", Result: ResultSuccess, Status: http.StatusOK},
+		},
+		{
+			method:   http.MethodGet,
+			path:     "/v1/modules/acme/vpc/aws/2.0.0/download",
+			token:    "static",
+			expected: Event{Action: ActionDownload, Module: "acme/vpc/aws/2.0.0", Identity: "static:2053dbbf", Provider: "static", TokenID: "2053dbbf", Result: ResultFailure, Status: http.StatusNotFound},
+		},
+		{
+			method:   http.MethodPut,
+			path:     "/v1/modules/acme/vpc/aws/1.0.0",
+			token:    "invalid",
+			expected: Event{Action: ActionPublish, Module: "acme/vpc/aws/1.0.0", Result: ResultDenied, Status: http.StatusUnauthorized},
+		},
+		{
+			method:   http.MethodPost,
+			path:     "/v1/admin/tokens",
+			token:    "invalid",
+			expected: Event{Action: ActionTokenCreate, Result: ResultDenied, Status: http.StatusUnauthorized},
+		},
+		{
+			method:   http.MethodDelete,
+			path:     "/v1/admin/tokens/" + revoked.ID,
+			token:    "static",
+			expected: Event{Action: ActionTokenRevoke, Token: revoked.ID, Identity: "static:2053dbbf", Provider: "static", TokenID: "2053dbbf", Result: ResultSuccess, Status: http.StatusOK},
+		},
+		{
+			method:   http.MethodPost,
+			path:     "/v1/exchange/token",
+			expected: Event{Action: ActionTokenExchange, Result: ResultFailure, Status: http.StatusBadRequest},
+		},
+	}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.token != "" {
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+tc.token)
+		}
+		req.Header.Set(fiber.HeaderUserAgent, "Terraform/1.5.0")
+
+		_, err := app.Test(req)
+		require.NoError(t, err)
+	}
+
+	// Requests to unnamed routes are not recorded
+	_, err = app.Test(httptest.NewRequest(http.MethodGet, "/v1/unnamed", nil))
+	require.NoError(t, err)
+
+	events, err := Query(&buf, Filter{})
+	require.NoError(t, err)
+	require.Len(t, events, len(testCases))
+
+	for i, event := range events {
+		assert.False(t, event.Time.IsZero())
+		assert.Equal(t, "0.0.0.0", event.SourceIP)
+		assert.Equal(t, "Terraform/1.5.0", event.UserAgent)
+
+		event.Time, event.SourceIP, event.UserAgent = time.Time{}, "", ""
+		assert.Equal(t, testCases[i].expected, event)
+	}
+}
+
+func TestStorageSink(t *testing.T) {
+	t.Parallel()
+
+	metadata := &memoryMetadata{data: make(map[string][]byte)}
+	sink := NewStorageSink(metadata, zerolog.Nop(), WithFlushInterval(time.Hour))
+
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	sink.now = func() time.Time { return now }
+
+	auditor := New(zerolog.Nop(), sink)
+	auditor.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	auditor.Record(ctx, Event{Action: ActionPublish, Module: "acme/vpc/aws/1.0.0", Identity: "ci@example.com", Result: ResultSuccess})
+	auditor.Record(ctx, Event{Action: ActionDownload, Module: "acme/vpc/aws/1.0.0", Identity: "alice@example.com", Result: ResultSuccess})
+	require.NoError(t, sink.Flush(ctx))
+
+	// Every flush appends an object, earlier objects are never rewritten
+	now = now.Add(24 * time.Hour)
+	auditor.Record(ctx, Event{Action: ActionDownload, Module: "acme/eks/aws/2.0.0", Identity: "alice@example.com", Result: ResultSuccess})
+	require.NoError(t, sink.Close())
+
+	keys, err := metadata.ListMetadata(ctx, "")
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.True(t, strings.HasPrefix(keys[0], "audit/2023/06/01/120000.000000000-"))
+	assert.True(t, strings.HasPrefix(keys[1], "audit/2023/06/02/120000.000000000-"))
+
+	testCases := []struct {
+		annotation string
+		filter     Filter
+		expected   []string
+	}{
+		{
+			annotation: "all",
+			expected:   []string{"acme/vpc/aws/1.0.0", "acme/vpc/aws/1.0.0", "acme/eks/aws/2.0.0"},
+		},
+		{
+			annotation: "module",
+			filter:     Filter{Module: "acme/vpc/aws"},
+			expected:   []string{"acme/vpc/aws/1.0.0", "acme/vpc/aws/1.0.0"},
+		},
+		{
+			annotation: "module pattern",
+			filter:     Filter{Module: "acme/*/aws/2.*"},
+			expected:   []string{"acme/eks/aws/2.0.0"},
+		},
+		{
+			annotation: "identity and action",
+			filter:     Filter{Identity: "alice@*", Action: ActionDownload},
+			expected:   []string{"acme/vpc/aws/1.0.0", "acme/eks/aws/2.0.0"},
+		},
+		{
+			annotation: "since",
+			filter:     Filter{Since: now},
+			expected:   []string{"acme/eks/aws/2.0.0"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			events, err := QueryStorage(context.Background(), metadata, tc.filter)
+			require.NoError(t, err)
+
+			var modules []string
+			for _, event := range events {
+				modules = append(modules, event.Module)
+			}
+			assert.Equal(t, tc.expected, modules)
+		})
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	// Restarts append to the existing log
+	for i := 0; i < 2; i++ {
+		sink, err := NewFileSink(file)
+		require.NoError(t, err)
+
+		New(zerolog.Nop(), sink).Record(context.Background(), Event{Action: ActionDownload, Module: "acme/vpc/aws/1.0.0", Result: ResultSuccess})
+		require.NoError(t, sink.Close())
+	}
+
+	events, err := QueryFile(file, Filter{Module: "acme/vpc/aws/1.0.0"})
+	require.NoError(t, err)
+	assert.Len(t, events, 2)
+}
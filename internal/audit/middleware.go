@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"github.com/MichielBijland/uncomplicated-registry/internal/auth"
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/pkg/errors"
+)
+
+// Middleware records the requests to the named routes, the name of a route is its action, e.g. module.publish.
+// It runs as the first handler of the routes, in front of the authentication, so requests that are denied
+// are recorded as well.
+func Middleware(auditor *Auditor) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		action := c.Route().Name
+		if action == "" {
+			return c.Next()
+		}
+
+		// Fiber reuses the request buffers, the sinks may buffer the event
+		event := Event{
+			Action:    action,
+			Module:    routeModule(c),
+			Token:     utils.CopyString(c.Params("id")),
+			SourceIP:  utils.CopyString(c.IP()),
+			UserAgent: utils.CopyString(c.Get(fiber.HeaderUserAgent)),
+		}
+
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		var fiberErr *fiber.Error
+		if errors.As(err, &fiberErr) {
+			status = fiberErr.Code
+		} else if err != nil {
+			status = fiber.StatusInternalServerError
+		}
+
+		event.Result = result(status)
+		event.Status = status
+
+		if identity, ok := auth.IdentityFromContext(c); ok {
+			event.Identity = identity.Name()
+			event.Provider = identity.Provider
+			event.TokenID = identity.TokenID
+		}
+
+		auditor.Record(c.UserContext(), event)
+
+		return err
+	}
+}
+
+// routeModule returns the module ID of the route parameters, empty for routes without a module.
+func routeModule(c *fiber.Ctx) string {
+	if c.Params("namespace") == "" {
+		return ""
+	}
+
+	m := core.Module{
+		Namespace: utils.CopyString(c.Params("namespace")),
+		Name:      utils.CopyString(c.Params("name")),
+		Provider:  utils.CopyString(c.Params("provider")),
+		Version:   utils.CopyString(c.Params("version")),
+	}
+
+	return m.ID(m.Version != "")
+}
+
+func result(status int) string {
+	switch {
+	case status == fiber.StatusUnauthorized, status == fiber.StatusForbidden, status == fiber.StatusTooManyRequests:
+		return ResultDenied
+	case status >= fiber.StatusBadRequest:
+		return ResultFailure
+	default:
+		return ResultSuccess
+	}
+}
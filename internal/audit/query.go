@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/storage"
+
+	"github.com/pkg/errors"
+)
+
+// Filter selects audit events, empty fields select every event.
+type Filter struct {
+	// Module selects the events of a module ID and its versions (e.g. "acme/vpc/aws"), or a path.Match pattern.
+	Module string
+	// Identity is matched with path.Match against the identity name.
+	Identity string
+	Action   string
+	Since    time.Time
+	Until    time.Time
+}
+
+// Matches reports whether the filter selects the event.
+func (f *Filter) Matches(event Event) bool {
+	if f.Module != "" && event.Module != f.Module && !strings.HasPrefix(event.Module, f.Module+"/") {
+		if ok, err := path.Match(f.Module, event.Module); err != nil || !ok {
+			return false
+		}
+	}
+
+	if f.Identity != "" {
+		if ok, err := path.Match(f.Identity, event.Identity); err != nil || !ok {
+			return false
+		}
+	}
+
+	if f.Action != "" && event.Action != f.Action {
+		return false
+	}
+
+	if !f.Since.IsZero() && event.Time.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && !event.Time.Before(f.Until) {
+		return false
+	}
+
+	return true
+}
+
+// Query returns the events of a JSON lines log the filter selects.
+func Query(r io.Reader, filter Filter) ([]Event, error) {
+	var events []Event
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, errors.Wrapf(err, "invalid audit event on line %d", line)
+		}
+
+		if filter.Matches(event) {
+			events = append(events, event)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read audit log")
+	}
+
+	return events, nil
+}
+
+// QueryFile returns the events of a file written by a file sink the filter selects.
+func QueryFile(file string, filter Filter) ([]Event, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open audit file")
+	}
+	defer f.Close()
+
+	return Query(f, filter)
+}
+
+// QueryStorage returns the events written by a StorageSink the filter selects, ordered by time.
+func QueryStorage(ctx context.Context, s storage.MetadataStorage, filter Filter) ([]Event, error) {
+	keys, err := s.ListMetadata(ctx, metadataPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	// The objects are named after the day they were written, which is not before the day of their events
+	var since string
+	if !filter.Since.IsZero() {
+		since = metadataPrefix + filter.Since.UTC().Format("2006/01/02")
+	}
+
+	var events []Event
+	for _, key := range keys {
+		if key < since {
+			continue
+		}
+
+		data, err := s.GetMetadata(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+
+		selected, err := Query(bytes.NewReader(data), filter)
+		if err != nil {
+			return nil, errors.Wrap(err, key)
+		}
+		events = append(events, selected...)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+
+	return events, nil
+}
@@ -0,0 +1,205 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/storage"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DefaultFlushInterval is how often the StorageSink writes the buffered events.
+	DefaultFlushInterval = time.Minute
+
+	// metadataPrefix is the directory of the audit objects in a storage.MetadataStorage.
+	metadataPrefix = "audit/"
+	// maxBufferedEvents flushes the StorageSink before the interval passes.
+	maxBufferedEvents = 1000
+)
+
+// WriterSink writes the events as JSON lines, e.g. to stdout or a file.
+type WriterSink struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// NewWriterSink returns a WriterSink writing to w, which it does not close.
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+// NewFileSink returns a WriterSink appending to the file, it is created when it does not exist.
+func NewFileSink(file string) (*WriterSink, error) {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open audit file")
+	}
+
+	return &WriterSink{w: f, closer: f}, nil
+}
+
+func (s *WriterSink) Write(_ context.Context, event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode audit event")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A single write keeps the lines of concurrent processes on the same file intact
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "failed to write audit event")
+	}
+
+	return nil
+}
+
+func (s *WriterSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+
+	return s.closer.Close()
+}
+
+// StorageSink buffers the events and writes them as new JSON lines objects next to the modules.
+// Objects are never rewritten, every flush appends an object named after its time to the log.
+type StorageSink struct {
+	storage  storage.MetadataStorage
+	logger   zerolog.Logger
+	interval time.Duration
+	now      func() time.Time
+
+	mu     sync.Mutex
+	events []Event
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// StorageSinkOption provides additional options for the StorageSink.
+type StorageSinkOption func(*StorageSink)
+
+// WithFlushInterval sets how often the buffered events are written.
+func WithFlushInterval(interval time.Duration) StorageSinkOption {
+	return func(s *StorageSink) {
+		if interval > 0 {
+			s.interval = interval
+		}
+	}
+}
+
+// NewStorageSink returns a StorageSink, the events are written in the background until it is closed.
+func NewStorageSink(s storage.MetadataStorage, logger zerolog.Logger, options ...StorageSinkOption) *StorageSink {
+	sink := &StorageSink{
+		storage:  s,
+		logger:   logger,
+		interval: DefaultFlushInterval,
+		now:      time.Now,
+		done:     make(chan struct{}),
+	}
+
+	for _, option := range options {
+		option(sink)
+	}
+
+	sink.wg.Add(1)
+	go sink.run()
+
+	return sink
+}
+
+func (s *StorageSink) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Flush(context.Background()); err != nil {
+				s.logger.Error().Err(err).Msg("failed to flush audit events")
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *StorageSink) Write(ctx context.Context, event Event) error {
+	s.mu.Lock()
+	s.events = append(s.events, event)
+	full := len(s.events) >= maxBufferedEvents
+	s.mu.Unlock()
+
+	if full {
+		return s.Flush(ctx)
+	}
+
+	return nil
+}
+
+// Flush writes the buffered events to a new object. Events that cannot be written are kept for the next flush.
+func (s *StorageSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	events := s.events
+	s.events = nil
+	s.mu.Unlock()
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return errors.Wrap(err, "failed to encode audit event")
+		}
+	}
+
+	key, err := s.key()
+	if err == nil {
+		err = s.storage.PutMetadata(ctx, key, buf.Bytes())
+	}
+	if err != nil {
+		s.mu.Lock()
+		s.events = append(events, s.events...)
+		s.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// key returns a unique key ordered by time, e.g. audit/2023/06/01/120000.000000000-1a2b3c4d.jsonl.
+// The random suffix keeps the objects of registries sharing the storage apart.
+func (s *StorageSink) key() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate audit object name")
+	}
+
+	return metadataPrefix + s.now().UTC().Format("2006/01/02/150405.000000000") + "-" + hex.EncodeToString(b) + ".jsonl", nil
+}
+
+// Close stops the background flushes and writes the buffered events.
+func (s *StorageSink) Close() error {
+	close(s.done)
+	s.wg.Wait()
+
+	return s.Flush(context.Background())
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"path"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// anonymousKey is the fiber.Ctx locals key marking requests served without authentication.
+const anonymousKey = "auth.anonymous"
+
+// AnonymousReads runs the authentication middleware in front of a route, except for reads of namespaces
+// that do not match a private pattern. Those are served anonymously, unless the request carries credentials.
+// It runs with the routes, as it needs the namespace of the route.
+func AnonymousReads(authenticate fiber.Handler, private []string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if isRead(c) && !hasCredentials(c) && !matchesAny(private, c.Params("namespace")) {
+			c.Locals(anonymousKey, true)
+			return c.Next()
+		}
+
+		return authenticate(c)
+	}
+}
+
+// isAnonymous reports whether AnonymousReads served the request without authentication.
+func isAnonymous(c *fiber.Ctx) bool {
+	anonymous, _ := c.Locals(anonymousKey).(bool)
+	return anonymous
+}
+
+func isRead(c *fiber.Ctx) bool {
+	return c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead
+}
+
+// hasCredentials reports whether the request carries a token, Basic credentials or a verified client certificate.
+func hasCredentials(c *fiber.Ctx) bool {
+	if c.Get(fiber.HeaderAuthorization) != "" {
+		return true
+	}
+
+	state := c.Context().TLSConnectionState()
+	return state != nil && len(state.VerifiedChains) > 0
+}
+
+func matchesAny(patterns []string, namespace string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, namespace); err == nil && ok {
+			return true
+		}
+	}
+
+	return false
+}
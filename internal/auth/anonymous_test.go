@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnonymousReads(t *testing.T) {
+	t.Parallel()
+
+	authenticate := Middleware(zerolog.Nop(), NewStaticProvider(zerolog.Nop(), "reader", "writer"))
+	policy := Policy{
+		Default: RoleRead,
+		Rules: []Rule{
+			{Namespace: "network", Role: RoleWrite, Subjects: []string{"static:b9300677"}},
+		},
+	}
+
+	app := fiber.New()
+	middleware := []fiber.Handler{
+		AnonymousReads(authenticate, []string{"internal-*"}),
+		Authorize(zerolog.Nop(), policy),
+	}
+	handler := func(c *fiber.Ctx) error {
+		identity, _ := IdentityFromContext(c)
+		return c.SendString(identity.Subject)
+	}
+	app.Get("/:namespace/:name", append(middleware, handler)...)
+	app.Put("/:namespace/:name", append(middleware, handler)...)
+
+	testCases := []struct {
+		annotation      string
+		method          string
+		path            string
+		token           string
+		expectedStatus  int
+		expectedSubject string
+	}{
+		{annotation: "anonymous read", method: http.MethodGet, path: "/network/vpc", expectedStatus: http.StatusOK},
+		{annotation: "anonymous read of private namespace", method: http.MethodGet, path: "/internal-tools/vpc", expectedStatus: http.StatusUnauthorized},
+		{annotation: "authenticated read of private namespace", method: http.MethodGet, path: "/internal-tools/vpc", token: "reader", expectedStatus: http.StatusOK, expectedSubject: "static:3d094196"},
+		{annotation: "invalid token on read", method: http.MethodGet, path: "/network/vpc", token: "invalid", expectedStatus: http.StatusUnauthorized},
+		{annotation: "anonymous write", method: http.MethodPut, path: "/network/vpc", expectedStatus: http.StatusUnauthorized},
+		{annotation: "authenticated write", method: http.MethodPut, path: "/network/vpc", token: "writer", expectedStatus: http.StatusOK, expectedSubject: "static:b9300677"},
+		{annotation: "write without role", method: http.MethodPut, path: "/network/vpc", token: "reader", expectedStatus: http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.token != "" {
+				req.Header.Set(fiber.HeaderAuthorization, "Bearer "+tc.token)
+			}
+
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == http.StatusOK {
+				body, err := io.ReadAll(resp.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedSubject, string(body))
+			}
+		})
+	}
+}
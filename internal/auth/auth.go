@@ -1,12 +1,48 @@
 package auth
 
 import (
+	"encoding/base64"
+	"strings"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/keyauth"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 )
 
+// FailureHook is called when a provider rejects the credentials of a request no provider authenticated.
+type FailureHook func(c *fiber.Ctx, provider string, err error)
+
+// rejectionsKey is the fiber.Ctx locals key of the rejections of the token providers.
+const rejectionsKey = "auth.rejections"
+
+// rejection is the error of a provider that did not accept the credentials of a request.
+type rejection struct {
+	provider Provider
+	err      error
+}
+
 func Middleware(logger zerolog.Logger, providers ...Provider) func(c *fiber.Ctx) error {
+	return MiddlewareWithFailureHook(logger, nil, providers...)
+}
+
+// MiddlewareWithFailureHook is the Middleware calling the hook for every provider rejecting credentials.
+// Rejections only count as failures if no provider authenticates the request, as the providers are tried in turn.
+func MiddlewareWithFailureHook(logger zerolog.Logger, hook FailureHook, providers ...Provider) func(c *fiber.Ctx) error {
+	rejected := func(rejections []rejection, provider Provider, err error) []rejection {
+		logger.Debug().Str("provider", provider.String()).Err(err).Msg("provider rejected credentials")
+		return append(rejections, rejection{provider: provider, err: err})
+	}
+
+	failed := func(c *fiber.Ctx, rejections []rejection) {
+		for _, r := range rejections {
+			logger.Error().Str("provider", r.provider.String()).Err(r.err).Msg("failed to authenticate request")
+			if hook != nil {
+				hook(c, r.provider.String(), r.err)
+			}
+		}
+	}
+
 	// NO-OP, as there are no providers defined
 	if len(providers) == 0 {
 		return func(c *fiber.Ctx) error {
@@ -14,19 +50,113 @@ func Middleware(logger zerolog.Logger, providers ...Provider) func(c *fiber.Ctx)
 		}
 	}
 
-	return keyauth.New(keyauth.Config{
+	var connectionProviders []ConnectionProvider
+	var passwordProviders []PasswordProvider
+	var tokenProviders []Provider
+	for _, provider := range providers {
+		switch p := provider.(type) {
+		case ConnectionProvider:
+			connectionProviders = append(connectionProviders, p)
+		case PasswordProvider:
+			passwordProviders = append(passwordProviders, p)
+		default:
+			tokenProviders = append(tokenProviders, provider)
+		}
+	}
+
+	tokenAuth := keyauth.New(keyauth.Config{
 		Validator: func(c *fiber.Ctx, key string) (bool, error) {
+			rejections, _ := c.Locals(rejectionsKey).([]rejection)
 
-			for _, provider := range providers {
-				success, err := provider.Verify(c, key)
+			for _, provider := range tokenProviders {
+				identity, err := provider.Verify(c, key)
 				if err != nil {
-					logger.Error().Str("provider", provider.String()).Err(err).Msg("failed to verify token")
-				} else {
-					return success, nil
+					rejections = rejected(rejections, provider, err)
+					continue
 				}
+
+				setProviderIdentity(c, provider, identity)
+
+				return true, nil
 			}
 
+			c.Locals(rejectionsKey, rejections)
+
 			return false, keyauth.ErrMissingOrMalformedAPIKey
 		},
 	})
+
+	return func(c *fiber.Ctx) error {
+		var rejections []rejection
+
+		for _, provider := range connectionProviders {
+			identity, err := provider.VerifyConnection(c)
+			if errors.Is(err, ErrNoClientCertificate) {
+				continue
+			}
+			if err != nil {
+				rejections = rejected(rejections, provider, err)
+				continue
+			}
+
+			setProviderIdentity(c, provider, identity)
+
+			return c.Next()
+		}
+
+		if len(passwordProviders) > 0 {
+			if username, password, ok := basicAuth(c); ok {
+				for _, provider := range passwordProviders {
+					identity, err := provider.VerifyPassword(c, username, password)
+					if err != nil {
+						rejections = rejected(rejections, provider, errors.Wrapf(err, "username %s", username))
+						continue
+					}
+
+					setProviderIdentity(c, provider, identity)
+
+					return c.Next()
+				}
+
+				failed(c, rejections)
+
+				c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="Restricted"`)
+				return c.Status(fiber.StatusUnauthorized).SendString(fiber.ErrUnauthorized.Message)
+			}
+		}
+
+		c.Locals(rejectionsKey, rejections)
+
+		err := tokenAuth(c)
+
+		// The token providers pass successfully authenticated requests on to the handlers
+		if _, ok := IdentityFromContext(c); !ok {
+			rejections, _ := c.Locals(rejectionsKey).([]rejection)
+			failed(c, rejections)
+		}
+
+		return err
+	}
+}
+
+// basicAuth returns the credentials of an Authorization: Basic header.
+func basicAuth(c *fiber.Ctx) (string, string, bool) {
+	scheme, encoded, ok := strings.Cut(c.Get(fiber.HeaderAuthorization), " ")
+	if !ok || !strings.EqualFold(scheme, "basic") {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+
+	return strings.Cut(string(decoded), ":")
+}
+
+func setProviderIdentity(c *fiber.Ctx, provider Provider, identity Identity) {
+	if identity.Provider == "" {
+		identity.Provider = provider.String()
+	}
+	setIdentity(c, identity)
 }
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	app := fiber.New()
+	app.Use(Middleware(zerolog.Nop(), NewStaticProvider(zerolog.Nop(), "first,second")))
+	app.Get("/", func(c *fiber.Ctx) error {
+		identity, ok := IdentityFromContext(c)
+		assert.True(t, ok)
+
+		// Services receive the identity through the user context
+		fromContext, ok := FromContext(c.UserContext())
+		assert.True(t, ok)
+		assert.Equal(t, identity, fromContext)
+
+		return c.JSON(identity)
+	})
+
+	testCases := []struct {
+		annotation       string
+		token            string
+		expectedStatus   int
+		expectedIdentity Identity
+	}{
+		{
+			annotation:       "first token",
+			token:            "first",
+			expectedStatus:   http.StatusOK,
+			expectedIdentity: Identity{Subject: "static:a7937b64", Provider: "static", TokenID: "a7937b64"},
+		},
+		{
+			annotation:       "second token",
+			token:            "second",
+			expectedStatus:   http.StatusOK,
+			expectedIdentity: Identity{Subject: "static:16367aac", Provider: "static", TokenID: "16367aac"},
+		},
+		{
+			annotation:     "unknown token",
+			token:          "third",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+tc.token)
+
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == http.StatusOK {
+				var identity Identity
+				assert.NoError(t, json.NewDecoder(resp.Body).Decode(&identity))
+				assert.Equal(t, tc.expectedIdentity, identity)
+			}
+		})
+	}
+}
+
+func TestMiddlewareWithFailureHook(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		annotation       string
+		token            string
+		expectedStatus   int
+		expectedFailures []string
+	}{
+		{
+			annotation:     "accepted by the first provider",
+			token:          "first",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			annotation:     "accepted by a later provider",
+			token:          "second",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			annotation:       "rejected by every provider",
+			token:            "third",
+			expectedStatus:   http.StatusUnauthorized,
+			expectedFailures: []string{"static", "static"},
+		},
+		{
+			annotation:     "without credentials",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			var failures []string
+			hook := func(c *fiber.Ctx, provider string, err error) {
+				failures = append(failures, provider)
+			}
+
+			app := fiber.New()
+			app.Use(MiddlewareWithFailureHook(zerolog.Nop(), hook, NewStaticProvider(zerolog.Nop(), "first"), NewStaticProvider(zerolog.Nop(), "second")))
+			app.Get("/", func(c *fiber.Ctx) error {
+				return c.SendStatus(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.token != "" {
+				req.Header.Set(fiber.HeaderAuthorization, "Bearer "+tc.token)
+			}
+
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.Equal(t, tc.expectedFailures, failures)
+		})
+	}
+}
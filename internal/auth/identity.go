@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+)
+
+// identityKey is the fiber.Ctx locals key of the Identity of a request.
+const identityKey = "auth.identity"
+
+// identityContextKey is the context.Context key of the Identity of a request.
+type identityContextKey struct{}
+
+// Identity is the authenticated principal of a request.
+type Identity struct {
+	Subject  string   `json:"subject"`
+	Email    string   `json:"email,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+	Provider string   `json:"provider"`
+	// TokenID identifies the token the request was made with, without revealing it.
+	TokenID string `json:"token_id,omitempty"`
+	// Scopes and Namespaces restrict what the token can be used for, empty values do not restrict it.
+	Scopes     []string `json:"scopes,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Claims are the raw claims of the token, if it has any.
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+// Name returns the email of the identity, or its subject without one.
+func (i Identity) Name() string {
+	if i.Email != "" {
+		return i.Email
+	}
+
+	return i.Subject
+}
+
+// MarshalZerologObject logs the identity without its claims.
+func (i Identity) MarshalZerologObject(e *zerolog.Event) {
+	e.Str("subject", i.Subject).Str("provider", i.Provider)
+	if i.Email != "" {
+		e.Str("email", i.Email)
+	}
+	if len(i.Groups) > 0 {
+		e.Strs("groups", i.Groups)
+	}
+	if i.TokenID != "" {
+		e.Str("token_id", i.TokenID)
+	}
+}
+
+// HasScope reports whether the token of the identity was granted the scope explicitly.
+func (i Identity) HasScope(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allows reports whether the scopes and namespaces of the token permit the role in the namespace.
+func (i Identity) allows(namespace string, role Role) bool {
+	if len(i.Namespaces) > 0 && !matchesAny(i.Namespaces, namespace) {
+		return false
+	}
+
+	if len(i.Scopes) == 0 {
+		return true
+	}
+
+	for _, scope := range i.Scopes {
+		if Role(scope).Includes(role) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IdentityFromContext returns the Identity of the request, if a provider established one.
+func IdentityFromContext(c *fiber.Ctx) (Identity, bool) {
+	identity, ok := c.Locals(identityKey).(Identity)
+	return identity, ok
+}
+
+// NewContext returns a copy of the context carrying the identity.
+func NewContext(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, identity)
+}
+
+// FromContext returns the Identity carried by the context, e.g. the user context of an authenticated request.
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityContextKey{}).(Identity)
+	return identity, ok
+}
+
+// setIdentity makes the identity available to the handlers, and through the user context to the services.
+func setIdentity(c *fiber.Ctx, identity Identity) {
+	c.Locals(identityKey, identity)
+	c.SetUserContext(NewContext(c.UserContext(), identity))
+}
@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// Role is the access an identity has to a namespace.
+type Role string
+
+// Roles, ordered by the access they grant. The write role includes read access.
+const (
+	RoleNone  Role = ""
+	RoleRead  Role = "read"
+	RoleWrite Role = "write"
+)
+
+// Scopes of tokens. The read and write scopes limit the role of a token, the admin scope grants access to the admin API.
+const (
+	ScopeRead  = string(RoleRead)
+	ScopeWrite = string(RoleWrite)
+	ScopeAdmin = "admin"
+)
+
+func (r Role) level() int {
+	switch r {
+	case RoleRead:
+		return 1
+	case RoleWrite:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// Includes reports whether the role grants at least the access of the other role.
+func (r Role) Includes(other Role) bool {
+	return r.level() >= other.level()
+}
+
+func (r Role) valid() bool {
+	return r == RoleNone || r == RoleRead || r == RoleWrite
+}
+
+// Policy grants roles on namespaces to identities.
+// A namespace matched by one or more rules is only accessible through the roles those rules grant,
+// all other namespaces are accessible with the default role.
+type Policy struct {
+	// Default is the role of every identity in namespaces no rule matches.
+	Default Role   `yaml:"default"`
+	Rules   []Rule `yaml:"rules"`
+}
+
+// Rule grants a role on the matching namespaces to the selected identities.
+type Rule struct {
+	// Namespace is matched with path.Match (e.g. "network" or "team-*").
+	Namespace string `yaml:"namespace"`
+	Role      Role   `yaml:"role"`
+	// Subjects, Groups and Providers select the identities, any match is sufficient.
+	// "*" selects every authenticated identity.
+	Subjects  []string `yaml:"subjects"`
+	Groups    []string `yaml:"groups"`
+	Providers []string `yaml:"providers"`
+}
+
+// LoadPolicy reads a YAML policy file.
+func LoadPolicy(file string) (Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return Policy{}, errors.Wrap(err, "failed to read auth policy")
+	}
+
+	var policy Policy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return Policy{}, errors.Wrapf(err, "failed to parse auth policy: %s", file)
+	}
+
+	return policy, policy.Validate()
+}
+
+// Validate ensures that a Policy is valid.
+func (p *Policy) Validate() error {
+	var result *multierror.Error
+
+	if !p.Default.valid() {
+		result = multierror.Append(result, fmt.Errorf("default must be one of %q, %q or empty", RoleRead, RoleWrite))
+	}
+
+	for i, rule := range p.Rules {
+		if rule.Namespace == "" {
+			result = multierror.Append(result, fmt.Errorf("rules[%d].namespace cannot be empty", i))
+		} else if _, err := path.Match(rule.Namespace, ""); err != nil {
+			result = multierror.Append(result, fmt.Errorf("rules[%d].namespace: %w", i, err))
+		}
+
+		if rule.Role != RoleRead && rule.Role != RoleWrite {
+			result = multierror.Append(result, fmt.Errorf("rules[%d].role must be %q or %q", i, RoleRead, RoleWrite))
+		}
+
+		if len(rule.Subjects) == 0 && len(rule.Groups) == 0 && len(rule.Providers) == 0 {
+			result = multierror.Append(result, fmt.Errorf("rules[%d] must select subjects, groups or providers", i))
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+// Role returns the role of the identity in the namespace.
+func (p *Policy) Role(identity Identity, namespace string) Role {
+	role, matched := RoleNone, false
+
+	for _, rule := range p.Rules {
+		if ok, err := path.Match(rule.Namespace, namespace); err != nil || !ok {
+			continue
+		}
+		matched = true
+
+		if rule.selects(identity) && !role.Includes(rule.Role) {
+			role = rule.Role
+		}
+	}
+
+	if !matched {
+		return p.Default
+	}
+
+	return role
+}
+
+// selects reports whether the rule applies to the identity.
+func (r *Rule) selects(identity Identity) bool {
+	if identity.Subject == "" {
+		return false
+	}
+
+	if contains(r.Subjects, identity.Subject) || contains(r.Providers, identity.Provider) {
+		return true
+	}
+
+	for _, group := range identity.Groups {
+		if contains(r.Groups, group) {
+			return true
+		}
+	}
+
+	return contains(r.Groups, "*")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == "*" || v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Authorize enforces the policy on the namespace of the route. Reads require the read role,
+// all other requests the write role, and the token of the identity has to permit it as well.
+// Denied requests are logged with the identity and the roles. Reads served by AnonymousReads are always allowed.
+func Authorize(logger zerolog.Logger, policy Policy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Public reads are not subject to the policy
+		if isAnonymous(c) {
+			return c.Next()
+		}
+
+		required := RoleWrite
+		if isRead(c) {
+			required = RoleRead
+		}
+
+		namespace := c.Params("namespace")
+		identity, _ := IdentityFromContext(c)
+
+		role := policy.Role(identity, namespace)
+		if role.Includes(required) && identity.allows(namespace, required) {
+			return c.Next()
+		}
+
+		logger.Warn().
+			Str("subject", identity.Subject).
+			Str("provider", identity.Provider).
+			Strs("groups", identity.Groups).
+			Strs("scopes", identity.Scopes).
+			Strs("token_namespaces", identity.Namespaces).
+			Str("namespace", namespace).
+			Str("required", string(required)).
+			Str("role", string(role)).
+			Str("method", c.Method()).
+			Str("path", c.Path()).
+			Msg("authorization denied")
+
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"errors": []string{"Forbidden"},
+		})
+	}
+}
+
+// RequireScope only allows identities whose token was granted the scope.
+func RequireScope(logger zerolog.Logger, scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		identity, _ := IdentityFromContext(c)
+		if identity.HasScope(scope) {
+			return c.Next()
+		}
+
+		logger.Warn().
+			Str("subject", identity.Subject).
+			Str("provider", identity.Provider).
+			Strs("scopes", identity.Scopes).
+			Str("required", scope).
+			Str("method", c.Method()).
+			Str("path", c.Path()).
+			Msg("authorization denied")
+
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"errors": []string{"Forbidden"},
+		})
+	}
+}
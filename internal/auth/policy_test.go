@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+var testPolicy = Policy{
+	Default: RoleRead,
+	Rules: []Rule{
+		{Namespace: "network", Role: RoleWrite, Groups: []string{"team-net"}},
+		{Namespace: "network", Role: RoleRead, Subjects: []string{"*"}},
+		{Namespace: "shared", Role: RoleRead, Subjects: []string{"*"}},
+		{Namespace: "shared", Role: RoleWrite, Providers: []string{"static"}},
+		{Namespace: "security", Role: RoleWrite, Groups: []string{"security"}},
+	},
+}
+
+func TestPolicy_Role(t *testing.T) {
+	t.Parallel()
+
+	var (
+		netEngineer = Identity{Subject: "jdoe", Groups: []string{"team-net"}, Provider: "jwt"}
+		securityOps = Identity{Subject: "asmith", Groups: []string{"security", "team-net"}, Provider: "jwt"}
+		developer   = Identity{Subject: "bwayne", Provider: "jwt"}
+		ci          = Identity{Subject: "static:1a2b3c4d", Provider: "static"}
+	)
+
+	testCases := []struct {
+		annotation string
+		identity   Identity
+		namespace  string
+		expected   Role
+	}{
+		{annotation: "team write", identity: netEngineer, namespace: "network", expected: RoleWrite},
+		{annotation: "everyone reads network", identity: developer, namespace: "network", expected: RoleRead},
+		{annotation: "everyone reads shared", identity: netEngineer, namespace: "shared", expected: RoleRead},
+		{annotation: "provider write", identity: ci, namespace: "shared", expected: RoleWrite},
+		{annotation: "security only", identity: securityOps, namespace: "security", expected: RoleWrite},
+		{annotation: "outside security", identity: netEngineer, namespace: "security", expected: RoleNone},
+		{annotation: "default", identity: developer, namespace: "compute", expected: RoleRead},
+		{annotation: "anonymous", identity: Identity{}, namespace: "network", expected: RoleNone},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expected, testPolicy.Role(tc.identity, tc.namespace))
+		})
+	}
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		annotation    string
+		policy        Policy
+		expectedError bool
+	}{
+		{
+			annotation: "valid",
+			policy:     testPolicy,
+		},
+		{
+			annotation:    "invalid default",
+			policy:        Policy{Default: "admin"},
+			expectedError: true,
+		},
+		{
+			annotation:    "invalid role",
+			policy:        Policy{Rules: []Rule{{Namespace: "network", Role: "admin", Subjects: []string{"*"}}}},
+			expectedError: true,
+		},
+		{
+			annotation:    "invalid namespace",
+			policy:        Policy{Rules: []Rule{{Namespace: "network[", Role: RoleRead, Subjects: []string{"*"}}}},
+			expectedError: true,
+		},
+		{
+			annotation:    "without identities",
+			policy:        Policy{Rules: []Rule{{Namespace: "network", Role: RoleRead}}},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.policy.Validate()
+			if tc.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(`
+default: read
+rules:
+  - namespace: network
+    role: write
+    groups: [team-net]
+`), 0o600))
+
+	policy, err := LoadPolicy(file)
+	assert.NoError(t, err)
+	assert.Equal(t, Policy{
+		Default: RoleRead,
+		Rules:   []Rule{{Namespace: "network", Role: RoleWrite, Groups: []string{"team-net"}}},
+	}, policy)
+}
+
+func TestAuthorize(t *testing.T) {
+	t.Parallel()
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		setIdentity(c, Identity{Subject: "jdoe", Groups: []string{c.Get("X-Group")}, Provider: "jwt"})
+		return c.Next()
+	})
+	handler := func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) }
+	app.Get("/:namespace/:name", Authorize(zerolog.Nop(), testPolicy), handler)
+	app.Put("/:namespace/:name", Authorize(zerolog.Nop(), testPolicy), handler)
+
+	testCases := []struct {
+		annotation string
+		method     string
+		path       string
+		group      string
+		expected   int
+	}{
+		{annotation: "read", method: http.MethodGet, path: "/network/vpc", expected: http.StatusOK},
+		{annotation: "write", method: http.MethodPut, path: "/network/vpc", group: "team-net", expected: http.StatusOK},
+		{annotation: "write without role", method: http.MethodPut, path: "/network/vpc", expected: http.StatusForbidden},
+		{annotation: "read without role", method: http.MethodGet, path: "/security/vault", expected: http.StatusForbidden},
+		{annotation: "write to default", method: http.MethodPut, path: "/compute/vm", group: "team-net", expected: http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			req.Header.Set("X-Group", tc.group)
+
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, resp.StatusCode)
+		})
+	}
+}
+
+func TestAuthorize_TokenRestrictions(t *testing.T) {
+	t.Parallel()
+
+	policy := Policy{Default: RoleWrite}
+
+	testCases := []struct {
+		annotation string
+		identity   Identity
+		method     string
+		path       string
+		expected   int
+	}{
+		{annotation: "unrestricted", identity: Identity{Subject: "ci"}, method: http.MethodPut, path: "/network/vpc", expected: http.StatusOK},
+		{annotation: "read scope reads", identity: Identity{Subject: "ci", Scopes: []string{ScopeRead}}, method: http.MethodGet, path: "/network/vpc", expected: http.StatusOK},
+		{annotation: "read scope writes", identity: Identity{Subject: "ci", Scopes: []string{ScopeRead}}, method: http.MethodPut, path: "/network/vpc", expected: http.StatusForbidden},
+		{annotation: "write scope reads", identity: Identity{Subject: "ci", Scopes: []string{ScopeWrite}}, method: http.MethodGet, path: "/network/vpc", expected: http.StatusOK},
+		{annotation: "admin scope only", identity: Identity{Subject: "ci", Scopes: []string{ScopeAdmin}}, method: http.MethodGet, path: "/network/vpc", expected: http.StatusForbidden},
+		{annotation: "matching namespace", identity: Identity{Subject: "ci", Namespaces: []string{"net*"}}, method: http.MethodPut, path: "/network/vpc", expected: http.StatusOK},
+		{annotation: "other namespace", identity: Identity{Subject: "ci", Namespaces: []string{"net*"}}, method: http.MethodGet, path: "/compute/vm", expected: http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			app := fiber.New()
+			app.Use(func(c *fiber.Ctx) error {
+				setIdentity(c, tc.identity)
+				return c.Next()
+			})
+			handler := func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) }
+			app.Get("/:namespace/:name", Authorize(zerolog.Nop(), policy), handler)
+			app.Put("/:namespace/:name", Authorize(zerolog.Nop(), policy), handler)
+
+			resp, err := app.Test(httptest.NewRequest(tc.method, tc.path, nil))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, resp.StatusCode)
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		annotation string
+		identity   Identity
+		expected   int
+	}{
+		{annotation: "admin scope", identity: Identity{Subject: "admin", Scopes: []string{ScopeRead, ScopeAdmin}}, expected: http.StatusOK},
+		{annotation: "other scopes", identity: Identity{Subject: "ci", Scopes: []string{ScopeWrite}}, expected: http.StatusForbidden},
+		{annotation: "unrestricted", identity: Identity{Subject: "ci"}, expected: http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			app := fiber.New()
+			app.Use(func(c *fiber.Ctx) error {
+				setIdentity(c, tc.identity)
+				return c.Next()
+			})
+			app.Get("/", RequireScope(zerolog.Nop(), ScopeAdmin), func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, resp.StatusCode)
+		})
+	}
+}
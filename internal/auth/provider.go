@@ -4,7 +4,8 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
+// Provider verifies the token of a request and returns the Identity it was issued to.
 type Provider interface {
-	Verify(ctx *fiber.Ctx, token string) (bool, error)
+	Verify(ctx *fiber.Ctx, token string) (Identity, error)
 	String() string
 }
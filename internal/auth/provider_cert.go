@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// Fields of a client certificate the subject of the Identity can be taken from.
+const (
+	ClientCertSubjectCommonName = "cn"
+	ClientCertSubjectDNS        = "dns"
+	ClientCertSubjectURI        = "uri"
+	ClientCertSubjectEmail      = "email"
+)
+
+// ErrNoClientCertificate is returned for requests without a verified client certificate.
+var ErrNoClientCertificate = errors.New("no verified client certificate")
+
+// ConnectionProvider authenticates requests by their connection instead of a token.
+// The Middleware tries them before asking for a token, ErrNoClientCertificate lets it fall back to the token providers.
+type ConnectionProvider interface {
+	Provider
+	VerifyConnection(c *fiber.Ctx) (Identity, error)
+}
+
+// ClientCertProvider authenticates requests by the client certificate verified during the TLS handshake,
+// so the listener has to verify the certificates against the trusted client CAs.
+type ClientCertProvider struct {
+	subjectField string
+}
+
+func (p *ClientCertProvider) String() string { return "cert" }
+
+// Verify ignores the token, the request is authenticated by its client certificate.
+func (p *ClientCertProvider) Verify(c *fiber.Ctx, _ string) (Identity, error) {
+	return p.VerifyConnection(c)
+}
+
+func (p *ClientCertProvider) VerifyConnection(c *fiber.Ctx) (Identity, error) {
+	state := c.Context().TLSConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return Identity{}, ErrNoClientCertificate
+	}
+
+	return p.identity(state.VerifiedChains[0][0])
+}
+
+func (p *ClientCertProvider) identity(cert *x509.Certificate) (Identity, error) {
+	var subject string
+	switch p.subjectField {
+	case ClientCertSubjectCommonName:
+		subject = cert.Subject.CommonName
+	case ClientCertSubjectDNS:
+		if len(cert.DNSNames) > 0 {
+			subject = cert.DNSNames[0]
+		}
+	case ClientCertSubjectURI:
+		if len(cert.URIs) > 0 {
+			subject = cert.URIs[0].String()
+		}
+	case ClientCertSubjectEmail:
+		if len(cert.EmailAddresses) > 0 {
+			subject = cert.EmailAddresses[0]
+		}
+	}
+
+	if subject == "" {
+		return Identity{}, errors.Errorf("client certificate %q has no %s", cert.Subject, p.subjectField)
+	}
+
+	identity := Identity{
+		Subject:  subject,
+		Groups:   cert.Subject.OrganizationalUnit,
+		Provider: p.String(),
+		// Certificates are told apart by a prefix of their fingerprint
+		TokenID: fingerprint(cert),
+	}
+	if len(cert.EmailAddresses) > 0 {
+		identity.Email = cert.EmailAddresses[0]
+	}
+
+	return identity, nil
+}
+
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:4])
+}
+
+// ClientCertProviderOption provides additional options for the ClientCertProvider.
+type ClientCertProviderOption func(*ClientCertProvider)
+
+// WithClientCertSubject configures the field of the certificate the subject of the Identity is taken from,
+// the first SAN of that type for dns, uri and email.
+func WithClientCertSubject(field string) ClientCertProviderOption {
+	return func(p *ClientCertProvider) {
+		if field != "" {
+			p.subjectField = field
+		}
+	}
+}
+
+// NewClientCertProvider returns a ClientCertProvider, taking the subject from the common name by default.
+func NewClientCertProvider(options ...ClientCertProviderOption) (*ClientCertProvider, error) {
+	p := &ClientCertProvider{
+		subjectField: ClientCertSubjectCommonName,
+	}
+
+	for _, option := range options {
+		option(p)
+	}
+
+	switch p.subjectField {
+	case ClientCertSubjectCommonName, ClientCertSubjectDNS, ClientCertSubjectURI, ClientCertSubjectEmail:
+	default:
+		return nil, fmt.Errorf("unknown client certificate subject field %q", p.subjectField)
+	}
+
+	return p, nil
+}
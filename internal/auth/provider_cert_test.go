@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCertificate issues a certificate for the template, self-signed without a parent.
+func testCertificate(t *testing.T, template *x509.Certificate, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Minute)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	issuer, signer := template, interface{}(key)
+	if parent != nil {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	require.NoError(t, err)
+
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestClientCertProvider(t *testing.T) {
+	t.Parallel()
+
+	ca := testCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	otherCA := testCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Other CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	serverCert := testCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "registry"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, &ca)
+
+	agentURI, _ := url.Parse("spiffe://example.com/build-agent")
+	clientTemplate := func() *x509.Certificate {
+		return &x509.Certificate{
+			Subject:        pkix.Name{CommonName: "build-agent-1", OrganizationalUnit: []string{"ci"}},
+			DNSNames:       []string{"agent-1.example.com"},
+			URIs:           []*url.URL{agentURI},
+			EmailAddresses: []string{"ci@example.com"},
+			ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+	}
+	agent := testCertificate(t, clientTemplate(), &ca)
+	untrusted := testCertificate(t, clientTemplate(), &otherCA)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.Leaf)
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ca.Leaf)
+
+	testCases := []struct {
+		annotation       string
+		subjectField     string
+		certificate      *tls.Certificate
+		token            string
+		expectedStatus   int
+		expectedIdentity Identity
+	}{
+		{
+			annotation:     "common name",
+			certificate:    &agent,
+			expectedStatus: http.StatusOK,
+			expectedIdentity: Identity{
+				Subject:  "build-agent-1",
+				Email:    "ci@example.com",
+				Groups:   []string{"ci"},
+				Provider: "cert",
+				TokenID:  fingerprint(agent.Leaf),
+			},
+		},
+		{
+			annotation:     "dns name",
+			subjectField:   ClientCertSubjectDNS,
+			certificate:    &agent,
+			expectedStatus: http.StatusOK,
+			expectedIdentity: Identity{
+				Subject:  "agent-1.example.com",
+				Email:    "ci@example.com",
+				Groups:   []string{"ci"},
+				Provider: "cert",
+				TokenID:  fingerprint(agent.Leaf),
+			},
+		},
+		{
+			annotation:     "uri",
+			subjectField:   ClientCertSubjectURI,
+			certificate:    &agent,
+			expectedStatus: http.StatusOK,
+			expectedIdentity: Identity{
+				Subject:  "spiffe://example.com/build-agent",
+				Email:    "ci@example.com",
+				Groups:   []string{"ci"},
+				Provider: "cert",
+				TokenID:  fingerprint(agent.Leaf),
+			},
+		},
+		{
+			annotation:     "without certificate falls back to tokens",
+			token:          "static",
+			expectedStatus: http.StatusOK,
+			expectedIdentity: Identity{
+				Subject:  "static:2053dbbf",
+				Provider: "static",
+				TokenID:  "2053dbbf",
+			},
+		},
+		{
+			annotation:     "without certificate and token",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			// Clients only present certificates of the CAs the server asks for
+			annotation:     "certificate of another CA",
+			certificate:    &untrusted,
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			provider, err := NewClientCertProvider(WithClientCertSubject(tc.subjectField))
+			require.NoError(t, err)
+
+			app := fiber.New(fiber.Config{DisableStartupMessage: true})
+			app.Use(Middleware(zerolog.Nop(), provider, NewStaticProvider(zerolog.Nop(), "static")))
+			app.Get("/", func(c *fiber.Ctx) error {
+				identity, _ := IdentityFromContext(c)
+				return c.JSON(identity)
+			})
+
+			ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+				Certificates: []tls.Certificate{serverCert},
+				ClientAuth:   tls.VerifyClientCertIfGiven,
+				ClientCAs:    clientCAs,
+			})
+			require.NoError(t, err)
+
+			go func() { _ = app.Listener(ln) }()
+			t.Cleanup(func() { _ = app.Shutdown() })
+
+			clientConfig := &tls.Config{RootCAs: rootCAs}
+			if tc.certificate != nil {
+				clientConfig.Certificates = []tls.Certificate{*tc.certificate}
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+
+			req, err := http.NewRequest(http.MethodGet, "https://"+ln.Addr().String()+"/", nil)
+			require.NoError(t, err)
+			if tc.token != "" {
+				req.Header.Set(fiber.HeaderAuthorization, "Bearer "+tc.token)
+			}
+
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == http.StatusOK {
+				var identity Identity
+				assert.NoError(t, json.NewDecoder(resp.Body).Decode(&identity))
+				assert.Equal(t, tc.expectedIdentity, identity)
+			}
+		})
+	}
+}
+
+func TestNewClientCertProvider(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewClientCertProvider(WithClientCertSubject("serial"))
+	assert.Error(t, err)
+}
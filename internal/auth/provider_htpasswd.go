@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidCredentials is returned for unknown users and wrong passwords.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// PasswordProvider verifies the credentials of HTTP Basic authentication.
+type PasswordProvider interface {
+	Provider
+	VerifyPassword(c *fiber.Ctx, username, password string) (Identity, error)
+}
+
+// HtpasswdProvider verifies Basic credentials against the bcrypt hashes of an htpasswd file,
+// as created with `htpasswd -B`. The file is read again when it changes.
+type HtpasswdProvider struct {
+	logger zerolog.Logger
+	file   string
+
+	mu      sync.RWMutex
+	modTime time.Time
+	size    int64
+	users   map[string][]byte
+}
+
+func (p *HtpasswdProvider) String() string { return "htpasswd" }
+
+// Verify rejects bearer tokens, the Middleware passes Basic credentials to VerifyPassword.
+func (p *HtpasswdProvider) Verify(_ *fiber.Ctx, _ string) (Identity, error) {
+	return Identity{}, ErrInvalidCredentials
+}
+
+func (p *HtpasswdProvider) VerifyPassword(_ *fiber.Ctx, username, password string) (Identity, error) {
+	if err := p.reload(); err != nil {
+		// Keep the users of the last valid file
+		p.logger.Error().Err(err).Str("file", p.file).Msg("failed to reload htpasswd file")
+	}
+
+	p.mu.RLock()
+	hash, ok := p.users[username]
+	p.mu.RUnlock()
+
+	if !ok {
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	return Identity{Subject: username, Provider: p.String()}, nil
+}
+
+// reload reads the file again if its modification time or size changed.
+func (p *HtpasswdProvider) reload() error {
+	info, err := os.Stat(p.file)
+	if err != nil {
+		return errors.Wrap(err, "failed to read htpasswd file")
+	}
+
+	p.mu.RLock()
+	changed := !info.ModTime().Equal(p.modTime) || info.Size() != p.size
+	p.mu.RUnlock()
+
+	if !changed {
+		return nil
+	}
+
+	data, err := os.ReadFile(p.file)
+	if err != nil {
+		return errors.Wrap(err, "failed to read htpasswd file")
+	}
+
+	users, err := parseHtpasswd(data)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse htpasswd file: %s", p.file)
+	}
+
+	p.mu.Lock()
+	p.users, p.modTime, p.size = users, info.ModTime(), info.Size()
+	p.mu.Unlock()
+
+	p.logger.Info().Str("file", p.file).Int("users", len(users)).Msg("loaded htpasswd file")
+
+	return nil
+}
+
+func parseHtpasswd(data []byte) (map[string][]byte, error) {
+	users := make(map[string][]byte)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		username, hash, ok := strings.Cut(entry, ":")
+		if !ok || username == "" {
+			return nil, errors.Errorf("line %d: expected username:hash", line)
+		}
+
+		// Only bcrypt is supported, the other htpasswd formats are weak
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, errors.Errorf("line %d: user %q has no bcrypt hash", line, username)
+		}
+
+		users[username] = []byte(hash)
+	}
+
+	return users, scanner.Err()
+}
+
+// NewHtpasswdProvider returns a HtpasswdProvider for the file, which has to be valid initially.
+func NewHtpasswdProvider(logger zerolog.Logger, file string) (*HtpasswdProvider, error) {
+	p := &HtpasswdProvider{
+		logger: logger,
+		file:   file,
+	}
+
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
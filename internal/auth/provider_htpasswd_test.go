@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeHtpasswd(t *testing.T, file string, modTime time.Time, users map[string]string) {
+	t.Helper()
+
+	var data []byte
+	for username, password := range users {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		require.NoError(t, err)
+		data = append(data, []byte(username+":"+string(hash)+"\n")...)
+	}
+
+	require.NoError(t, os.WriteFile(file, data, 0o600))
+	// Changes within the resolution of the file system are detected by the time set here
+	require.NoError(t, os.Chtimes(file, modTime, modTime))
+}
+
+func TestHtpasswdProvider(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	writeHtpasswd(t, file, time.Now().Add(-time.Hour), map[string]string{"proxy": "secret"})
+
+	provider, err := NewHtpasswdProvider(zerolog.Nop(), file)
+	require.NoError(t, err)
+
+	app := fiber.New()
+	app.Use(Middleware(zerolog.Nop(), provider, NewStaticProvider(zerolog.Nop(), "static")))
+	app.Get("/", func(c *fiber.Ctx) error {
+		identity, _ := IdentityFromContext(c)
+		return c.JSON(identity)
+	})
+
+	request := func(configure func(req *http.Request)) (*http.Response, Identity) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		configure(req)
+
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+
+		var identity Identity
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&identity))
+		}
+
+		return resp, identity
+	}
+
+	resp, identity := request(func(req *http.Request) { req.SetBasicAuth("proxy", "secret") })
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, Identity{Subject: "proxy", Provider: "htpasswd"}, identity)
+
+	resp, _ = request(func(req *http.Request) { req.SetBasicAuth("proxy", "wrong") })
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, `Basic realm="Restricted"`, resp.Header.Get(fiber.HeaderWWWAuthenticate))
+
+	resp, _ = request(func(req *http.Request) { req.SetBasicAuth("unknown", "secret") })
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// Bearer tokens are still verified by the token providers
+	resp, identity = request(func(req *http.Request) { req.Header.Set(fiber.HeaderAuthorization, "Bearer static") })
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "static", identity.Provider)
+
+	// Rotated passwords are picked up without a restart
+	writeHtpasswd(t, file, time.Now(), map[string]string{"proxy": "rotated", "getter": "download"})
+
+	resp, _ = request(func(req *http.Request) { req.SetBasicAuth("proxy", "secret") })
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, _ = request(func(req *http.Request) { req.SetBasicAuth("proxy", "rotated") })
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, identity = request(func(req *http.Request) { req.SetBasicAuth("getter", "download") })
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "getter", identity.Subject)
+
+	// An invalid file keeps the users of the last valid one
+	require.NoError(t, os.WriteFile(file, []byte("proxy:plaintext\n"), 0o600))
+	require.NoError(t, os.Chtimes(file, time.Now().Add(time.Hour), time.Now().Add(time.Hour)))
+
+	resp, _ = request(func(req *http.Request) { req.SetBasicAuth("proxy", "rotated") })
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestParseHtpasswd(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		annotation    string
+		data          string
+		expectedUsers []string
+		wantErr       bool
+	}{
+		{
+			annotation:    "bcrypt users",
+			data:          "# proxies\nproxy:" + string(hash) + "\n\ngetter:" + string(hash) + "\n",
+			expectedUsers: []string{"getter", "proxy"},
+		},
+		{
+			annotation: "md5 hash",
+			data:       "proxy:$apr1$aXbFu3Xv$JLrB6/bZLBF5iW5cVyCDC1\n",
+			wantErr:    true,
+		},
+		{
+			annotation: "missing hash",
+			data:       "proxy\n",
+			wantErr:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			users, err := parseHtpasswd([]byte(tc.data))
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			var usernames []string
+			for username := range users {
+				usernames = append(usernames, username)
+			}
+			assert.ElementsMatch(t, tc.expectedUsers, usernames)
+		})
+	}
+}
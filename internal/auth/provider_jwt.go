@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// Default claims the Identity of a JWT is taken from.
+const (
+	DefaultJWTSubjectClaim = "sub"
+	DefaultJWTEmailClaim   = "email"
+	DefaultJWTGroupsClaim  = "groups"
+)
+
+// jwtSigningAlgorithms are the asymmetric algorithms accepted for tokens verified with a local key set.
+var jwtSigningAlgorithms = []string{
+	oidc.RS256, oidc.RS384, oidc.RS512,
+	oidc.ES256, oidc.ES384, oidc.ES512,
+	oidc.PS256, oidc.PS384, oidc.PS512,
+	oidc.EdDSA,
+}
+
+// JWTProvider verifies bearer tokens issued by an OpenID Connect provider, e.g. the SSO of an organization.
+// The signature is checked against the JWKS of the issuer, as well as the iss, aud and exp claims.
+type JWTProvider struct {
+	name         string
+	issuer       string
+	audience     string
+	keySetFile   string
+	keySet       oidc.KeySet
+	subjectClaim string
+	emailClaim   string
+	groupsClaim  string
+	verifier     *oidc.IDTokenVerifier
+}
+
+func (p *JWTProvider) String() string { return p.name }
+
+func (p *JWTProvider) Verify(c *fiber.Ctx, token string) (Identity, error) {
+	idToken, err := p.verifier.Verify(c.UserContext(), token)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return Identity{}, err
+	}
+
+	return p.identity(claims)
+}
+
+// identity maps the configured claims to an Identity.
+func (p *JWTProvider) identity(claims map[string]interface{}) (Identity, error) {
+	identity := Identity{
+		Provider: p.String(),
+		Claims:   claims,
+	}
+	identity.TokenID, _ = claims["jti"].(string)
+
+	identity.Subject, _ = claims[p.subjectClaim].(string)
+	if identity.Subject == "" {
+		return Identity{}, fmt.Errorf("token has no %s claim", p.subjectClaim)
+	}
+
+	identity.Email, _ = claims[p.emailClaim].(string)
+
+	switch groups := claims[p.groupsClaim].(type) {
+	case string:
+		identity.Groups = strings.Fields(groups)
+	case []interface{}:
+		for _, group := range groups {
+			if s, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, s)
+			}
+		}
+	}
+
+	return identity, nil
+}
+
+// JWTProviderOption provides additional options for the JWTProvider.
+type JWTProviderOption func(*JWTProvider)
+
+// WithJWTKeySetFile verifies the signatures with the keys of a local JWKS file,
+// instead of the keys published by the issuer.
+func WithJWTKeySetFile(file string) JWTProviderOption {
+	return func(p *JWTProvider) {
+		p.keySetFile = file
+	}
+}
+
+// WithJWTKeySet verifies the signatures with the given keys, e.g. those of a token issuer embedded in the registry.
+func WithJWTKeySet(keySet oidc.KeySet) JWTProviderOption {
+	return func(p *JWTProvider) {
+		p.keySet = keySet
+	}
+}
+
+// WithJWTName sets the name the provider is reported and logged as.
+func WithJWTName(name string) JWTProviderOption {
+	return func(p *JWTProvider) {
+		if name != "" {
+			p.name = name
+		}
+	}
+}
+
+// WithJWTClaims configures the claims the subject, email and groups of the Identity are taken from.
+// Empty values keep the defaults.
+func WithJWTClaims(subject, email, groups string) JWTProviderOption {
+	return func(p *JWTProvider) {
+		if subject != "" {
+			p.subjectClaim = subject
+		}
+		if email != "" {
+			p.emailClaim = email
+		}
+		if groups != "" {
+			p.groupsClaim = groups
+		}
+	}
+}
+
+// NewJWTProvider returns a JWTProvider accepting tokens of the issuer for the audience.
+// Without a key set (file), the keys are discovered through the OpenID configuration of the issuer.
+func NewJWTProvider(ctx context.Context, issuer, audience string, options ...JWTProviderOption) (*JWTProvider, error) {
+	p := &JWTProvider{
+		name:         "jwt",
+		issuer:       issuer,
+		audience:     audience,
+		subjectClaim: DefaultJWTSubjectClaim,
+		emailClaim:   DefaultJWTEmailClaim,
+		groupsClaim:  DefaultJWTGroupsClaim,
+	}
+
+	for _, option := range options {
+		option(p)
+	}
+
+	if p.issuer == "" {
+		return nil, errors.New("jwt issuer is required")
+	}
+
+	// Tokens of the issuer are usually meant for many services, the audience tells them apart
+	if p.audience == "" {
+		return nil, errors.New("jwt audience is required")
+	}
+
+	config := &oidc.Config{
+		ClientID: p.audience,
+	}
+
+	if p.keySet == nil && p.keySetFile == "" {
+		provider, err := oidc.NewProvider(ctx, p.issuer)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to discover issuer %s", p.issuer)
+		}
+
+		p.verifier = provider.Verifier(config)
+		return p, nil
+	}
+
+	if p.keySet == nil {
+		keySet, err := loadKeySet(p.keySetFile)
+		if err != nil {
+			return nil, err
+		}
+		p.keySet = keySet
+	}
+
+	config.SupportedSigningAlgs = jwtSigningAlgorithms
+	p.verifier = oidc.NewVerifier(p.issuer, p.keySet, config)
+
+	return p, nil
+}
+
+// loadKeySet reads the public keys of a JWKS file.
+func loadKeySet(file string) (*oidc.StaticKeySet, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read key set")
+	}
+
+	var jwks jose.JSONWebKeySet
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse key set: %s", file)
+	}
+
+	keySet := &oidc.StaticKeySet{}
+	for _, key := range jwks.Keys {
+		if !key.Valid() || !key.IsPublic() {
+			return nil, errors.Errorf("key set %s: key %q is not a valid public key", file, key.KeyID)
+		}
+
+		keySet.PublicKeys = append(keySet.PublicKeys, crypto.PublicKey(key.Key))
+	}
+
+	if len(keySet.PublicKeys) == 0 {
+		return nil, errors.Errorf("key set %s contains no keys", file)
+	}
+
+	return keySet, nil
+}
@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+const testJWTIssuer = "https://sso.example.com"
+
+type testJWTClaims struct {
+	jwt.Claims
+	Email  string      `json:"email,omitempty"`
+	Groups interface{} `json:"groups,omitempty"`
+	Login  string      `json:"preferred_username,omitempty"`
+}
+
+func testJWTKey(t *testing.T) (*rsa.PrivateKey, jose.JSONWebKeySet) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	return key, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: key.Public(), KeyID: "test", Algorithm: string(jose.RS256), Use: "sig"},
+	}}
+}
+
+func testJWT(t *testing.T, key *rsa.PrivateKey, claims testJWTClaims) string {
+	t.Helper()
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithHeader("kid", "test"))
+	assert.NoError(t, err)
+
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	assert.NoError(t, err)
+
+	return token
+}
+
+// verifyJWT runs the provider in a fiber handler and returns the identity without its claims.
+func verifyJWT(t *testing.T, p Provider, token string) (Identity, error) {
+	t.Helper()
+
+	var (
+		identity Identity
+		err      error
+	)
+
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		identity, err = p.Verify(c, token)
+		identity.Claims = nil
+		return nil
+	})
+
+	_, testErr := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.NoError(t, testErr)
+
+	return identity, err
+}
+
+func TestJWTProvider(t *testing.T) {
+	t.Parallel()
+
+	key, jwks := testJWTKey(t)
+	otherKey, _ := testJWTKey(t)
+
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	data, err := json.Marshal(jwks)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(file, data, 0o600))
+
+	p, err := NewJWTProvider(context.Background(), testJWTIssuer, "registry", WithJWTKeySetFile(file))
+	assert.NoError(t, err)
+
+	valid := jwt.Claims{
+		Issuer:   testJWTIssuer,
+		Subject:  "jdoe",
+		Audience: jwt.Audience{"registry"},
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+
+	testCases := []struct {
+		annotation string
+		key        *rsa.PrivateKey
+		claims     func(c jwt.Claims) testJWTClaims
+		expected   Identity
+		expectedOK bool
+	}{
+		{
+			annotation: "valid token",
+			key:        key,
+			claims: func(c jwt.Claims) testJWTClaims {
+				return testJWTClaims{Claims: c, Email: "jdoe@example.com", Groups: []string{"network", "platform"}}
+			},
+			expected:   Identity{Subject: "jdoe", Email: "jdoe@example.com", Groups: []string{"network", "platform"}, Provider: "jwt"},
+			expectedOK: true,
+		},
+		{
+			annotation: "token id",
+			key:        key,
+			claims: func(c jwt.Claims) testJWTClaims {
+				c.ID = "d5e1f7"
+				return testJWTClaims{Claims: c}
+			},
+			expected:   Identity{Subject: "jdoe", Provider: "jwt", TokenID: "d5e1f7"},
+			expectedOK: true,
+		},
+		{
+			annotation: "space separated groups",
+			key:        key,
+			claims: func(c jwt.Claims) testJWTClaims {
+				return testJWTClaims{Claims: c, Groups: "network platform"}
+			},
+			expected:   Identity{Subject: "jdoe", Groups: []string{"network", "platform"}, Provider: "jwt"},
+			expectedOK: true,
+		},
+		{
+			annotation: "signed with another key",
+			key:        otherKey,
+			claims:     func(c jwt.Claims) testJWTClaims { return testJWTClaims{Claims: c} },
+		},
+		{
+			annotation: "other issuer",
+			key:        key,
+			claims: func(c jwt.Claims) testJWTClaims {
+				c.Issuer = "https://evil.example.com"
+				return testJWTClaims{Claims: c}
+			},
+		},
+		{
+			annotation: "other audience",
+			key:        key,
+			claims: func(c jwt.Claims) testJWTClaims {
+				c.Audience = jwt.Audience{"kubernetes"}
+				return testJWTClaims{Claims: c}
+			},
+		},
+		{
+			annotation: "expired",
+			key:        key,
+			claims: func(c jwt.Claims) testJWTClaims {
+				c.Expiry = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+				return testJWTClaims{Claims: c}
+			},
+		},
+		{
+			annotation: "without expiry",
+			key:        key,
+			claims: func(c jwt.Claims) testJWTClaims {
+				c.Expiry = nil
+				return testJWTClaims{Claims: c}
+			},
+		},
+		{
+			annotation: "without subject",
+			key:        key,
+			claims: func(c jwt.Claims) testJWTClaims {
+				c.Subject = ""
+				return testJWTClaims{Claims: c}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			identity, err := verifyJWT(t, p, testJWT(t, tc.key, tc.claims(valid)))
+			if tc.expectedOK {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, identity)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	_, err = verifyJWT(t, p, "not-a-jwt")
+	assert.Error(t, err)
+}
+
+func TestJWTProvider_Discovery(t *testing.T) {
+	t.Parallel()
+
+	key, jwks := testJWTKey(t)
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                server.URL,
+			"jwks_uri":                              server.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jwks)
+	})
+
+	p, err := NewJWTProvider(context.Background(), server.URL, "registry", WithJWTClaims("preferred_username", "", ""))
+	assert.NoError(t, err)
+
+	token := testJWT(t, key, testJWTClaims{
+		Claims: jwt.Claims{
+			Issuer:   server.URL,
+			Subject:  "00u1a2b3c4",
+			Audience: jwt.Audience{"registry"},
+			Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Login: "jdoe",
+	})
+
+	identity, err := verifyJWT(t, p, token)
+	assert.NoError(t, err)
+	assert.Equal(t, Identity{Subject: "jdoe", Provider: "jwt"}, identity)
+}
+
+func TestNewJWTProvider_InvalidOptions(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewJWTProvider(context.Background(), "", "registry")
+	assert.Error(t, err)
+
+	_, err = NewJWTProvider(context.Background(), testJWTIssuer, "")
+	assert.Error(t, err)
+
+	_, err = NewJWTProvider(context.Background(), testJWTIssuer, "registry", WithJWTKeySetFile(filepath.Join(t.TempDir(), "missing.json")))
+	assert.Error(t, err)
+}
@@ -0,0 +1,339 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// Defaults of the LDAPProvider, {username} and {dn} are replaced with the escaped username and user DN.
+const (
+	DefaultLDAPUserFilter         = "(uid={username})"
+	DefaultLDAPEmailAttribute     = "mail"
+	DefaultLDAPGroupFilter        = "(member={dn})"
+	DefaultLDAPGroupNameAttribute = "cn"
+	DefaultLDAPCacheTTL           = time.Minute
+
+	ldapTimeout = 10 * time.Second
+)
+
+// LDAPProvider verifies Basic credentials against a directory like Active Directory. The user is searched
+// with the service account, after which the provider binds as the user to verify the password.
+// The groups of the user are those whose member attribute lists the DN of the user.
+type LDAPProvider struct {
+	url                string
+	bindDN             string
+	bindPassword       string
+	userBaseDN         string
+	userFilter         string
+	emailAttribute     string
+	groupBaseDN        string
+	groupFilter        string
+	groupNameAttribute string
+	startTLS           bool
+	caFile             string
+	tlsConfig          *tls.Config
+	cacheTTL           time.Duration
+	now                func() time.Time
+
+	mu    sync.Mutex
+	cache map[[32]byte]ldapCacheEntry
+}
+
+type ldapCacheEntry struct {
+	identity Identity
+	expires  time.Time
+}
+
+func (p *LDAPProvider) String() string { return "ldap" }
+
+// Verify rejects bearer tokens, the Middleware passes Basic credentials to VerifyPassword.
+func (p *LDAPProvider) Verify(_ *fiber.Ctx, _ string) (Identity, error) {
+	return Identity{}, ErrInvalidCredentials
+}
+
+func (p *LDAPProvider) VerifyPassword(_ *fiber.Ctx, username, password string) (Identity, error) {
+	// An empty password is an unauthenticated bind, which succeeds for any DN
+	if username == "" || password == "" {
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	key := sha256.Sum256([]byte(username + "\x00" + password))
+	if identity, ok := p.cached(key); ok {
+		return identity, nil
+	}
+
+	identity, err := p.authenticate(username, password)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	p.store(key, identity)
+
+	return identity, nil
+}
+
+func (p *LDAPProvider) authenticate(username, password string) (Identity, error) {
+	conn, err := p.dial()
+	if err != nil {
+		return Identity{}, err
+	}
+	defer conn.Close()
+
+	if err := p.bindServiceAccount(conn); err != nil {
+		return Identity{}, err
+	}
+
+	filter := strings.ReplaceAll(p.userFilter, "{username}", ldap.EscapeFilter(username))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		p.userBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(ldapTimeout.Seconds()), false,
+		filter, []string{p.emailAttribute}, nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return Identity{}, errors.Wrap(err, "failed to search user")
+	}
+	if result == nil || len(result.Entries) != 1 {
+		return Identity{}, ErrInvalidCredentials
+	}
+	user := result.Entries[0]
+
+	if err := conn.Bind(user.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return Identity{}, ErrInvalidCredentials
+		}
+		return Identity{}, errors.Wrap(err, "failed to bind user")
+	}
+
+	identity := Identity{
+		Subject:  username,
+		Email:    user.GetAttributeValue(p.emailAttribute),
+		Provider: p.String(),
+	}
+
+	if p.groupBaseDN != "" {
+		// The user may not be allowed to search the groups
+		if err := p.bindServiceAccount(conn); err != nil {
+			return Identity{}, err
+		}
+
+		if identity.Groups, err = p.groups(conn, user.DN); err != nil {
+			return Identity{}, err
+		}
+	}
+
+	return identity, nil
+}
+
+func (p *LDAPProvider) groups(conn *ldap.Conn, userDN string) ([]string, error) {
+	filter := strings.ReplaceAll(p.groupFilter, "{dn}", ldap.EscapeFilter(userDN))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		p.groupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(ldapTimeout.Seconds()), false,
+		filter, []string{p.groupNameAttribute}, nil,
+	))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to search groups")
+	}
+
+	var groups []string
+	for _, entry := range result.Entries {
+		if name := entry.GetAttributeValue(p.groupNameAttribute); name != "" {
+			groups = append(groups, name)
+		}
+	}
+
+	return groups, nil
+}
+
+func (p *LDAPProvider) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(p.url,
+		ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}),
+		ldap.DialWithTLSConfig(p.tlsConfig),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to %s", p.url)
+	}
+	conn.SetTimeout(ldapTimeout)
+
+	if p.startTLS {
+		if err := conn.StartTLS(p.tlsConfig); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "failed to start tls")
+		}
+	}
+
+	return conn, nil
+}
+
+// bindServiceAccount binds as the service account, without one the searches are anonymous.
+func (p *LDAPProvider) bindServiceAccount(conn *ldap.Conn) error {
+	if p.bindDN == "" {
+		return conn.UnauthenticatedBind("")
+	}
+
+	return errors.Wrap(conn.Bind(p.bindDN, p.bindPassword), "failed to bind service account")
+}
+
+func (p *LDAPProvider) cached(key [32]byte) (Identity, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.cache[key]
+	if !ok || !p.now().Before(entry.expires) {
+		return Identity{}, false
+	}
+
+	return entry.identity, true
+}
+
+func (p *LDAPProvider) store(key [32]byte, identity Identity) {
+	if p.cacheTTL <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	for k, v := range p.cache {
+		if !now.Before(v.expires) {
+			delete(p.cache, k)
+		}
+	}
+
+	p.cache[key] = ldapCacheEntry{identity: identity, expires: now.Add(p.cacheTTL)}
+}
+
+// LDAPProviderOption provides additional options for the LDAPProvider.
+type LDAPProviderOption func(*LDAPProvider)
+
+// WithLDAPBind configures the service account searching the users and groups, they are searched anonymously without it.
+func WithLDAPBind(dn, password string) LDAPProviderOption {
+	return func(p *LDAPProvider) {
+		p.bindDN = dn
+		p.bindPassword = password
+	}
+}
+
+// WithLDAPUserSearch configures the base DN and filter the user is searched with,
+// e.g. "(sAMAccountName={username})" for Active Directory. An empty filter keeps the default.
+func WithLDAPUserSearch(baseDN, filter string) LDAPProviderOption {
+	return func(p *LDAPProvider) {
+		p.userBaseDN = baseDN
+		if filter != "" {
+			p.userFilter = filter
+		}
+	}
+}
+
+// WithLDAPEmailAttribute configures the attribute of the user holding the email of the identity.
+func WithLDAPEmailAttribute(attribute string) LDAPProviderOption {
+	return func(p *LDAPProvider) {
+		if attribute != "" {
+			p.emailAttribute = attribute
+		}
+	}
+}
+
+// WithLDAPGroupSearch configures the base DN, filter and name attribute of the groups of the user,
+// groups are not resolved without a base DN. Empty values keep the defaults.
+func WithLDAPGroupSearch(baseDN, filter, nameAttribute string) LDAPProviderOption {
+	return func(p *LDAPProvider) {
+		p.groupBaseDN = baseDN
+		if filter != "" {
+			p.groupFilter = filter
+		}
+		if nameAttribute != "" {
+			p.groupNameAttribute = nameAttribute
+		}
+	}
+}
+
+// WithLDAPStartTLS upgrades ldap:// connections with StartTLS.
+func WithLDAPStartTLS(enabled bool) LDAPProviderOption {
+	return func(p *LDAPProvider) {
+		p.startTLS = enabled
+	}
+}
+
+// WithLDAPCAFile verifies the certificate of the directory with the PEM encoded CAs, instead of the system roots.
+func WithLDAPCAFile(file string) LDAPProviderOption {
+	return func(p *LDAPProvider) {
+		p.caFile = file
+	}
+}
+
+// WithLDAPCacheTTL configures how long successful binds are cached, zero disables the cache.
+func WithLDAPCacheTTL(ttl time.Duration) LDAPProviderOption {
+	return func(p *LDAPProvider) {
+		p.cacheTTL = ttl
+	}
+}
+
+// NewLDAPProvider returns a LDAPProvider for the directory at the ldap:// or ldaps:// URL.
+func NewLDAPProvider(ldapURL string, options ...LDAPProviderOption) (*LDAPProvider, error) {
+	p := &LDAPProvider{
+		url:                ldapURL,
+		userFilter:         DefaultLDAPUserFilter,
+		emailAttribute:     DefaultLDAPEmailAttribute,
+		groupFilter:        DefaultLDAPGroupFilter,
+		groupNameAttribute: DefaultLDAPGroupNameAttribute,
+		cacheTTL:           DefaultLDAPCacheTTL,
+		now:                time.Now,
+		cache:              make(map[[32]byte]ldapCacheEntry),
+	}
+
+	for _, option := range options {
+		option(p)
+	}
+
+	if p.url == "" {
+		return nil, errors.New("ldap url is required")
+	}
+
+	if p.userBaseDN == "" {
+		return nil, errors.New("ldap user base dn is required")
+	}
+
+	if !strings.Contains(p.userFilter, "{username}") {
+		return nil, errors.Errorf("ldap user filter %q must contain {username}", p.userFilter)
+	}
+
+	if p.groupBaseDN != "" && !strings.Contains(p.groupFilter, "{dn}") {
+		return nil, errors.Errorf("ldap group filter %q must contain {dn}", p.groupFilter)
+	}
+
+	p.tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if p.caFile != "" {
+		bundle, err := os.ReadFile(p.caFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read ldap ca file")
+		}
+
+		p.tlsConfig.RootCAs = x509.NewCertPool()
+		if !p.tlsConfig.RootCAs.AppendCertsFromPEM(bundle) {
+			return nil, errors.Errorf("no certificates found in ldap ca file: %s", p.caFile)
+		}
+	}
+
+	// StartTLS verifies the host name of the URL as well
+	parsed, err := url.Parse(p.url)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid ldap url")
+	}
+	p.tlsConfig.ServerName = parsed.Hostname()
+
+	return p, nil
+}
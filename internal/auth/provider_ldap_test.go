@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/jimlambrt/gldap"
+	"github.com/jimlambrt/gldap/testdirectory"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testLDAPUserBaseDN  = "ou=people,dc=example,dc=org"
+	testLDAPGroupBaseDN = "ou=groups,dc=example,dc=org"
+	testLDAPBindDN      = "cn=registry,ou=services,dc=example,dc=org"
+)
+
+func testLDAPUsers(alicePassword string) []*gldap.Entry {
+	return []*gldap.Entry{
+		gldap.NewEntry(testLDAPBindDN, map[string][]string{"password": {"service"}}),
+		gldap.NewEntry("cn=alice,"+testLDAPUserBaseDN, map[string][]string{"password": {alicePassword}, "mail": {"alice@example.org"}}),
+		gldap.NewEntry("cn=bob,"+testLDAPUserBaseDN, map[string][]string{"password": {"builder"}}),
+	}
+}
+
+func TestLDAPProvider(t *testing.T) {
+	t.Parallel()
+
+	directory := testdirectory.Start(t, testdirectory.WithDefaults(t, &testdirectory.Defaults{
+		Users: testLDAPUsers("secret"),
+		Groups: []*gldap.Entry{
+			gldap.NewEntry("cn=network,"+testLDAPGroupBaseDN, map[string][]string{"cn": {"network"}, "member": {"cn=alice," + testLDAPUserBaseDN}}),
+			gldap.NewEntry("cn=platform,"+testLDAPGroupBaseDN, map[string][]string{"cn": {"platform"}, "member": {"cn=alice," + testLDAPUserBaseDN}}),
+		},
+	}))
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, []byte(directory.Cert()), 0o600))
+
+	provider, err := NewLDAPProvider(fmt.Sprintf("ldaps://%s:%d", directory.Host(), directory.Port()),
+		WithLDAPBind(testLDAPBindDN, "service"),
+		WithLDAPUserSearch(testLDAPUserBaseDN, "(cn={username})"),
+		WithLDAPGroupSearch(testLDAPGroupBaseDN, "", ""),
+		WithLDAPCAFile(caFile),
+	)
+	require.NoError(t, err)
+
+	now := time.Now()
+	provider.now = func() time.Time { return now }
+
+	app := fiber.New()
+	app.Use(Middleware(zerolog.Nop(), provider))
+	app.Get("/", func(c *fiber.Ctx) error {
+		identity, _ := IdentityFromContext(c)
+		return c.JSON(identity)
+	})
+
+	request := func(username, password string) (*http.Response, Identity) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth(username, password)
+
+		resp, err := app.Test(req, -1)
+		require.NoError(t, err)
+
+		var identity Identity
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&identity))
+		}
+
+		return resp, identity
+	}
+
+	resp, identity := request("alice", "secret")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, Identity{Subject: "alice", Email: "alice@example.org", Groups: []string{"network", "platform"}, Provider: "ldap"}, identity)
+
+	resp, identity = request("bob", "builder")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, Identity{Subject: "bob", Provider: "ldap"}, identity)
+
+	resp, _ = request("alice", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, `Basic realm="Restricted"`, resp.Header.Get(fiber.HeaderWWWAuthenticate))
+
+	resp, _ = request("mallory", "secret")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// An empty password would be an unauthenticated bind
+	resp, _ = request("alice", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// Successful binds are cached until the TTL expires
+	directory.SetUsers(testLDAPUsers("rotated")...)
+
+	resp, _ = request("alice", "secret")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	now = now.Add(DefaultLDAPCacheTTL)
+
+	resp, _ = request("alice", "secret")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, _ = request("alice", "rotated")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestNewLDAPProvider(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		annotation string
+		url        string
+		options    []LDAPProviderOption
+		wantErr    bool
+	}{
+		{
+			annotation: "defaults",
+			url:        "ldaps://ldap.example.org",
+			options:    []LDAPProviderOption{WithLDAPUserSearch(testLDAPUserBaseDN, "")},
+		},
+		{
+			annotation: "without url",
+			options:    []LDAPProviderOption{WithLDAPUserSearch(testLDAPUserBaseDN, "")},
+			wantErr:    true,
+		},
+		{
+			annotation: "without user base dn",
+			url:        "ldaps://ldap.example.org",
+			wantErr:    true,
+		},
+		{
+			annotation: "user filter without username",
+			url:        "ldaps://ldap.example.org",
+			options:    []LDAPProviderOption{WithLDAPUserSearch(testLDAPUserBaseDN, "(objectClass=person)")},
+			wantErr:    true,
+		},
+		{
+			annotation: "group filter without dn",
+			url:        "ldaps://ldap.example.org",
+			options: []LDAPProviderOption{
+				WithLDAPUserSearch(testLDAPUserBaseDN, ""),
+				WithLDAPGroupSearch(testLDAPGroupBaseDN, "(objectClass=group)", ""),
+			},
+			wantErr: true,
+		},
+		{
+			annotation: "missing ca file",
+			url:        "ldaps://ldap.example.org",
+			options: []LDAPProviderOption{
+				WithLDAPUserSearch(testLDAPUserBaseDN, ""),
+				WithLDAPCAFile(filepath.Join(t.TempDir(), "ca.pem")),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewLDAPProvider(tc.url, tc.options...)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
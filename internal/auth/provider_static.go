@@ -3,6 +3,7 @@ package auth
 import (
 	"crypto/sha256"
 	"crypto/subtle"
+	"encoding/hex"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
@@ -16,15 +17,17 @@ type StaticProvider struct {
 
 func (p *StaticProvider) String() string { return "static" }
 
-func (p *StaticProvider) Verify(ctx *fiber.Ctx, key string) (bool, error) {
+func (p *StaticProvider) Verify(ctx *fiber.Ctx, key string) (Identity, error) {
 	hashedKey := sha256.Sum256([]byte(key))
 	for _, validToken := range p.tokens {
 		if subtle.ConstantTimeCompare(validToken[:], hashedKey[:]) == 1 {
-			return true, nil
+			// Static tokens have no owner, they are told apart by a prefix of their hash
+			tokenID := hex.EncodeToString(hashedKey[:4])
+			return Identity{Subject: "static:" + tokenID, Provider: p.String(), TokenID: tokenID}, nil
 		}
 	}
 
-	return false, keyauth.ErrMissingOrMalformedAPIKey
+	return Identity{}, keyauth.ErrMissingOrMalformedAPIKey
 }
 
 func NewStaticProvider(logger zerolog.Logger, tokens ...string) Provider {
@@ -1,6 +1,9 @@
 package core
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // Module represents Terraform module metadata.
 type Module struct {
@@ -9,6 +12,25 @@ type Module struct {
 	Provider    string `json:"provider"`
 	Version     string `json:"version"`
 	DownloadURL string `json:"download_url"`
+
+	// Publish is only set when a single module version is retrieved.
+	Publish *PublishMetadata `json:"publish,omitempty"`
+}
+
+// PublishMetadata describes who published a module version, from where and when.
+type PublishMetadata struct {
+	Publisher string            `json:"publisher,omitempty"`
+	Source    string            `json:"source,omitempty"`
+	Commit    string            `json:"commit,omitempty"`
+	CIRunURL  string            `json:"ci_run_url,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+
+	// Set by the storage when the module is uploaded.
+	PublishedAt time.Time `json:"published_at,omitempty"`
+	Size        int64     `json:"size,omitempty"`
+
+	// Digest of the archive, only set by content-addressed storage.
+	Digest string `json:"digest,omitempty"`
 }
 
 // ID returns the module metadata in a compact format.
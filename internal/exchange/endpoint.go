@@ -0,0 +1,95 @@
+package exchange
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+)
+
+// Token exchange error codes and token types, see https://www.rfc-editor.org/rfc/rfc8693.
+const (
+	errorInvalidRequest = "invalid_request"
+	errorAccessDenied   = "access_denied"
+	errorServerError    = "server_error"
+
+	grantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+	tokenTypeIDToken       = "urn:ietf:params:oauth:token-type:id_token"
+	tokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// Error is returned for refused exchanges, its code and description are safe to return to the client.
+type Error struct {
+	Code        string
+	Description string
+	err         error
+}
+
+func (e *Error) Error() string { return e.err.Error() }
+
+func (e *Error) Unwrap() error { return e.err }
+
+type exchangeRequest struct {
+	GrantType        string `json:"grant_type" form:"grant_type"`
+	SubjectToken     string `json:"subject_token" form:"subject_token"`
+	SubjectTokenType string `json:"subject_token_type" form:"subject_token_type"`
+}
+
+type exchangeResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+}
+
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// exchangeEndpoint exchanges a CI identity token for a registry token. It accepts form encoded RFC 8693
+// requests, as well as JSON for clients like curl in a CI job, where only subject_token is required.
+func exchangeEndpoint(s *Server) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req exchangeRequest
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(errorResponse{Error: errorInvalidRequest, ErrorDescription: "malformed request"})
+		}
+
+		if req.GrantType != "" && req.GrantType != grantTypeTokenExchange {
+			return c.Status(fiber.StatusBadRequest).JSON(errorResponse{Error: errorInvalidRequest, ErrorDescription: "unsupported grant_type"})
+		}
+
+		if req.SubjectToken == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(errorResponse{Error: errorInvalidRequest, ErrorDescription: "missing subject_token"})
+		}
+
+		if req.SubjectTokenType != "" && req.SubjectTokenType != tokenTypeIDToken && req.SubjectTokenType != tokenTypeJWT {
+			return c.Status(fiber.StatusBadRequest).JSON(errorResponse{Error: errorInvalidRequest, ErrorDescription: "unsupported subject_token_type"})
+		}
+
+		token, ttl, err := s.Exchange(c, req.SubjectToken)
+
+		var exchangeErr *Error
+		if errors.As(err, &exchangeErr) {
+			s.logger.Warn().Err(err).Msg("refused ci identity token")
+
+			status := fiber.StatusBadRequest
+			if exchangeErr.Code == errorAccessDenied {
+				status = fiber.StatusForbidden
+			}
+			return c.Status(status).JSON(errorResponse{Error: exchangeErr.Code, ErrorDescription: exchangeErr.Description})
+		}
+		if err != nil {
+			s.logger.Error().Err(err).Msg("failed to exchange ci identity token")
+			return c.Status(fiber.StatusInternalServerError).JSON(errorResponse{Error: errorServerError})
+		}
+
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		return c.JSON(exchangeResponse{
+			AccessToken:     token,
+			IssuedTokenType: tokenTypeAccessToken,
+			TokenType:       "Bearer",
+			ExpiresIn:       int64(ttl.Seconds()),
+		})
+	}
+}
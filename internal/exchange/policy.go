@@ -0,0 +1,143 @@
+package exchange
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Policy lists the CI issuers whose identity tokens are exchanged, and which namespaces their jobs may publish to.
+type Policy struct {
+	Issuers []Issuer `yaml:"issuers"`
+}
+
+// Issuer is a trusted CI OIDC issuer, e.g. https://token.actions.githubusercontent.com or https://gitlab.com.
+type Issuer struct {
+	Issuer string `yaml:"issuer"`
+	// Audience the identity tokens must be requested for.
+	Audience string `yaml:"audience"`
+	Rules    []Rule `yaml:"rules"`
+}
+
+// Rule grants write access to the namespaces to the jobs whose claims match.
+type Rule struct {
+	// Claims are matched with path.Match against the claims of the identity token, all of them must match
+	// (e.g. repository: "acme/network-*", ref: "refs/heads/main", environment: "production").
+	Claims map[string]string `yaml:"claims"`
+	// Namespaces may contain path.Match patterns.
+	Namespaces []string `yaml:"namespaces"`
+}
+
+// LoadPolicy reads a YAML policy file.
+func LoadPolicy(file string) (Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return Policy{}, errors.Wrap(err, "failed to read exchange policy")
+	}
+
+	var policy Policy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return Policy{}, errors.Wrapf(err, "failed to parse exchange policy: %s", file)
+	}
+
+	return policy, policy.Validate()
+}
+
+// Validate ensures that a Policy is valid.
+func (p *Policy) Validate() error {
+	var result *multierror.Error
+
+	if len(p.Issuers) == 0 {
+		result = multierror.Append(result, errors.New("issuers cannot be empty"))
+	}
+
+	seen := make(map[string]bool)
+	for i, issuer := range p.Issuers {
+		if issuer.Issuer == "" {
+			result = multierror.Append(result, fmt.Errorf("issuers[%d].issuer cannot be empty", i))
+		} else if seen[issuer.Issuer] {
+			result = multierror.Append(result, fmt.Errorf("issuers[%d].issuer %s is listed twice", i, issuer.Issuer))
+		}
+		seen[issuer.Issuer] = true
+
+		if issuer.Audience == "" {
+			result = multierror.Append(result, fmt.Errorf("issuers[%d].audience cannot be empty", i))
+		}
+
+		for j, rule := range issuer.Rules {
+			// Without claims every job of the issuer would match, e.g. of any GitHub repository
+			if len(rule.Claims) == 0 {
+				result = multierror.Append(result, fmt.Errorf("issuers[%d].rules[%d].claims cannot be empty", i, j))
+			}
+
+			for claim, pattern := range rule.Claims {
+				if _, err := path.Match(pattern, ""); err != nil {
+					result = multierror.Append(result, fmt.Errorf("issuers[%d].rules[%d].claims.%s: %w", i, j, claim, err))
+				}
+			}
+
+			if len(rule.Namespaces) == 0 {
+				result = multierror.Append(result, fmt.Errorf("issuers[%d].rules[%d].namespaces cannot be empty", i, j))
+			}
+
+			for _, namespace := range rule.Namespaces {
+				if _, err := path.Match(namespace, ""); err != nil {
+					result = multierror.Append(result, fmt.Errorf("issuers[%d].rules[%d].namespaces: %w", i, j, err))
+				}
+			}
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+// Namespaces returns the namespaces the rules of the issuer grant to the claims, nil if no rule matches.
+func (i *Issuer) Namespaces(claims map[string]interface{}) []string {
+	var namespaces []string
+	seen := make(map[string]bool)
+
+	for _, rule := range i.Rules {
+		if !rule.matches(claims) {
+			continue
+		}
+
+		for _, namespace := range rule.Namespaces {
+			if !seen[namespace] {
+				namespaces = append(namespaces, namespace)
+				seen[namespace] = true
+			}
+		}
+	}
+
+	return namespaces
+}
+
+func (r *Rule) matches(claims map[string]interface{}) bool {
+	for claim, pattern := range r.Claims {
+		value, ok := claims[claim]
+		if !ok {
+			return false
+		}
+
+		// Claims like GitLab's protected are strings, others may be booleans or numbers
+		var s string
+		switch v := value.(type) {
+		case string:
+			s = v
+		case bool, float64:
+			s = fmt.Sprint(v)
+		default:
+			return false
+		}
+
+		if ok, err := path.Match(pattern, s); err != nil || !ok {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,40 @@
+package exchange
+
+import (
+	"strings"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/auth"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Provider accepts the tokens issued by the Server, restricted to the scope and namespaces granted to the job.
+type Provider struct {
+	jwt *auth.JWTProvider
+}
+
+func (p *Provider) String() string { return ProviderName }
+
+func (p *Provider) Verify(c *fiber.Ctx, token string) (auth.Identity, error) {
+	identity, err := p.jwt.Verify(c, token)
+	if err != nil {
+		return auth.Identity{}, err
+	}
+
+	scope, _ := identity.Claims["scope"].(string)
+	identity.Scopes = strings.Fields(scope)
+
+	namespaces, _ := identity.Claims["namespaces"].([]interface{})
+	for _, namespace := range namespaces {
+		if s, ok := namespace.(string); ok {
+			identity.Namespaces = append(identity.Namespaces, s)
+		}
+	}
+
+	// A token without namespaces must not be mistaken for an unrestricted one
+	if len(identity.Scopes) == 0 || len(identity.Namespaces) == 0 {
+		return auth.Identity{}, auth.ErrInvalidCredentials
+	}
+
+	return identity, nil
+}
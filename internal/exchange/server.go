@@ -0,0 +1,211 @@
+package exchange
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/auth"
+	"github.com/MichielBijland/uncomplicated-registry/internal/signing"
+
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// Path of the token exchange endpoint.
+	Path = "/v1/exchange/token"
+	// DefaultTokenTTL is how long the tokens issued by the Server are valid, long enough to publish a module.
+	DefaultTokenTTL = 15 * time.Minute
+	// ProviderName is the name of the auth.Provider accepting the tokens issued by the Server.
+	ProviderName = "exchange"
+)
+
+// Server exchanges the OIDC identity tokens of CI jobs, e.g. of GitHub Actions or GitLab CI, for short-lived
+// registry tokens. The jobs may publish to the namespaces the Policy grants to the claims of their token.
+type Server struct {
+	logger         zerolog.Logger
+	url            string
+	tokenTTL       time.Duration
+	signingKeyFile string
+
+	issuers map[string]*issuer
+	key     *signing.Key
+	now     func() time.Time
+}
+
+type issuer struct {
+	Issuer
+	verifier *auth.JWTProvider
+}
+
+// tokenClaims are the claims of a registry token issued for a CI job.
+type tokenClaims struct {
+	jwt.Claims
+	Scope      string   `json:"scope"`
+	Namespaces []string `json:"namespaces"`
+	// CIIssuer is the issuer of the exchanged identity token.
+	CIIssuer string `json:"ci_iss"`
+}
+
+// Provider returns the auth.Provider accepting the tokens issued by the Server.
+func (s *Server) Provider(ctx context.Context) (*Provider, error) {
+	jwtProvider, err := auth.NewJWTProvider(ctx, s.url, s.audience(),
+		auth.WithJWTKeySet(s.key.KeySet()),
+		auth.WithJWTName(ProviderName),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Provider{jwt: jwtProvider}, nil
+}
+
+// Exchange verifies the identity token of a CI job and returns a registry token with its lifetime.
+// An *Error describes why a token was refused.
+func (s *Server) Exchange(c *fiber.Ctx, subjectToken string) (string, time.Duration, error) {
+	issuerURL, err := unverifiedIssuer(subjectToken)
+	if err != nil {
+		return "", 0, &Error{Code: errorInvalidRequest, Description: "subject_token is not a JWT", err: err}
+	}
+
+	trusted, ok := s.issuers[issuerURL]
+	if !ok {
+		return "", 0, &Error{Code: errorInvalidRequest, Description: "untrusted issuer", err: errors.Errorf("untrusted issuer %q", issuerURL)}
+	}
+
+	identity, err := trusted.verifier.Verify(c, subjectToken)
+	if err != nil {
+		return "", 0, &Error{Code: errorInvalidRequest, Description: "invalid subject_token", err: err}
+	}
+
+	namespaces := trusted.Namespaces(identity.Claims)
+	if len(namespaces) == 0 {
+		return "", 0, &Error{Code: errorAccessDenied, Description: "no rule grants access to the job", err: errors.Errorf("no rule matches %s", identity.Subject)}
+	}
+
+	id, err := randomString()
+	if err != nil {
+		return "", 0, err
+	}
+
+	now := s.now()
+	token, err := s.key.Sign(tokenClaims{
+		Claims: jwt.Claims{
+			ID:       id,
+			Issuer:   s.url,
+			Subject:  identity.Subject,
+			Audience: jwt.Audience{s.audience()},
+			IssuedAt: jwt.NewNumericDate(now),
+			Expiry:   jwt.NewNumericDate(now.Add(s.tokenTTL)),
+		},
+		Scope:      auth.ScopeWrite,
+		Namespaces: namespaces,
+		CIIssuer:   issuerURL,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	s.logger.Info().
+		Str("ci_issuer", issuerURL).
+		Str("subject", identity.Subject).
+		Strs("namespaces", namespaces).
+		Str("token_id", id).
+		Msg("exchanged ci identity token")
+
+	return token, s.tokenTTL, nil
+}
+
+// audience returns the audience of the issued tokens. The login server issues tokens for the registry URL,
+// possibly with the same signing key, its provider must not mistake them for unrestricted tokens and vice versa.
+func (s *Server) audience() string {
+	return s.url + Path
+}
+
+// unverifiedIssuer returns the iss claim of a JWT, to select the verifier of the token.
+func unverifiedIssuer(token string) (string, error) {
+	parsed, err := jwt.ParseSigned(token)
+	if err != nil {
+		return "", err
+	}
+
+	var claims jwt.Claims
+	if err := parsed.UnsafeClaimsWithoutVerification(&claims); err != nil {
+		return "", err
+	}
+
+	return claims.Issuer, nil
+}
+
+// ServerOption provides additional options for the Server.
+type ServerOption func(*Server)
+
+// WithTokenTTL sets the lifetime of the issued tokens.
+func WithTokenTTL(ttl time.Duration) ServerOption {
+	return func(s *Server) {
+		if ttl > 0 {
+			s.tokenTTL = ttl
+		}
+	}
+}
+
+// WithSigningKeyFile signs the issued tokens with a PEM encoded private key, instead of a generated key.
+func WithSigningKeyFile(file string) ServerOption {
+	return func(s *Server) {
+		s.signingKeyFile = file
+	}
+}
+
+// NewServer returns a Server reachable at the URL of the registry, the keys of the CI issuers are discovered.
+func NewServer(ctx context.Context, logger zerolog.Logger, serverURL string, policy Policy, options ...ServerOption) (*Server, error) {
+	s := &Server{
+		logger:   logger,
+		url:      strings.TrimSuffix(serverURL, "/"),
+		tokenTTL: DefaultTokenTTL,
+		issuers:  make(map[string]*issuer),
+		now:      time.Now,
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	if s.url == "" {
+		return nil, errors.New("exchange server url is required")
+	}
+
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	for _, i := range policy.Issuers {
+		// The CI subject, e.g. repo:acme/network:ref:refs/heads/main, identifies the job
+		verifier, err := auth.NewJWTProvider(ctx, i.Issuer, i.Audience, auth.WithJWTName("ci"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to setup ci issuer %s", i.Issuer)
+		}
+		s.issuers[i.Issuer] = &issuer{Issuer: i, verifier: verifier}
+	}
+
+	var err error
+	if s.key, err = signing.LoadKey(s.signingKeyFile); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// randomString returns 32 random bytes, base64url encoded.
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate random value")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
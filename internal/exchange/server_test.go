@@ -0,0 +1,356 @@
+package exchange
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/auth"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testServerURL = "https://registry.example.com"
+
+// testIssuer is a CI OIDC issuer, signing the identity tokens of jobs with the claims.
+type testIssuer struct {
+	*httptest.Server
+	signer jose.Signer
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithHeader("kid", "ci"))
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                server.URL,
+			"jwks_uri":                              server.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: key.Public(), KeyID: "ci", Algorithm: string(jose.RS256), Use: "sig"},
+		}})
+	})
+
+	return &testIssuer{Server: server, signer: signer}
+}
+
+func (i *testIssuer) token(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+
+	defaults := map[string]interface{}{
+		"iss": i.URL,
+		"aud": testServerURL,
+		"exp": time.Now().Add(5 * time.Minute).Unix(),
+	}
+	for k, v := range claims {
+		defaults[k] = v
+	}
+
+	token, err := jwt.Signed(i.signer).Claims(defaults).CompactSerialize()
+	require.NoError(t, err)
+
+	return token
+}
+
+func TestServer_Exchange(t *testing.T) {
+	t.Parallel()
+
+	ci := newTestIssuer(t)
+	untrusted := newTestIssuer(t)
+
+	policy := Policy{Issuers: []Issuer{{
+		Issuer:   ci.URL,
+		Audience: testServerURL,
+		Rules: []Rule{
+			{
+				Claims:     map[string]string{"repository": "acme/network-*", "ref": "refs/heads/main"},
+				Namespaces: []string{"network"},
+			},
+			{
+				Claims:     map[string]string{"repository": "acme/network-*", "environment": "production"},
+				Namespaces: []string{"network", "shared"},
+			},
+		},
+	}}}
+
+	s, err := NewServer(context.Background(), zerolog.Nop(), testServerURL, policy)
+	require.NoError(t, err)
+
+	provider, err := s.Provider(context.Background())
+	require.NoError(t, err)
+
+	app := fiber.New()
+	Register(s, app)
+	app.Get("/:namespace", auth.Middleware(zerolog.Nop(), provider), auth.Authorize(zerolog.Nop(), auth.Policy{Default: auth.RoleWrite}), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	app.Put("/:namespace", auth.Middleware(zerolog.Nop(), provider), auth.Authorize(zerolog.Nop(), auth.Policy{Default: auth.RoleWrite}), func(c *fiber.Ctx) error {
+		identity, _ := auth.IdentityFromContext(c)
+		return c.JSON(identity)
+	})
+
+	testCases := []struct {
+		annotation         string
+		subjectToken       string
+		expectedStatus     int
+		expectedError      string
+		expectedNamespaces []string
+	}{
+		{
+			annotation:         "main branch",
+			subjectToken:       ci.token(t, map[string]interface{}{"sub": "repo:acme/network-vpc:ref:refs/heads/main", "repository": "acme/network-vpc", "ref": "refs/heads/main"}),
+			expectedStatus:     http.StatusOK,
+			expectedNamespaces: []string{"network"},
+		},
+		{
+			annotation:         "production environment",
+			subjectToken:       ci.token(t, map[string]interface{}{"sub": "repo:acme/network-vpc:environment:production", "repository": "acme/network-vpc", "ref": "refs/heads/main", "environment": "production"}),
+			expectedStatus:     http.StatusOK,
+			expectedNamespaces: []string{"network", "shared"},
+		},
+		{
+			annotation:     "feature branch",
+			subjectToken:   ci.token(t, map[string]interface{}{"sub": "repo:acme/network-vpc:ref:refs/heads/feature", "repository": "acme/network-vpc", "ref": "refs/heads/feature"}),
+			expectedStatus: http.StatusForbidden,
+			expectedError:  errorAccessDenied,
+		},
+		{
+			annotation:     "other repository",
+			subjectToken:   ci.token(t, map[string]interface{}{"sub": "repo:evil/network-vpc:ref:refs/heads/main", "repository": "evil/network-vpc", "ref": "refs/heads/main"}),
+			expectedStatus: http.StatusForbidden,
+			expectedError:  errorAccessDenied,
+		},
+		{
+			annotation:     "wrong audience",
+			subjectToken:   ci.token(t, map[string]interface{}{"sub": "repo:acme/network-vpc:ref:refs/heads/main", "repository": "acme/network-vpc", "ref": "refs/heads/main", "aud": "sts.amazonaws.com"}),
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  errorInvalidRequest,
+		},
+		{
+			annotation:     "expired",
+			subjectToken:   ci.token(t, map[string]interface{}{"sub": "repo:acme/network-vpc:ref:refs/heads/main", "repository": "acme/network-vpc", "ref": "refs/heads/main", "exp": time.Now().Add(-time.Minute).Unix()}),
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  errorInvalidRequest,
+		},
+		{
+			annotation:     "untrusted issuer",
+			subjectToken:   untrusted.token(t, map[string]interface{}{"sub": "repo:acme/network-vpc:ref:refs/heads/main", "repository": "acme/network-vpc", "ref": "refs/heads/main"}),
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  errorInvalidRequest,
+		},
+		{
+			annotation:     "not a jwt",
+			subjectToken:   "token",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  errorInvalidRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			form := url.Values{
+				"grant_type":         {grantTypeTokenExchange},
+				"subject_token":      {tc.subjectToken},
+				"subject_token_type": {tokenTypeIDToken},
+			}
+			req := httptest.NewRequest(http.MethodPost, Path, strings.NewReader(form.Encode()))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus != http.StatusOK {
+				var body errorResponse
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+				assert.Equal(t, tc.expectedError, body.Error)
+				return
+			}
+
+			var body exchangeResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			assert.Equal(t, "Bearer", body.TokenType)
+			assert.Equal(t, int64(DefaultTokenTTL.Seconds()), body.ExpiresIn)
+
+			// The registry token publishes to the granted namespaces only
+			req = httptest.NewRequest(http.MethodPut, "/network", nil)
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+body.AccessToken)
+			resp, err = app.Test(req)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var identity auth.Identity
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&identity))
+			assert.Equal(t, ProviderName, identity.Provider)
+			assert.Equal(t, []string{auth.ScopeWrite}, identity.Scopes)
+			assert.Equal(t, tc.expectedNamespaces, identity.Namespaces)
+
+			req = httptest.NewRequest(http.MethodPut, "/security", nil)
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+body.AccessToken)
+			resp, err = app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		})
+	}
+}
+
+func TestProvider_RejectsLoginTokens(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewServer(context.Background(), zerolog.Nop(), testServerURL, Policy{Issuers: []Issuer{{
+		Issuer:   newTestIssuer(t).URL,
+		Audience: testServerURL,
+		Rules:    []Rule{{Claims: map[string]string{"repository": "acme/*"}, Namespaces: []string{"network"}}},
+	}}})
+	require.NoError(t, err)
+
+	provider, err := s.Provider(context.Background())
+	require.NoError(t, err)
+
+	app := fiber.New()
+	app.Get("/", auth.Middleware(zerolog.Nop(), provider), func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	// The login server issues tokens for the registry URL, possibly with the same signing key
+	now := time.Now()
+	token, err := s.key.Sign(tokenClaims{
+		Claims: jwt.Claims{
+			Issuer:   testServerURL,
+			Subject:  "jdoe",
+			Audience: jwt.Audience{testServerURL},
+			IssuedAt: jwt.NewNumericDate(now),
+			Expiry:   jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+		Scope:      auth.ScopeWrite,
+		Namespaces: []string{"network"},
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestExchangeEndpoint_JSON(t *testing.T) {
+	t.Parallel()
+
+	ci := newTestIssuer(t)
+
+	s, err := NewServer(context.Background(), zerolog.Nop(), testServerURL, Policy{Issuers: []Issuer{{
+		Issuer:   ci.URL,
+		Audience: testServerURL,
+		Rules:    []Rule{{Claims: map[string]string{"project_path": "acme/*"}, Namespaces: []string{"acme"}}},
+	}}})
+	require.NoError(t, err)
+
+	app := fiber.New()
+	Register(s, app)
+
+	body, err := json.Marshal(exchangeRequest{SubjectToken: ci.token(t, map[string]interface{}{"sub": "project_path:acme/vpc", "project_path": "acme/vpc"})})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, Path, strings.NewReader(string(body)))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+	resp, err := app.Test(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "no-store", resp.Header.Get(fiber.HeaderCacheControl))
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		annotation string
+		policy     Policy
+		wantErr    bool
+	}{
+		{
+			annotation: "valid",
+			policy: Policy{Issuers: []Issuer{{
+				Issuer:   "https://token.actions.githubusercontent.com",
+				Audience: testServerURL,
+				Rules:    []Rule{{Claims: map[string]string{"repository": "acme/*"}, Namespaces: []string{"acme-*"}}},
+			}}},
+		},
+		{
+			annotation: "without issuers",
+			policy:     Policy{},
+			wantErr:    true,
+		},
+		{
+			annotation: "without audience",
+			policy: Policy{Issuers: []Issuer{{
+				Issuer: "https://token.actions.githubusercontent.com",
+				Rules:  []Rule{{Claims: map[string]string{"repository": "acme/*"}, Namespaces: []string{"acme"}}},
+			}}},
+			wantErr: true,
+		},
+		{
+			annotation: "rule without claims",
+			policy: Policy{Issuers: []Issuer{{
+				Issuer:   "https://token.actions.githubusercontent.com",
+				Audience: testServerURL,
+				Rules:    []Rule{{Namespaces: []string{"acme"}}},
+			}}},
+			wantErr: true,
+		},
+		{
+			annotation: "invalid claim pattern",
+			policy: Policy{Issuers: []Issuer{{
+				Issuer:   "https://token.actions.githubusercontent.com",
+				Audience: testServerURL,
+				Rules:    []Rule{{Claims: map[string]string{"repository": "acme/["}, Namespaces: []string{"acme"}}},
+			}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.policy.Validate()
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,11 @@
+package exchange
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// Register adds the token exchange route to the router, named by its audit action.
+func Register(s *Server, router fiber.Router, middleware ...fiber.Handler) {
+	handlers := append(append([]fiber.Handler{}, middleware...), exchangeEndpoint(s))
+	router.Post(Path, handlers...).Name("token.exchange")
+}
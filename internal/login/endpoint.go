@@ -0,0 +1,190 @@
+package login
+
+import (
+	"crypto/subtle"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"golang.org/x/oauth2"
+)
+
+// OAuth 2.0 error codes, see https://www.rfc-editor.org/rfc/rfc6749#section-4.1.2.1.
+const (
+	errorInvalidRequest          = "invalid_request"
+	errorInvalidClient           = "invalid_client"
+	errorInvalidGrant            = "invalid_grant"
+	errorAccessDenied            = "access_denied"
+	errorServerError             = "server_error"
+	errorUnsupportedResponseType = "unsupported_response_type"
+	errorUnsupportedGrantType    = "unsupported_grant_type"
+)
+
+const codeChallengeMethodS256 = "S256"
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// authorizationEndpoint validates the request of terraform login and sends the user to the upstream provider.
+func authorizationEndpoint(s *Server) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Fiber reuses the request buffers, the values outlive the request
+		clientID := utils.CopyString(c.Query("client_id"))
+		redirectURI := utils.CopyString(c.Query("redirect_uri"))
+		state := utils.CopyString(c.Query("state"))
+
+		// Without a trusted redirect URI the error can only be shown to the user
+		if clientID != s.clientID {
+			return c.Status(fiber.StatusBadRequest).JSON(errorResponse{Error: errorInvalidClient, ErrorDescription: "unknown client_id"})
+		}
+		if !s.validRedirectURI(redirectURI) {
+			return c.Status(fiber.StatusBadRequest).JSON(errorResponse{Error: errorInvalidRequest, ErrorDescription: "invalid redirect_uri"})
+		}
+
+		if c.Query("response_type") != "code" {
+			return redirectError(c, redirectURI, state, errorUnsupportedResponseType)
+		}
+
+		challenge := utils.CopyString(c.Query("code_challenge"))
+		if challenge == "" || c.Query("code_challenge_method") != codeChallengeMethodS256 {
+			return redirectError(c, redirectURI, state, errorInvalidRequest)
+		}
+
+		upstreamState, err := randomString()
+		if err != nil {
+			return redirectError(c, redirectURI, state, errorServerError)
+		}
+		verifier, err := randomString()
+		if err != nil {
+			return redirectError(c, redirectURI, state, errorServerError)
+		}
+
+		s.storeAuthorization(s.authorizations, upstreamState, &authorization{
+			clientID:      clientID,
+			redirectURI:   redirectURI,
+			state:         state,
+			codeChallenge: challenge,
+			codeVerifier:  verifier,
+			expires:       s.now().Add(authorizationTTL),
+		})
+
+		return c.Redirect(s.upstream.AuthCodeURL(upstreamState,
+			oauth2.SetAuthURLParam("code_challenge", codeChallenge(verifier)),
+			oauth2.SetAuthURLParam("code_challenge_method", codeChallengeMethodS256),
+		), fiber.StatusFound)
+	}
+}
+
+// callbackEndpoint completes the login at the upstream provider and returns an authorization code to terraform login.
+func callbackEndpoint(s *Server) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		a, ok := s.takeAuthorization(s.authorizations, c.Query("state"))
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(errorResponse{Error: errorInvalidRequest, ErrorDescription: "unknown or expired login"})
+		}
+
+		if upstreamError := c.Query("error"); upstreamError != "" {
+			s.logger.Warn().Str("error", upstreamError).Str("description", c.Query("error_description")).Msg("upstream login failed")
+			return redirectError(c, a.redirectURI, a.state, errorAccessDenied)
+		}
+
+		token, err := s.upstream.Exchange(c.UserContext(), c.Query("code"), oauth2.SetAuthURLParam("code_verifier", a.codeVerifier))
+		if err != nil {
+			s.logger.Error().Err(err).Msg("failed to exchange upstream authorization code")
+			return redirectError(c, a.redirectURI, a.state, errorServerError)
+		}
+
+		idToken, _ := token.Extra("id_token").(string)
+		if idToken == "" {
+			s.logger.Error().Msg("upstream token response has no id_token")
+			return redirectError(c, a.redirectURI, a.state, errorServerError)
+		}
+
+		a.identity, err = s.idTokens.Verify(c, idToken)
+		if err != nil {
+			s.logger.Error().Err(err).Msg("failed to verify upstream id_token")
+			return redirectError(c, a.redirectURI, a.state, errorAccessDenied)
+		}
+
+		code, err := randomString()
+		if err != nil {
+			return redirectError(c, a.redirectURI, a.state, errorServerError)
+		}
+
+		a.expires = s.now().Add(codeTTL)
+		s.storeAuthorization(s.codes, code, a)
+
+		return redirect(c, a.redirectURI, url.Values{"code": {code}, "state": {a.state}})
+	}
+}
+
+// tokenEndpoint exchanges an authorization code for a registry token.
+func tokenEndpoint(s *Server) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, "no-store")
+
+		if c.FormValue("grant_type") != "authorization_code" {
+			return c.Status(fiber.StatusBadRequest).JSON(errorResponse{Error: errorUnsupportedGrantType})
+		}
+
+		// Codes are single use, also when the exchange fails
+		a, ok := s.takeAuthorization(s.codes, c.FormValue("code"))
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(errorResponse{Error: errorInvalidGrant, ErrorDescription: "unknown or expired code"})
+		}
+
+		if c.FormValue("client_id") != a.clientID || c.FormValue("redirect_uri") != a.redirectURI {
+			return c.Status(fiber.StatusBadRequest).JSON(errorResponse{Error: errorInvalidGrant, ErrorDescription: "client_id or redirect_uri mismatch"})
+		}
+
+		challenge := codeChallenge(c.FormValue("code_verifier"))
+		if subtle.ConstantTimeCompare([]byte(challenge), []byte(a.codeChallenge)) != 1 {
+			return c.Status(fiber.StatusBadRequest).JSON(errorResponse{Error: errorInvalidGrant, ErrorDescription: "invalid code_verifier"})
+		}
+
+		token, err := s.issueToken(a.identity)
+		if err != nil {
+			s.logger.Error().Err(err).Msg("failed to issue token")
+			return c.Status(fiber.StatusInternalServerError).JSON(errorResponse{Error: errorServerError})
+		}
+
+		s.logger.Info().Str("subject", a.identity.Subject).Msg("issued registry token")
+
+		return c.JSON(tokenResponse{
+			AccessToken: token,
+			TokenType:   "bearer",
+			ExpiresIn:   int64(s.tokenTTL.Seconds()),
+		})
+	}
+}
+
+func redirectError(c *fiber.Ctx, redirectURI, state, code string) error {
+	return redirect(c, redirectURI, url.Values{"error": {code}, "state": {state}})
+}
+
+// redirect sends the user back to terraform login with the parameters added to the redirect URI.
+func redirect(c *fiber.Ctx, redirectURI string, params url.Values) error {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid redirect_uri")
+	}
+
+	query := u.Query()
+	for k, v := range params {
+		if len(v) == 1 && v[0] == "" {
+			continue
+		}
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+
+	return c.Redirect(u.String(), fiber.StatusFound)
+}
@@ -0,0 +1,315 @@
+package login
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/auth"
+	"github.com/MichielBijland/uncomplicated-registry/internal/signing"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"golang.org/x/oauth2"
+)
+
+// Paths of the endpoints of the Server, relative to its URL.
+const (
+	AuthorizationPath = "/oauth/authorization"
+	TokenPath         = "/oauth/token"
+	CallbackPath      = "/oauth/callback"
+)
+
+const (
+	// DefaultClientID is the client_id advertised to, and accepted from, terraform login.
+	DefaultClientID = "terraform-cli"
+	// DefaultTokenTTL is how long the tokens issued by the Server are valid.
+	DefaultTokenTTL = 30 * 24 * time.Hour
+	// ProviderName is the name of the auth.Provider accepting the tokens issued by the Server.
+	ProviderName = "login"
+
+	// authorizationTTL limits how long a user may take to log in at the upstream provider.
+	authorizationTTL = 10 * time.Minute
+	// codeTTL limits how long an authorization code can be exchanged for a token.
+	codeTTL = time.Minute
+)
+
+// DefaultUpstreamScopes are requested from the upstream provider.
+var DefaultUpstreamScopes = []string{oidc.ScopeOpenID, "email", "profile"}
+
+// authorization is an authorization request of terraform login, from the redirect to the upstream
+// provider until its authorization code is exchanged for a token.
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	state         string
+	codeChallenge string
+	// codeVerifier is the PKCE verifier of the request to the upstream provider.
+	codeVerifier string
+	identity     auth.Identity
+	expires      time.Time
+}
+
+// Server is an OAuth 2.0 authorization server implementing the authorization code flow with PKCE used by
+// terraform login. Users authenticate at an upstream OpenID Connect provider, after which the Server issues
+// a registry token for their identity.
+//
+// Pending authorizations are kept in memory, so all requests of a login must reach the same instance.
+type Server struct {
+	logger         zerolog.Logger
+	url            string
+	clientID       string
+	ports          []int
+	tokenTTL       time.Duration
+	signingKeyFile string
+
+	upstreamIssuer       string
+	upstreamClientID     string
+	upstreamClientSecret string
+	upstreamScopes       []string
+
+	upstream *oauth2.Config
+	idTokens *auth.JWTProvider
+	key      *signing.Key
+
+	mu             sync.Mutex
+	authorizations map[string]*authorization
+	codes          map[string]*authorization
+	now            func() time.Time
+}
+
+// ClientID returns the client_id terraform login has to use.
+func (s *Server) ClientID() string { return s.clientID }
+
+// AuthorizationURL returns the URL of the authorization endpoint.
+func (s *Server) AuthorizationURL() string { return s.url + AuthorizationPath }
+
+// TokenURL returns the URL of the token endpoint.
+func (s *Server) TokenURL() string { return s.url + TokenPath }
+
+// Provider returns the auth.Provider accepting the tokens issued by the Server.
+// Their audience is the URL of the registry, tokens issued for other audiences, e.g. exchanged CI tokens, are rejected.
+func (s *Server) Provider(ctx context.Context) (*auth.JWTProvider, error) {
+	return auth.NewJWTProvider(ctx, s.url, s.url,
+		auth.WithJWTKeySet(s.key.KeySet()),
+		auth.WithJWTName(ProviderName),
+	)
+}
+
+// tokenClaims are the claims of a registry token, named after the defaults of the auth.JWTProvider.
+type tokenClaims struct {
+	jwt.Claims
+	Email  string   `json:"email,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// issueToken signs a registry token for the identity.
+func (s *Server) issueToken(identity auth.Identity) (string, error) {
+	id, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	now := s.now()
+	claims := tokenClaims{
+		Claims: jwt.Claims{
+			ID:       id,
+			Issuer:   s.url,
+			Subject:  identity.Subject,
+			Audience: jwt.Audience{s.url},
+			IssuedAt: jwt.NewNumericDate(now),
+			Expiry:   jwt.NewNumericDate(now.Add(s.tokenTTL)),
+		},
+		Email:  identity.Email,
+		Groups: identity.Groups,
+	}
+
+	return s.key.Sign(claims)
+}
+
+// validRedirectURI reports whether the redirect URI points to the loopback listener of terraform login,
+// within the advertised port range.
+func (s *Server) validRedirectURI(redirectURI string) bool {
+	u, err := url.Parse(redirectURI)
+	if err != nil || u.Scheme != "http" || u.User != nil || u.Fragment != "" {
+		return false
+	}
+
+	if host := u.Hostname(); host != "localhost" {
+		ip := net.ParseIP(host)
+		if ip == nil || !ip.IsLoopback() {
+			return false
+		}
+	}
+
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		return false
+	}
+
+	if len(s.ports) == 2 {
+		return port >= s.ports[0] && port <= s.ports[1]
+	}
+
+	return true
+}
+
+// storeAuthorization keeps an authorization under the key until it expires, and drops expired ones.
+func (s *Server) storeAuthorization(store map[string]*authorization, key string, a *authorization) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for k, v := range store {
+		if now.After(v.expires) {
+			delete(store, k)
+		}
+	}
+
+	store[key] = a
+}
+
+// takeAuthorization removes and returns the authorization stored under the key, if it has not expired.
+func (s *Server) takeAuthorization(store map[string]*authorization, key string) (*authorization, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := store[key]
+	if !ok {
+		return nil, false
+	}
+	delete(store, key)
+
+	if s.now().After(a.expires) {
+		return nil, false
+	}
+
+	return a, true
+}
+
+// ServerOption provides additional options for the Server.
+type ServerOption func(*Server)
+
+// WithClientID sets the client_id terraform login has to use.
+func WithClientID(clientID string) ServerOption {
+	return func(s *Server) {
+		if clientID != "" {
+			s.clientID = clientID
+		}
+	}
+}
+
+// WithPorts restricts the redirect URIs to the inclusive port range terraform login may listen on.
+func WithPorts(ports []int) ServerOption {
+	return func(s *Server) {
+		s.ports = ports
+	}
+}
+
+// WithTokenTTL sets how long the issued tokens are valid.
+func WithTokenTTL(ttl time.Duration) ServerOption {
+	return func(s *Server) {
+		if ttl > 0 {
+			s.tokenTTL = ttl
+		}
+	}
+}
+
+// WithSigningKeyFile signs the tokens with the PEM encoded RSA, ECDSA or Ed25519 private key of the file.
+// Without it a key is generated at startup, which invalidates all tokens when the server restarts.
+func WithSigningKeyFile(file string) ServerOption {
+	return func(s *Server) {
+		s.signingKeyFile = file
+	}
+}
+
+// WithUpstream configures the OpenID Connect provider users authenticate with.
+func WithUpstream(issuer, clientID, clientSecret string, scopes ...string) ServerOption {
+	return func(s *Server) {
+		s.upstreamIssuer = issuer
+		s.upstreamClientID = clientID
+		s.upstreamClientSecret = clientSecret
+		if len(scopes) > 0 {
+			s.upstreamScopes = scopes
+		}
+	}
+}
+
+// NewServer returns a Server reachable at the URL of the registry.
+func NewServer(ctx context.Context, logger zerolog.Logger, serverURL string, options ...ServerOption) (*Server, error) {
+	s := &Server{
+		logger:         logger,
+		url:            strings.TrimSuffix(serverURL, "/"),
+		clientID:       DefaultClientID,
+		tokenTTL:       DefaultTokenTTL,
+		upstreamScopes: DefaultUpstreamScopes,
+		authorizations: make(map[string]*authorization),
+		codes:          make(map[string]*authorization),
+		now:            time.Now,
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	if s.url == "" {
+		return nil, errors.New("login server url is required")
+	}
+
+	if s.upstreamIssuer == "" || s.upstreamClientID == "" {
+		return nil, errors.New("login upstream issuer and client id are required")
+	}
+
+	if len(s.ports) != 0 && (len(s.ports) != 2 || s.ports[0] > s.ports[1]) {
+		return nil, errors.Errorf("login ports must be an inclusive range, got %v", s.ports)
+	}
+
+	provider, err := oidc.NewProvider(ctx, s.upstreamIssuer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to discover upstream issuer %s", s.upstreamIssuer)
+	}
+
+	s.upstream = &oauth2.Config{
+		ClientID:     s.upstreamClientID,
+		ClientSecret: s.upstreamClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  s.url + CallbackPath,
+		Scopes:       s.upstreamScopes,
+	}
+
+	s.idTokens, err = auth.NewJWTProvider(ctx, s.upstreamIssuer, s.upstreamClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.key, err = signing.LoadKey(s.signingKeyFile); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// randomString returns 32 random bytes, base64url encoded.
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate random value")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// codeChallenge returns the S256 PKCE challenge of the verifier.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,427 @@
+package login
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/auth"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testServerURL   = "https://registry.example.com"
+	testRedirectURI = "http://localhost:10000/login"
+	testVerifier    = "dBjftJeZ4CVP-mJ92K9b9d9g6YOxdgKZd1lyJ5d3Lk0"
+)
+
+// testUpstream is a minimal OpenID Connect provider, logging in every user as jdoe.
+func testUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, (&jose.SignerOptions{}).WithHeader("kid", "upstream"))
+	assert.NoError(t, err)
+
+	var (
+		mu         sync.Mutex
+		challenges = map[string]string{}
+	)
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                server.URL,
+			"authorization_endpoint":                server.URL + "/authorize",
+			"token_endpoint":                        server.URL + "/token",
+			"jwks_uri":                              server.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: key.Public(), KeyID: "upstream", Algorithm: string(jose.RS256), Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		challenges["upstream-code"] = r.URL.Query().Get("code_challenge")
+		mu.Unlock()
+
+		redirect := fmt.Sprintf("%s?code=upstream-code&state=%s", r.URL.Query().Get("redirect_uri"), r.URL.Query().Get("state"))
+		http.Redirect(w, r, redirect, http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		challenge, ok := challenges[r.FormValue("code")]
+		mu.Unlock()
+
+		if !ok || codeChallenge(r.FormValue("code_verifier")) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(errorResponse{Error: errorInvalidGrant})
+			return
+		}
+
+		idToken, err := jwt.Signed(signer).Claims(map[string]interface{}{
+			"iss":    server.URL,
+			"sub":    "jdoe",
+			"aud":    "registry",
+			"email":  "jdoe@example.com",
+			"groups": []string{"platform"},
+			"exp":    time.Now().Add(time.Hour).Unix(),
+		}).CompactSerialize()
+		assert.NoError(t, err)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "upstream-access-token",
+			"token_type":   "bearer",
+			"id_token":     idToken,
+		})
+	})
+
+	return server
+}
+
+func newTestServer(t *testing.T) (*Server, *fiber.App) {
+	t.Helper()
+
+	upstream := testUpstream(t)
+
+	s, err := NewServer(context.Background(), zerolog.Nop(), testServerURL,
+		WithPorts([]int{10000, 10010}),
+		WithUpstream(upstream.URL, "registry", "secret"),
+	)
+	assert.NoError(t, err)
+
+	app := fiber.New()
+	Register(s, app)
+
+	return s, app
+}
+
+func authorizationQuery(overrides map[string]string) string {
+	query := url.Values{
+		"client_id":             {DefaultClientID},
+		"redirect_uri":          {testRedirectURI},
+		"response_type":         {"code"},
+		"state":                 {"terraform-state"},
+		"code_challenge":        {codeChallenge(testVerifier)},
+		"code_challenge_method": {codeChallengeMethodS256},
+	}
+	for k, v := range overrides {
+		query.Set(k, v)
+	}
+
+	return query.Encode()
+}
+
+// login runs the authorization flow up to the authorization code returned to terraform login.
+func login(t *testing.T, app *fiber.App) string {
+	t.Helper()
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, AuthorizationPath+"?"+authorizationQuery(nil), nil))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+
+	// The browser follows the redirect to the upstream provider, which sends it back to the callback
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	upstreamResp, err := client.Get(resp.Header.Get(fiber.HeaderLocation))
+	assert.NoError(t, err)
+	upstreamResp.Body.Close()
+
+	callback, err := url.Parse(upstreamResp.Header.Get(fiber.HeaderLocation))
+	assert.NoError(t, err)
+	assert.Equal(t, CallbackPath, callback.Path)
+
+	resp, err = app.Test(httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get(fiber.HeaderLocation))
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:10000", location.Host)
+	assert.Equal(t, "terraform-state", location.Query().Get("state"))
+
+	return location.Query().Get("code")
+}
+
+func exchange(t *testing.T, app *fiber.App, form url.Values) (int, map[string]interface{}) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, TokenPath, strings.NewReader(form.Encode()))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+
+	var body map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+
+	return resp.StatusCode, body
+}
+
+func tokenForm(code string) url.Values {
+	return url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {DefaultClientID},
+		"redirect_uri":  {testRedirectURI},
+		"code":          {code},
+		"code_verifier": {testVerifier},
+	}
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	s, app := newTestServer(t)
+
+	code := login(t, app)
+	assert.NotEmpty(t, code)
+
+	status, body := exchange(t, app, tokenForm(code))
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "bearer", body["token_type"])
+	assert.Equal(t, DefaultTokenTTL.Seconds(), body["expires_in"])
+	token, _ := body["access_token"].(string)
+
+	// Codes are single use
+	status, body = exchange(t, app, tokenForm(code))
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, errorInvalidGrant, body["error"])
+
+	// The issued token is accepted by the auth middleware
+	provider, err := s.Provider(context.Background())
+	assert.NoError(t, err)
+
+	api := fiber.New()
+	api.Use(auth.Middleware(zerolog.Nop(), provider))
+	api.Get("/", func(c *fiber.Ctx) error {
+		identity, _ := auth.IdentityFromContext(c)
+		identity.Claims, identity.TokenID = nil, ""
+		return c.JSON(identity)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	resp, err := api.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var identity auth.Identity
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&identity))
+	assert.Equal(t, auth.Identity{Subject: "jdoe", Email: "jdoe@example.com", Groups: []string{"platform"}, Provider: ProviderName}, identity)
+
+	// Tokens for other audiences, e.g. namespace restricted CI tokens signed with the same key, are rejected
+	now := time.Now()
+	exchanged, err := s.key.Sign(jwt.Claims{
+		Issuer:   testServerURL,
+		Subject:  "repo:acme/network-vpc:ref:refs/heads/main",
+		Audience: jwt.Audience{testServerURL + "/v1/exchange/token"},
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(time.Minute)),
+	}, map[string]interface{}{"scope": auth.ScopeWrite, "namespaces": []string{"network"}})
+	assert.NoError(t, err)
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+exchanged)
+	resp, err = api.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestServer_Token(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		annotation    string
+		override      map[string]string
+		expectedError string
+	}{
+		{
+			annotation:    "invalid code verifier",
+			override:      map[string]string{"code_verifier": "guessed"},
+			expectedError: errorInvalidGrant,
+		},
+		{
+			annotation:    "other redirect uri",
+			override:      map[string]string{"redirect_uri": "http://localhost:10001/login"},
+			expectedError: errorInvalidGrant,
+		},
+		{
+			annotation:    "other client",
+			override:      map[string]string{"client_id": "other"},
+			expectedError: errorInvalidGrant,
+		},
+		{
+			annotation:    "unknown code",
+			override:      map[string]string{"code": "unknown"},
+			expectedError: errorInvalidGrant,
+		},
+		{
+			annotation:    "unsupported grant type",
+			override:      map[string]string{"grant_type": "password"},
+			expectedError: errorUnsupportedGrantType,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			_, app := newTestServer(t)
+
+			form := tokenForm(login(t, app))
+			for k, v := range tc.override {
+				form.Set(k, v)
+			}
+
+			status, body := exchange(t, app, form)
+			assert.Equal(t, http.StatusBadRequest, status)
+			assert.Equal(t, tc.expectedError, body["error"])
+		})
+	}
+}
+
+func TestServer_Authorization(t *testing.T) {
+	t.Parallel()
+
+	_, app := newTestServer(t)
+
+	testCases := []struct {
+		annotation       string
+		override         map[string]string
+		expectedStatus   int
+		expectedRedirect string
+	}{
+		{
+			annotation:     "unknown client",
+			override:       map[string]string{"client_id": "other"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			annotation:     "remote redirect uri",
+			override:       map[string]string{"redirect_uri": "http://evil.example.com:10000/login"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			annotation:     "redirect uri outside the port range",
+			override:       map[string]string{"redirect_uri": "http://localhost:8080/login"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			annotation:       "unsupported response type",
+			override:         map[string]string{"response_type": "token"},
+			expectedStatus:   http.StatusFound,
+			expectedRedirect: errorUnsupportedResponseType,
+		},
+		{
+			annotation:       "plain code challenge",
+			override:         map[string]string{"code_challenge_method": "plain"},
+			expectedStatus:   http.StatusFound,
+			expectedRedirect: errorInvalidRequest,
+		},
+		{
+			annotation:       "without code challenge",
+			override:         map[string]string{"code_challenge": ""},
+			expectedStatus:   http.StatusFound,
+			expectedRedirect: errorInvalidRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, AuthorizationPath+"?"+authorizationQuery(tc.override), nil))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedRedirect != "" {
+				location, err := url.Parse(resp.Header.Get(fiber.HeaderLocation))
+				assert.NoError(t, err)
+				assert.Equal(t, "localhost:10000", location.Host)
+				assert.Equal(t, tc.expectedRedirect, location.Query().Get("error"))
+				assert.Equal(t, "terraform-state", location.Query().Get("state"))
+			}
+		})
+	}
+
+	// Unknown logins are not redirected anywhere
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, CallbackPath+"?code=upstream-code&state=unknown", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	_, _ = io.Copy(io.Discard, resp.Body)
+}
+
+func TestServer_ValidRedirectURI(t *testing.T) {
+	t.Parallel()
+
+	s := &Server{ports: []int{10000, 10010}}
+
+	testCases := []struct {
+		redirectURI string
+		expected    bool
+	}{
+		{redirectURI: "http://localhost:10000/login", expected: true},
+		{redirectURI: "http://127.0.0.1:10010/login", expected: true},
+		{redirectURI: "http://[::1]:10005/login", expected: true},
+		{redirectURI: "http://localhost:10011/login"},
+		{redirectURI: "http://localhost/login"},
+		{redirectURI: "https://localhost:10000/login"},
+		{redirectURI: "http://registry.example.com:10000/login"},
+		{redirectURI: "http://user@localhost:10000/login"},
+		{redirectURI: "http://localhost:10000/login#fragment"},
+		{redirectURI: ""},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.redirectURI, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expected, s.validRedirectURI(tc.redirectURI))
+		})
+	}
+}
+
+func TestNewServer_InvalidOptions(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewServer(context.Background(), zerolog.Nop(), "")
+	assert.Error(t, err)
+
+	_, err = NewServer(context.Background(), zerolog.Nop(), testServerURL)
+	assert.Error(t, err)
+
+	_, err = NewServer(context.Background(), zerolog.Nop(), testServerURL,
+		WithUpstream("https://sso.example.com", "registry", "secret"),
+		WithPorts([]int{10010, 10000}),
+	)
+	assert.Error(t, err)
+}
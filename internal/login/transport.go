@@ -0,0 +1,11 @@
+package login
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+func Register(s *Server, router fiber.Router) {
+	router.Get(AuthorizationPath, authorizationEndpoint(s))
+	router.Get(CallbackPath, callbackEndpoint(s))
+	router.Post(TokenPath, tokenEndpoint(s))
+}
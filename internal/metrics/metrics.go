@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Path of the metrics endpoint.
+const Path = "/metrics"
+
+const namespace = "uncomplicated_registry"
+
+// Metrics collects the metrics of the registry in its own registry, next to the Go runtime and process metrics.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	storageDuration *prometheus.HistogramVec
+	storageErrors   *prometheus.CounterVec
+	downloads       *prometheus.CounterVec
+	authFailures    *prometheus.CounterVec
+}
+
+// New returns Metrics with all collectors registered.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, route and status code.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of the HTTP requests by method, route and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		storageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "storage_operation_duration_seconds",
+			Help:      "Latency of the storage operations by backend and operation.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"backend", "operation"}),
+		storageErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "storage_operation_errors_total",
+			Help:      "Failed storage operations by backend and operation.",
+		}, []string{"backend", "operation"}),
+		downloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "module_downloads_total",
+			Help:      "Module downloads handed out by module (namespace/name/provider).",
+		}, []string{"module"}),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "auth_failures_total",
+			Help:      "Credentials rejected by each authentication provider.",
+		}, []string{"provider"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.storageDuration,
+		m.storageErrors,
+		m.downloads,
+		m.authFailures,
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+// Middleware measures the requests. Routes are labelled by their pattern, e.g. /v1/modules/:namespace/:name/:provider/versions,
+// to keep the number of series bounded.
+func (m *Metrics) Middleware(c *fiber.Ctx) error {
+	start := time.Now()
+
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		status = fiberErr.Code
+	} else if err != nil {
+		status = fiber.StatusInternalServerError
+	}
+
+	// The route is the last one that handled the request. The method is copied, fiber reuses the request buffers
+	labels := prometheus.Labels{
+		"method": utils.CopyString(c.Method()),
+		"route":  c.Route().Path,
+		"status": strconv.Itoa(status),
+	}
+	m.requests.With(labels).Inc()
+	m.requestDuration.With(labels).Observe(time.Since(start).Seconds())
+
+	return err
+}
+
+// RecordDownload counts a module download, it can be used as a module.DownloadHook.
+func (m *Metrics) RecordDownload(_ context.Context, module core.Module) {
+	m.downloads.WithLabelValues(module.ID(false)).Inc()
+}
+
+// RecordAuthFailure counts credentials rejected by the provider, it can be used as an auth.FailureHook.
+func (m *Metrics) RecordAuthFailure(_ *fiber.Ctx, provider string, _ error) {
+	m.authFailures.WithLabelValues(provider).Inc()
+}
+
+// observeStorage records the latency and result of a storage operation.
+func (m *Metrics) observeStorage(backend, operation string, start time.Time, err error) {
+	m.storageDuration.WithLabelValues(backend, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.storageErrors.WithLabelValues(backend, operation).Inc()
+	}
+}
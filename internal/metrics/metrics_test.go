@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/auth"
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+	"github.com/MichielBijland/uncomplicated-registry/internal/module"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	m := New()
+
+	inmem := module.NewInmemStorage()
+	_, err := inmem.UploadModule(context.Background(), "acme", "vpc", "aws", "1.0.0", bytes.NewBufferString("data"), core.PublishMetadata{})
+	require.NoError(t, err)
+
+	s := m.Storage(inmem, "inmem")
+	_, ok := s.(module.ArchiveStorage)
+	assert.True(t, ok, "the instrumented storage still serves archives")
+
+	app := fiber.New()
+	app.Use(m.Middleware)
+	app.Get(Path, m.Handler())
+
+	api := app.Group("/v1/modules")
+	api.Use(auth.MiddlewareWithFailureHook(zerolog.Nop(), m.RecordAuthFailure, auth.NewStaticProvider(zerolog.Nop(), "static")))
+	module.Register(module.NewService(s, module.WithDownloadHook(m.RecordDownload)), api)
+
+	request := func(path, token string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	assert.Equal(t, http.StatusOK, request("/v1/modules/acme/vpc/aws/versions", "static").StatusCode)
+	assert.Equal(t, http.StatusNoContent, request("/v1/modules/acme/vpc/aws/1.0.0/download", "static").StatusCode)
+	assert.NotEqual(t, http.StatusOK, request("/v1/modules/acme/vpc/aws/2.0.0", "static").StatusCode)
+	assert.Equal(t, http.StatusUnauthorized, request("/v1/modules/acme/vpc/aws/versions", "invalid").StatusCode)
+
+	resp := request(Path, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	for _, expected := range []string{
+		`uncomplicated_registry_http_requests_total{method="GET",route="/v1/modules/:namespace/:name/:provider/versions",status="200"} 1`,
+		`uncomplicated_registry_http_requests_total{method="GET",route="/v1/modules/:namespace/:name/:provider/:version/download",status="204"} 1`,
+		`uncomplicated_registry_http_request_duration_seconds_count{method="GET",route="/v1/modules/:namespace/:name/:provider/versions",status="200"} 1`,
+		`uncomplicated_registry_storage_operation_duration_seconds_count{backend="inmem",operation="GetModule"} 2`,
+		`uncomplicated_registry_storage_operation_duration_seconds_count{backend="inmem",operation="ListModuleVersions"} 1`,
+		`uncomplicated_registry_storage_operation_errors_total{backend="inmem",operation="GetModule"} 1`,
+		`uncomplicated_registry_module_downloads_total{module="acme/vpc/aws"} 1`,
+		`uncomplicated_registry_auth_failures_total{provider="static"} 1`,
+	} {
+		assert.Contains(t, string(body), expected)
+	}
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+	"github.com/MichielBijland/uncomplicated-registry/internal/module"
+)
+
+// Storage returns the storage measuring the latency and errors of its operations, labelled with the backend.
+// Storages serving module archives keep serving them.
+func (m *Metrics) Storage(s module.Storage, backend string) module.Storage {
+	instrumented := &storage{Storage: s, metrics: m, backend: backend}
+	if archives, ok := s.(module.ArchiveStorage); ok {
+		return &archiveStorage{storage: instrumented, archives: archives}
+	}
+
+	return instrumented
+}
+
+type storage struct {
+	module.Storage
+	metrics *Metrics
+	backend string
+}
+
+func (s *storage) GetModule(ctx context.Context, namespace, name, provider, version string) (core.Module, error) {
+	start := time.Now()
+	res, err := s.Storage.GetModule(ctx, namespace, name, provider, version)
+	s.metrics.observeStorage(s.backend, "GetModule", start, err)
+
+	return res, err
+}
+
+func (s *storage) ListModuleVersions(ctx context.Context, namespace, name, provider string) ([]core.Module, error) {
+	start := time.Now()
+	res, err := s.Storage.ListModuleVersions(ctx, namespace, name, provider)
+	s.metrics.observeStorage(s.backend, "ListModuleVersions", start, err)
+
+	return res, err
+}
+
+func (s *storage) UploadModule(ctx context.Context, namespace, name, provider, version string, body io.Reader, publish core.PublishMetadata) (core.Module, error) {
+	start := time.Now()
+	res, err := s.Storage.UploadModule(ctx, namespace, name, provider, version, body, publish)
+	s.metrics.observeStorage(s.backend, "UploadModule", start, err)
+
+	return res, err
+}
+
+type archiveStorage struct {
+	*storage
+	archives module.ArchiveStorage
+}
+
+func (s *archiveStorage) GetModuleArchive(ctx context.Context, namespace, name, provider, version string) (io.Reader, error) {
+	start := time.Now()
+	res, err := s.archives.GetModuleArchive(ctx, namespace, name, provider, version)
+	s.metrics.observeStorage(s.backend, "GetModuleArchive", start, err)
+
+	return res, err
+}
@@ -1,7 +1,17 @@
 package module
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/auth"
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
 )
 
 type listRequest struct {
@@ -25,7 +35,7 @@ type listResponse struct {
 func listEndpoint(svc Service) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 
-		res, err := svc.ListModuleVersions(c.Context(), c.Params("namespace"), c.Params("name"), c.Params("provider"))
+		res, err := svc.ListModuleVersions(c.UserContext(), c.Params("namespace"), c.Params("name"), c.Params("provider"))
 		if err != nil {
 			return errorHandler(c, err)
 		}
@@ -52,9 +62,20 @@ func listEndpoint(svc Service) fiber.Handler {
 	}
 }
 
+func getEndpoint(svc Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		res, err := svc.GetModule(c.UserContext(), c.Params("namespace"), c.Params("name"), c.Params("provider"), c.Params("version"))
+		if err != nil {
+			return errorHandler(c, err)
+		}
+
+		return c.JSON(res)
+	}
+}
+
 func downloadEndpoint(svc Service) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		res, err := svc.GetModule(c.Context(), c.Params("namespace"), c.Params("name"), c.Params("provider"), c.Params("version"))
+		res, err := svc.DownloadModule(c.UserContext(), c.Params("namespace"), c.Params("name"), c.Params("provider"), c.Params("version"))
 		if err != nil {
 			return errorHandler(c, err)
 		}
@@ -63,3 +84,113 @@ func downloadEndpoint(svc Service) fiber.Handler {
 		return c.SendStatus(fiber.StatusNoContent)
 	}
 }
+
+func archiveEndpoint(svc Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		res, err := svc.GetModuleArchive(c.UserContext(), c.Params("namespace"), c.Params("name"), c.Params("provider"), c.Params("version"))
+		if err != nil {
+			return errorHandler(c, err)
+		}
+
+		c.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+		return c.SendStream(res)
+	}
+}
+
+// publishEndpoint uploads the archive in the request body. The publish metadata is taken from the
+// source, commit, ci_run_url and label (key=value, repeatable) query parameters, the publisher is the
+// authenticated identity of the request.
+func publishEndpoint(svc Service, maxSize int64) fiber.Handler {
+	tooLarge := fiber.NewError(fiber.StatusRequestEntityTooLarge, fmt.Sprintf("module archives are limited to %d bytes", maxSize))
+
+	return func(c *fiber.Ctx) error {
+		// Failed requests leave the rest of a streamed body unread, their connection cannot be reused
+		if c.Context().RequestBodyStream() != nil {
+			defer func() {
+				if c.Response().StatusCode() >= fiber.StatusBadRequest {
+					c.Context().SetConnectionClose()
+				}
+			}()
+		}
+
+		if int64(c.Request().Header.ContentLength()) > maxSize {
+			return errorHandler(c, tooLarge)
+		}
+
+		// Fiber reuses the request buffers, the storage may keep the values
+		publish := core.PublishMetadata{
+			Source:   utils.CopyString(c.Query("source")),
+			Commit:   utils.CopyString(c.Query("commit")),
+			CIRunURL: utils.CopyString(c.Query("ci_run_url")),
+		}
+
+		for _, label := range c.Context().QueryArgs().PeekMulti("label") {
+			key, value, ok := strings.Cut(string(label), "=")
+			if !ok || key == "" {
+				return errorHandler(c, fiber.NewError(fiber.StatusBadRequest, "labels must be key=value pairs"))
+			}
+
+			if publish.Labels == nil {
+				publish.Labels = make(map[string]string)
+			}
+			publish.Labels[key] = value
+		}
+
+		// The authenticated identity is the publisher, whatever the request claims
+		if identity, ok := auth.IdentityFromContext(c); ok {
+			publish.Publisher = identity.Name()
+		}
+
+		// Bodies are only buffered below the body limit of the server, larger bodies are streamed
+		body := c.Context().RequestBodyStream()
+		if body == nil {
+			body = bytes.NewReader(c.Body())
+		}
+		archive := &limitedReader{r: body, n: maxSize}
+
+		res, err := svc.PublishModule(c.UserContext(),
+			utils.CopyString(c.Params("namespace")),
+			utils.CopyString(c.Params("name")),
+			utils.CopyString(c.Params("provider")),
+			utils.CopyString(c.Params("version")),
+			archive,
+			publish,
+		)
+		if archive.n < 0 {
+			return errorHandler(c, tooLarge)
+		}
+		if err != nil {
+			return errorHandler(c, err)
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(res)
+	}
+}
+
+var errArchiveTooLarge = errors.New("module archive too large")
+
+// limitedReader reads at most n bytes from r. Unlike io.LimitReader it fails on larger bodies, instead of
+// silently truncating them; n is negative afterwards.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, errArchiveTooLarge
+	}
+
+	// One byte more than allowed tells whether the body exceeds the limit
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, errArchiveTooLarge
+	}
+
+	return n, err
+}
@@ -0,0 +1,11 @@
+package module
+
+import "errors"
+
+// Service errors.
+var (
+	ErrModuleNotFound      = errors.New("failed to locate module")
+	ErrModuleAlreadyExists = errors.New("module already exists")
+	ErrInvalidMetadata     = errors.New("invalid module metadata")
+	ErrArchiveNotSupported = errors.New("storage does not serve module archives")
+)
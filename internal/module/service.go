@@ -2,26 +2,52 @@ package module
 
 import (
 	"context"
+	"io"
 
 	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+
+	"github.com/pkg/errors"
 )
 
 // Service implements the Module Registry Protocol.
 // For more information see: https://www.terraform.io/docs/internals/module-registry-protocol.html.
 type Service interface {
 	GetModule(ctx context.Context, namespace, name, provider, version string) (core.Module, error)
+	DownloadModule(ctx context.Context, namespace, name, provider, version string) (core.Module, error)
 	ListModuleVersions(ctx context.Context, namespace, name, provider string) ([]core.Module, error)
+	GetModuleArchive(ctx context.Context, namespace, name, provider, version string) (io.Reader, error)
+	PublishModule(ctx context.Context, namespace, name, provider, version string, body io.Reader, publish core.PublishMetadata) (core.Module, error)
 }
 
 type service struct {
-	storage Storage
+	storage       Storage
+	downloadHooks []DownloadHook
+}
+
+// DownloadHook is called after a module download has been handed out.
+type DownloadHook func(ctx context.Context, module core.Module)
+
+// ServiceOption provides additional options for the Service.
+type ServiceOption func(*service)
+
+// WithDownloadHook registers a hook that is called for every module download.
+func WithDownloadHook(hook DownloadHook) ServiceOption {
+	return func(s *service) {
+		s.downloadHooks = append(s.downloadHooks, hook)
+	}
 }
 
 // NewService returns a fully initialized Service.
-func NewService(storage Storage) Service {
-	return &service{
+func NewService(storage Storage, options ...ServiceOption) Service {
+	s := &service{
 		storage: storage,
 	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	return s
 }
 
 func (s *service) GetModule(ctx context.Context, namespace, name, provider, version string) (core.Module, error) {
@@ -33,6 +59,19 @@ func (s *service) GetModule(ctx context.Context, namespace, name, provider, vers
 	return res, nil
 }
 
+func (s *service) DownloadModule(ctx context.Context, namespace, name, provider, version string) (core.Module, error) {
+	res, err := s.GetModule(ctx, namespace, name, provider, version)
+	if err != nil {
+		return core.Module{}, err
+	}
+
+	for _, hook := range s.downloadHooks {
+		hook(ctx, res)
+	}
+
+	return res, nil
+}
+
 func (s *service) ListModuleVersions(ctx context.Context, namespace, name, provider string) ([]core.Module, error) {
 	res, err := s.storage.ListModuleVersions(ctx, namespace, name, provider)
 	if err != nil {
@@ -41,3 +80,26 @@ func (s *service) ListModuleVersions(ctx context.Context, namespace, name, provi
 
 	return res, nil
 }
+
+func (s *service) GetModuleArchive(ctx context.Context, namespace, name, provider, version string) (io.Reader, error) {
+	archives, ok := s.storage.(ArchiveStorage)
+	if !ok {
+		return nil, ErrArchiveNotSupported
+	}
+
+	return archives.GetModuleArchive(ctx, namespace, name, provider, version)
+}
+
+func (s *service) PublishModule(ctx context.Context, namespace, name, provider, version string, body io.Reader, publish core.PublishMetadata) (core.Module, error) {
+	metadata := Metadata{
+		Namespace: namespace,
+		Name:      name,
+		Provider:  provider,
+		Version:   version,
+	}
+	if err := metadata.Validate(); err != nil {
+		return core.Module{}, errors.Wrap(ErrInvalidMetadata, err.Error())
+	}
+
+	return s.storage.UploadModule(ctx, namespace, name, provider, version, body, publish)
+}
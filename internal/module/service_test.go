@@ -2,16 +2,25 @@ package module
 
 import (
 	"archive/tar"
+	"archive/zip"
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/rand"
 	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
+	"github.com/MichielBijland/uncomplicated-registry/internal/auth"
 	"github.com/MichielBijland/uncomplicated-registry/internal/core"
 
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func testModuleData(files map[string]string) *bytes.Buffer {
@@ -37,22 +46,43 @@ func testModuleData(files map[string]string) *bytes.Buffer {
 	return buf
 }
 
+func testModuleZipData(files map[string]string) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+
+	zw := zip.NewWriter(buf)
+	defer zw.Close()
+
+	for name, moduleData := range files {
+		f, _ := zw.Create(name)
+		_, _ = f.Write([]byte(moduleData))
+	}
+
+	return buf
+}
+
 func TestService_GetModule(t *testing.T) {
 	assert := assert.New(t)
 
 	testCases := []struct {
 		name        string
 		module      core.Module
+		publish     core.PublishMetadata
 		data        io.Reader
 		expectError bool
 	}{
 		{
 			name: "valid get",
 			module: core.Module{
-				Namespace: "test",
-				Name:      "s3",
-				Provider:  "aws",
-				Version:   "1.0.0",
+				Namespace:   "test",
+				Name:        "s3",
+				Provider:    "aws",
+				Version:     "1.0.0",
+				DownloadURL: "/test/s3/aws/1.0.0/archive/test-s3-aws-1.0.0.tar.gz",
+			},
+			publish: core.PublishMetadata{
+				Publisher: "ci@example.com",
+				Commit:    "4a4faad",
+				Labels:    map[string]string{"team": "network"},
 			},
 			data: testModuleData(map[string]string{
 				"main.tf": `name = "foo"`,
@@ -82,7 +112,7 @@ func TestService_GetModule(t *testing.T) {
 				svc     = NewService(storage)
 			)
 
-			_, err := storage.UploadModule(ctx, tc.module.Namespace, tc.module.Name, tc.module.Provider, tc.module.Version, tc.data)
+			_, err := storage.UploadModule(ctx, tc.module.Namespace, tc.module.Name, tc.module.Provider, tc.module.Version, tc.data, tc.publish)
 			switch tc.expectError {
 			case true:
 				assert.Error(err)
@@ -96,6 +126,14 @@ func TestService_GetModule(t *testing.T) {
 				assert.Error(err)
 			case false:
 				assert.NoError(err)
+				if assert.NotNil(module.Publish) {
+					assert.Equal(tc.publish.Publisher, module.Publish.Publisher)
+					assert.Equal(tc.publish.Commit, module.Publish.Commit)
+					assert.Equal(tc.publish.Labels, module.Publish.Labels)
+					assert.NotZero(module.Publish.Size)
+					assert.False(module.Publish.PublishedAt.IsZero())
+				}
+				module.Publish = nil
 				assert.Equal(tc.module, module)
 			}
 		})
@@ -108,13 +146,15 @@ func TestService_ListModuleVersions(t *testing.T) {
 	testCases := []struct {
 		name        string
 		format      string
+		extension   string
 		module      core.Module
 		versions    []string
 		data        io.Reader
 		expectError bool
 	}{
 		{
-			name: "valid list default format",
+			name:      "valid list default format",
+			extension: "tar.gz",
 			module: core.Module{
 				Namespace: "test",
 				Name:      "s3",
@@ -126,8 +166,9 @@ func TestService_ListModuleVersions(t *testing.T) {
 			}),
 		},
 		{
-			name:   "valid list custom format",
-			format: "zip",
+			name:      "valid list custom format",
+			format:    "tgz",
+			extension: "tgz",
 			module: core.Module{
 				Namespace: "test",
 				Name:      "s3",
@@ -138,6 +179,19 @@ func TestService_ListModuleVersions(t *testing.T) {
 				"main.tf": `name = "foo"`,
 			}),
 		},
+		{
+			name:      "valid list detected format",
+			extension: "zip",
+			module: core.Module{
+				Namespace: "test",
+				Name:      "s3",
+				Provider:  "aws",
+			},
+			versions: []string{"1.0.0", "2.4.1"},
+			data: testModuleZipData(map[string]string{
+				"main.tf": `name = "foo"`,
+			}),
+		},
 		{
 			name: "invalid list",
 			module: core.Module{
@@ -165,8 +219,12 @@ func TestService_ListModuleVersions(t *testing.T) {
 			// Make sure this test case is actually doing something
 			assert.NotEmpty(tc.versions)
 
+			// Every version gets the same archive
+			data, err := io.ReadAll(tc.data)
+			assert.NoError(err)
+
 			for _, version := range tc.versions {
-				_, err := storage.UploadModule(ctx, tc.module.Namespace, tc.module.Name, tc.module.Provider, version, tc.data)
+				_, err := storage.UploadModule(ctx, tc.module.Namespace, tc.module.Name, tc.module.Provider, version, bytes.NewReader(data), core.PublishMetadata{})
 				switch tc.expectError {
 				case true:
 					assert.Error(err)
@@ -183,7 +241,7 @@ func TestService_ListModuleVersions(t *testing.T) {
 				assert.NoError(err)
 				versions := make([]string, 0)
 				for _, module := range modules {
-					assert.True(strings.HasSuffix(module.DownloadURL, "."+tc.format))
+					assert.True(strings.HasSuffix(module.DownloadURL, "."+tc.extension))
 					module.DownloadURL = ""
 					versions = append(versions, module.Version)
 					module.Version = ""
@@ -194,3 +252,239 @@ func TestService_ListModuleVersions(t *testing.T) {
 		})
 	}
 }
+
+func TestService_GetModuleArchive(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		ctx     = context.Background()
+		storage = NewInmemStorage()
+		svc     = NewService(storage)
+		data    = testModuleData(map[string]string{
+			"main.tf": `name = "foo"`,
+		})
+	)
+
+	expected := data.Bytes()
+
+	_, err := storage.UploadModule(ctx, "test", "s3", "aws", "1.0.0", data, core.PublishMetadata{})
+	assert.NoError(err)
+
+	archive, err := svc.GetModuleArchive(ctx, "test", "s3", "aws", "1.0.0")
+	assert.NoError(err)
+	actual, err := io.ReadAll(archive)
+	assert.NoError(err)
+	assert.Equal(expected, actual)
+
+	// The archive can be downloaded more than once
+	archive, err = svc.GetModuleArchive(ctx, "test", "s3", "aws", "1.0.0")
+	assert.NoError(err)
+	actual, err = io.ReadAll(archive)
+	assert.NoError(err)
+	assert.Equal(expected, actual)
+
+	_, err = svc.GetModuleArchive(ctx, "test", "s3", "aws", "2.0.0")
+	assert.Error(err)
+}
+
+func TestService_PublishModule(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		ctx     = context.Background()
+		storage = NewInmemStorage()
+		svc     = NewService(storage)
+		data    = testModuleData(map[string]string{
+			"main.tf": `name = "foo"`,
+		})
+	)
+
+	m, err := svc.PublishModule(ctx, "test", "s3", "aws", "1.0.0", data, core.PublishMetadata{Commit: "4a4faad"})
+	assert.NoError(err)
+	assert.Equal("test/s3/aws/1.0.0", m.ID(true))
+
+	m, err = svc.GetModule(ctx, "test", "s3", "aws", "1.0.0")
+	assert.NoError(err)
+	if assert.NotNil(m.Publish) {
+		assert.Equal("4a4faad", m.Publish.Commit)
+	}
+
+	_, err = svc.PublishModule(ctx, "test", "s3", "aws", "latest", data, core.PublishMetadata{})
+	assert.ErrorIs(err, ErrInvalidMetadata)
+}
+
+type testProvider struct {
+	identity auth.Identity
+}
+
+func (p testProvider) String() string { return "test" }
+
+func (p testProvider) Verify(_ *fiber.Ctx, _ string) (auth.Identity, error) {
+	return p.identity, nil
+}
+
+func TestPublishEndpoint(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		storage = NewInmemStorage()
+		app     = fiber.New()
+		data    = testModuleData(map[string]string{
+			"main.tf": `name = "foo"`,
+		})
+	)
+	RegisterPublish(NewService(storage), app, DefaultPublishMaxSize)
+
+	req := httptest.NewRequest(http.MethodPut, "/test/s3/aws/1.0.0?commit=4a4faad&label=team=network&label=tier=1", data)
+	resp, err := app.Test(req)
+	assert.NoError(err)
+	assert.Equal(http.StatusCreated, resp.StatusCode)
+
+	m, err := storage.GetModule(context.Background(), "test", "s3", "aws", "1.0.0")
+	assert.NoError(err)
+	if assert.NotNil(m.Publish) {
+		assert.Equal("4a4faad", m.Publish.Commit)
+		assert.Equal(map[string]string{"team": "network", "tier": "1"}, m.Publish.Labels)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/test/s3/aws/1.1.0?label=team", testModuleData(map[string]string{
+		"main.tf": `name = "foo"`,
+	}))
+	resp, err = app.Test(req)
+	assert.NoError(err)
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+
+	req = httptest.NewRequest(http.MethodPut, "/test/s3/aws/latest", testModuleData(map[string]string{
+		"main.tf": `name = "foo"`,
+	}))
+	resp, err = app.Test(req)
+	assert.NoError(err)
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestPublishEndpoint_MaxSize(t *testing.T) {
+	const (
+		bodyLimit = 1 << 10
+		maxSize   = 4 << 10
+	)
+
+	var (
+		storage = NewInmemStorage()
+		app     = fiber.New(fiber.Config{StreamRequestBody: true, BodyLimit: bodyLimit, DisableStartupMessage: true})
+	)
+	RegisterPublish(NewService(storage), app, maxSize)
+
+	// app.Test cannot send chunked bodies
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = app.Listener(ln) }()
+	t.Cleanup(func() { _ = app.Shutdown() })
+
+	// The shutdown waits for idle connections, which are reused between requests
+	transport := &http.Transport{}
+	t.Cleanup(transport.CloseIdleConnections)
+	client := &http.Client{Transport: transport}
+
+	archive := func(size int) []byte {
+		content := make([]byte, size)
+		_, err := rand.Read(content)
+		require.NoError(t, err)
+
+		return testModuleData(map[string]string{"main.tf": string(content)}).Bytes()
+	}
+
+	publish := func(version string, body io.Reader) int {
+		req, err := http.NewRequest(http.MethodPut, "http://"+ln.Addr().String()+"/test/s3/aws/"+version, body)
+		require.NoError(t, err)
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	// Archives above the body limit of the server are streamed
+	small, large := archive(2<<10), archive(6<<10)
+	require.Greater(t, len(small), bodyLimit)
+	require.Less(t, len(small), maxSize)
+	require.Greater(t, len(large), maxSize)
+
+	assert.Equal(t, http.StatusCreated, publish("1.0.0", bytes.NewReader(small)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, publish("2.0.0", bytes.NewReader(large)))
+
+	// Chunked bodies have no length upfront
+	assert.Equal(t, http.StatusCreated, publish("1.1.0", io.MultiReader(bytes.NewReader(small))))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, publish("2.1.0", io.MultiReader(bytes.NewReader(large))))
+
+	reader, err := storage.GetModuleArchive(context.Background(), "test", "s3", "aws", "1.1.0")
+	require.NoError(t, err)
+	stored, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, small, stored)
+
+	_, err = storage.GetModule(context.Background(), "test", "s3", "aws", "2.1.0")
+	assert.ErrorIs(t, err, ErrModuleNotFound)
+}
+
+func TestPublishEndpoint_Publisher(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		storage  = NewInmemStorage()
+		app      = fiber.New()
+		identity = auth.Identity{Subject: "jdoe", Email: "jdoe@example.com"}
+	)
+	RegisterPublish(NewService(storage), app, DefaultPublishMaxSize, auth.Middleware(zerolog.Nop(), testProvider{identity: identity}))
+
+	// The identity of the request is the publisher, whatever the request claims
+	req := httptest.NewRequest(http.MethodPut, "/test/s3/aws/1.0.0?publisher=someone-else", testModuleData(map[string]string{
+		"main.tf": `name = "foo"`,
+	}))
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer token")
+	resp, err := app.Test(req)
+	assert.NoError(err)
+	assert.Equal(http.StatusCreated, resp.StatusCode)
+
+	m, err := storage.GetModule(context.Background(), "test", "s3", "aws", "1.0.0")
+	assert.NoError(err)
+	if assert.NotNil(m.Publish) {
+		assert.Equal("jdoe@example.com", m.Publish.Publisher)
+	}
+}
+
+func TestEndpoint_ErrorStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		storage = NewInmemStorage()
+		svc     = NewService(storage)
+		app     = fiber.New()
+	)
+	Register(svc, app)
+	RegisterPublish(svc, app, DefaultPublishMaxSize)
+
+	publish := func() int {
+		req := httptest.NewRequest(http.MethodPut, "/test/s3/aws/1.0.0", testModuleData(map[string]string{
+			"main.tf": `name = "foo"`,
+		}))
+		resp, err := app.Test(req)
+		assert.NoError(err)
+		return resp.StatusCode
+	}
+
+	assert.Equal(http.StatusCreated, publish())
+	// Versions are immutable, the second publisher loses
+	assert.Equal(http.StatusConflict, publish())
+
+	for _, target := range []string{
+		"/test/s3/aws/2.0.0",
+		"/test/s3/aws/2.0.0/download",
+		"/test/s3/aws/2.0.0/archive/test-s3-aws-2.0.0.tar.gz",
+		"/test/s3/gcp/versions",
+	} {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, target, nil))
+		assert.NoError(err)
+		assert.Equal(http.StatusNotFound, resp.StatusCode, target)
+	}
+}
@@ -11,5 +11,11 @@ import (
 type Storage interface {
 	GetModule(ctx context.Context, namespace, name, provider, version string) (core.Module, error)
 	ListModuleVersions(ctx context.Context, namespace, name, provider string) ([]core.Module, error)
-	UploadModule(ctx context.Context, namespace, name, provider, version string, body io.Reader) (core.Module, error)
+	UploadModule(ctx context.Context, namespace, name, provider, version string, body io.Reader, publish core.PublishMetadata) (core.Module, error)
+}
+
+// ArchiveStorage is implemented by storages that serve module archives through the registry
+// instead of handing out a download URL of their own.
+type ArchiveStorage interface {
+	GetModuleArchive(ctx context.Context, namespace, name, provider, version string) (io.Reader, error)
 }
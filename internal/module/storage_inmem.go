@@ -1,24 +1,45 @@
 package module
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"os"
 	"path"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+	"github.com/MichielBijland/uncomplicated-registry/internal/utils"
 
 	"github.com/pkg/errors"
 )
 
 // InmemStorage is a Storage implementation
-// This storage is typically used for testing purposes.
+// Module archives are kept in memory and served through the registry itself,
+// which makes this storage suitable for demos and end-to-end tests.
 type InmemStorage struct {
-	mu            sync.RWMutex
-	modules       map[string]core.Module
-	moduleData    map[string]io.Reader
-	archiveFormat string
+	mu             sync.RWMutex
+	modules        map[string]core.Module
+	moduleData     map[string][]byte
+	formats        map[string]string
+	archiveFormat  string
+	downloadPrefix string
+	snapshotPath   string
+}
+
+// inmemSnapshot is the on-disk representation of an InmemStorage.
+type inmemSnapshot struct {
+	Modules []inmemSnapshotModule `json:"modules"`
+}
+
+type inmemSnapshotModule struct {
+	core.Module
+	Format string `json:"format,omitempty"`
+	Data   []byte `json:"data"`
 }
 
 // GetModule retrieves information about a module from the in-memory storage.
@@ -34,9 +55,11 @@ func (s *InmemStorage) GetModule(_ context.Context, namespace, name, provider, v
 	}
 	module, ok := s.modules[m.ID(true)]
 	if !ok {
-		return core.Module{}, errors.Wrap(errors.New("module not found"), "id")
+		return core.Module{}, errors.Wrap(ErrModuleNotFound, m.ID(true))
 	}
 
+	module.DownloadURL = s.downloadURL(module)
+
 	return module, nil
 }
 
@@ -48,20 +71,20 @@ func (s *InmemStorage) ListModuleVersions(ctx context.Context, namespace, name,
 
 	for _, module := range s.modules {
 		if module.Namespace == namespace && module.Name == name && module.Provider == provider {
-			f := fmt.Sprintf("%s-%s-%s-%s.%s", namespace, name, provider, module.Version, s.archiveFormat)
-			module.DownloadURL = path.Join("prefix", "inmem", namespace, name, provider, f)
+			module.DownloadURL = s.downloadURL(module)
+			module.Publish = nil
 			modules = append(modules, module)
 		}
 	}
 
 	if len(modules) == 0 {
-		return nil, errors.Errorf("no modules found for namespace=%s name=%s provider=%s", namespace, name, provider)
+		return nil, errors.Wrapf(ErrModuleNotFound, "no modules found for namespace=%s name=%s provider=%s", namespace, name, provider)
 	}
 
 	return modules, nil
 }
 
-func (s *InmemStorage) UploadModule(ctx context.Context, namespace, name, provider, version string, body io.Reader) (core.Module, error) {
+func (s *InmemStorage) UploadModule(ctx context.Context, namespace, name, provider, version string, body io.Reader, publish core.PublishMetadata) (core.Module, error) {
 	if namespace == "" {
 		return core.Module{}, errors.New("namespace not defined")
 	}
@@ -78,44 +101,229 @@ func (s *InmemStorage) UploadModule(ctx context.Context, namespace, name, provid
 		return core.Module{}, errors.New("version not defined")
 	}
 
+	// Read the body before taking the lock, the reader might be slow.
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return core.Module{}, errors.Wrap(err, "failed to read module")
+	}
+
 	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	format := utils.DetectArchiveFormat(data, s.archiveFormat)
+
+	publish.PublishedAt = time.Now().UTC()
+	publish.Size = int64(len(data))
 
 	m := core.Module{
 		Namespace: namespace,
 		Name:      name,
 		Provider:  provider,
 		Version:   version,
+		Publish:   &publish,
 	}
 
 	id := m.ID(true)
 	if _, ok := s.modules[id]; ok {
-		return core.Module{}, errors.Wrap(errors.New("exists already"), "id")
+		return core.Module{}, errors.Wrap(ErrModuleAlreadyExists, id)
 	}
 
 	s.modules[id] = m
+	s.moduleData[id] = data
+	s.formats[id] = format
+
+	if err := s.snapshot(); err != nil {
+		delete(s.modules, id)
+		delete(s.moduleData, id)
+		delete(s.formats, id)
+		return core.Module{}, err
+	}
+
+	m.DownloadURL = s.downloadURL(m)
+
+	return m, nil
+}
+
+// ListModules returns all module versions in the in-memory storage.
+func (s *InmemStorage) ListModules(_ context.Context) ([]core.Module, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	modules := make([]core.Module, 0, len(s.modules))
+	for _, module := range s.modules {
+		module.DownloadURL = s.downloadURL(module)
+		module.Publish = nil
+		modules = append(modules, module)
+	}
+
+	return modules, nil
+}
+
+// DeleteModule removes a module version from the in-memory storage.
+func (s *InmemStorage) DeleteModule(_ context.Context, namespace, name, provider, version string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := core.Module{
+		Namespace: namespace,
+		Name:      name,
+		Provider:  provider,
+		Version:   version,
+	}
+
+	id := m.ID(true)
+	module, ok := s.modules[id]
+	if !ok {
+		return errors.Wrap(ErrModuleNotFound, id)
+	}
+	data, format := s.moduleData[id], s.formats[id]
+
+	delete(s.modules, id)
+	delete(s.moduleData, id)
+	delete(s.formats, id)
+
+	if err := s.snapshot(); err != nil {
+		s.modules[id] = module
+		s.moduleData[id] = data
+		s.formats[id] = format
+		return err
+	}
+
+	return nil
+}
+
+// GetModuleArchive returns the archive of a module stored in memory.
+func (s *InmemStorage) GetModuleArchive(_ context.Context, namespace, name, provider, version string) (io.Reader, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	s.moduleData[id] = body
-	s.mu.Unlock()
+	m := core.Module{
+		Namespace: namespace,
+		Name:      name,
+		Provider:  provider,
+		Version:   version,
+	}
+	data, ok := s.moduleData[m.ID(true)]
+	if !ok {
+		return nil, errors.Wrap(ErrModuleNotFound, m.ID(true))
+	}
+
+	return bytes.NewReader(data), nil
+}
+
+// Restore loads the modules from the snapshot file, if one is configured and exists.
+func (s *InmemStorage) Restore() error {
+	if s.snapshotPath == "" {
+		return nil
+	}
+
+	f, err := os.Open(s.snapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "failed to open snapshot")
+	}
+	defer f.Close()
+
+	var snapshot inmemSnapshot
+	if err := json.NewDecoder(f).Decode(&snapshot); err != nil {
+		return errors.Wrapf(err, "failed to decode snapshot: %s", s.snapshotPath)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, module := range snapshot.Modules {
+		id := module.ID(true)
+		s.modules[id] = module.Module
+		s.moduleData[id] = module.Data
+		s.formats[id] = module.Format
+	}
+
+	return nil
+}
 
-	return s.GetModule(ctx, namespace, name, provider, version)
+// snapshot writes all modules to the snapshot file, if one is configured.
+// The caller must hold the write lock.
+func (s *InmemStorage) snapshot() error {
+	if s.snapshotPath == "" {
+		return nil
+	}
+
+	var snapshot inmemSnapshot
+	for id, module := range s.modules {
+		snapshot.Modules = append(snapshot.Modules, inmemSnapshotModule{
+			Module: module,
+			Format: s.formats[id],
+			Data:   s.moduleData[id],
+		})
+	}
+
+	// Write to a temporary file first, so an interrupted write never corrupts an existing snapshot.
+	tmp, err := os.CreateTemp(filepath.Dir(s.snapshotPath), filepath.Base(s.snapshotPath)+".*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create snapshot")
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := json.NewEncoder(tmp).Encode(snapshot); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write snapshot")
+	}
+
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write snapshot")
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), s.snapshotPath), "failed to write snapshot")
+}
+
+// downloadURL returns the archive route of a module, with the archive format the module was uploaded in.
+// The caller must hold the lock.
+func (s *InmemStorage) downloadURL(m core.Module) string {
+	format := s.formats[m.ID(true)]
+	if format == "" {
+		format = s.archiveFormat
+	}
+
+	f := fmt.Sprintf("%s-%s-%s-%s.%s", m.Namespace, m.Name, m.Provider, m.Version, format)
+	return path.Join("/", s.downloadPrefix, m.Namespace, m.Name, m.Provider, m.Version, "archive", f)
 }
 
 // InmemStorageOption provides additional options for the InmemStorage.
 type InmemStorageOption func(*InmemStorage)
 
-// WithInmemArchiveFormat configures the module archive format (zip, tar, tgz, etc.)
+// WithInmemArchiveFormat configures the module archive format (tar.gz, tgz or zip) used for archives
+// whose format cannot be detected from their content.
 func WithInmemArchiveFormat(archiveFormat string) InmemStorageOption {
 	return func(s *InmemStorage) {
 		s.archiveFormat = archiveFormat
 	}
 }
 
+// WithInmemDownloadPrefix configures the path under which the module routes are registered,
+// used to build the download URLs.
+func WithInmemDownloadPrefix(prefix string) InmemStorageOption {
+	return func(s *InmemStorage) {
+		s.downloadPrefix = prefix
+	}
+}
+
+// WithInmemSnapshotPath configures a file the storage is written to after every upload.
+// Use Restore to load the file.
+func WithInmemSnapshotPath(snapshotPath string) InmemStorageOption {
+	return func(s *InmemStorage) {
+		s.snapshotPath = snapshotPath
+	}
+}
+
 // NewInmemStorage returns a fully initialized in-memory storage.
-func NewInmemStorage(options ...InmemStorageOption) Storage {
+func NewInmemStorage(options ...InmemStorageOption) *InmemStorage {
 	s := &InmemStorage{
 		modules:       make(map[string]core.Module),
-		moduleData:    make(map[string]io.Reader),
-		archiveFormat: "tar.gz",
+		moduleData:    make(map[string][]byte),
+		formats:       make(map[string]string),
+		archiveFormat: utils.ArchiveFormatTarGz,
 	}
 
 	for _, option := range options {
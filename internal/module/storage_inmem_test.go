@@ -0,0 +1,46 @@
+package module
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInmemStorage_Restore(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		ctx      = context.Background()
+		snapshot = filepath.Join(t.TempDir(), "snapshot.json")
+		data     = testModuleData(map[string]string{
+			"main.tf": `name = "foo"`,
+		})
+	)
+
+	expected := data.Bytes()
+
+	// Restoring from a missing snapshot is not an error
+	storage := NewInmemStorage(WithInmemSnapshotPath(snapshot))
+	assert.NoError(storage.Restore())
+
+	uploaded, err := storage.UploadModule(ctx, "test", "s3", "aws", "1.0.0", data, core.PublishMetadata{})
+	assert.NoError(err)
+
+	restored := NewInmemStorage(WithInmemSnapshotPath(snapshot))
+	assert.NoError(restored.Restore())
+
+	module, err := restored.GetModule(ctx, "test", "s3", "aws", "1.0.0")
+	assert.NoError(err)
+	assert.Equal(uploaded, module)
+
+	archive, err := restored.GetModuleArchive(ctx, "test", "s3", "aws", "1.0.0")
+	assert.NoError(err)
+	actual, err := io.ReadAll(archive)
+	assert.NoError(err)
+	assert.Equal(expected, actual)
+}
@@ -1,22 +1,48 @@
 package module
 
 import (
+	"errors"
+
 	"github.com/gofiber/fiber/v2"
 )
 
-func Register(svc Service, router fiber.Router) {
-	router.Get("/:namespace/:name/:provider/versions", listEndpoint(svc))
-	router.Get("/:namespace/:name/:provider/:version/download", downloadEndpoint(svc))
+// Register adds the module routes to the router, the middleware runs in front of each route with its parameters.
+// The routes are named by their audit action.
+func Register(svc Service, router fiber.Router, middleware ...fiber.Handler) {
+	router.Get("/:namespace/:name/:provider/versions", withMiddleware(listEndpoint(svc), middleware)...).Name("module.list")
+	router.Get("/:namespace/:name/:provider/:version", withMiddleware(getEndpoint(svc), middleware)...).Name("module.get")
+	router.Get("/:namespace/:name/:provider/:version/download", withMiddleware(downloadEndpoint(svc), middleware)...).Name("module.download")
+	router.Get("/:namespace/:name/:provider/:version/archive/:file", withMiddleware(archiveEndpoint(svc), middleware)...).Name("module.archive")
+}
+
+// DefaultPublishMaxSize is the default maximum size of the archives uploaded to the publish route.
+const DefaultPublishMaxSize = 100 << 20
+
+// RegisterPublish adds the route publishing modules to the router, it accepts archives of at most maxSize bytes.
+// The archive is streamed to the storage if the server streams request bodies (fiber.Config.StreamRequestBody).
+func RegisterPublish(svc Service, router fiber.Router, maxSize int64, middleware ...fiber.Handler) {
+	router.Put("/:namespace/:name/:provider/:version", withMiddleware(publishEndpoint(svc, maxSize), middleware)...).Name("module.publish")
+}
+
+func withMiddleware(endpoint fiber.Handler, middleware []fiber.Handler) []fiber.Handler {
+	return append(append([]fiber.Handler{}, middleware...), endpoint)
 }
 
 func errorHandler(c *fiber.Ctx, err error) error {
-	errors := []string{err.Error()}
 	response := fiber.Map{
-		"errors": errors,
+		"errors": []string{err.Error()},
 	}
-	switch err.(type) {
-	case *fiber.Error:
-		return c.Status(err.(*fiber.Error).Code).JSON(response)
+
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &fiberErr):
+		return c.Status(fiberErr.Code).JSON(response)
+	case errors.Is(err, ErrInvalidMetadata):
+		return c.Status(fiber.StatusBadRequest).JSON(response)
+	case errors.Is(err, ErrModuleNotFound), errors.Is(err, ErrArchiveNotSupported):
+		return c.Status(fiber.StatusNotFound).JSON(response)
+	case errors.Is(err, ErrModuleAlreadyExists):
+		return c.Status(fiber.StatusConflict).JSON(response)
 	default:
 		return c.Status(fiber.StatusInternalServerError).JSON(response)
 	}
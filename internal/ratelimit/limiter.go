@@ -0,0 +1,176 @@
+package ratelimit
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/auth"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"golang.org/x/time/rate"
+)
+
+// sweepInterval is how often buckets that refilled completely are dropped.
+const sweepInterval = time.Minute
+
+// Limiter enforces a Policy with a token bucket per route group and identity or IP address.
+type Limiter struct {
+	logger zerolog.Logger
+	policy Policy
+	now    func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	limiter *rate.Limiter
+	// idle is how long it takes an empty bucket to refill, after which it is equal to a new one.
+	idle     time.Duration
+	lastSeen time.Time
+}
+
+// NewLimiter returns a Limiter enforcing the policy.
+func NewLimiter(logger zerolog.Logger, policy Policy) *Limiter {
+	return &Limiter{
+		logger:  logger,
+		policy:  policy,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// ipReservationKey is the fiber.Ctx locals key of the token IPHandler took for the request.
+const ipReservationKey = "ratelimit.ip"
+
+// IPHandler limits the requests of each IP address. It runs in front of the authentication middleware,
+// so requests failing authentication, e.g. guessing credentials, are limited as well.
+// Limited requests are answered with 429 Too Many Requests and a Retry-After header.
+func (l *Limiter) IPHandler(c *fiber.Ctx) error {
+	group, limits := l.policy.group(c.Method(), c.Path())
+	if limits.IP == nil {
+		return c.Next()
+	}
+
+	r, delay := l.take(group+"\x00ip\x00"+c.IP(), limits.IP)
+	if delay > 0 {
+		return l.limited(c, group, delay, auth.Identity{})
+	}
+
+	c.Locals(ipReservationKey, r)
+
+	return c.Next()
+}
+
+// IdentityHandler limits the requests of each identity, it runs after the authentication middleware.
+// Exempt identities, and requests limited by their identity, get back the token IPHandler took.
+func (l *Limiter) IdentityHandler(c *fiber.Ctx) error {
+	identity, _ := auth.IdentityFromContext(c)
+	ipReservation, _ := c.Locals(ipReservationKey).(*rate.Reservation)
+
+	if l.policy.Exempt.exempts(identity) {
+		l.refund(ipReservation)
+		return c.Next()
+	}
+
+	// Anonymous requests are only limited by their IP address
+	group, limits := l.policy.group(c.Method(), c.Path())
+	if limits.Identity == nil || identity.Subject == "" {
+		return c.Next()
+	}
+
+	_, delay := l.take(group+"\x00identity\x00"+identity.Provider+":"+identity.Subject, limits.Identity)
+	if delay > 0 {
+		l.refund(ipReservation)
+		return l.limited(c, group, delay, identity)
+	}
+
+	return c.Next()
+}
+
+// limited answers a request that has to wait for the delay.
+func (l *Limiter) limited(c *fiber.Ctx, group string, delay time.Duration, identity auth.Identity) error {
+	retryAfter := int(math.Ceil(delay.Seconds()))
+
+	l.logger.Warn().
+		Str("group", group).
+		Str("ip", c.IP()).
+		Str("subject", identity.Subject).
+		Str("provider", identity.Provider).
+		Int("retry_after", retryAfter).
+		Str("method", c.Method()).
+		Str("path", c.Path()).
+		Msg("rate limit exceeded")
+
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"errors": []string{"Too Many Requests"},
+	})
+}
+
+// take takes a token from the bucket with the key, and returns its reservation and how long the request
+// has to wait for it. No token is taken when it has to wait, so rejected requests do not extend the wait.
+func (l *Limiter) take(key string, limit *Limit) (*rate.Reservation, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	r := l.bucket(now, key, limit).ReserveN(now, 1)
+	delay := r.DelayFrom(now)
+	if delay > 0 {
+		r.CancelAt(now)
+	}
+
+	return r, delay
+}
+
+// refund returns the token of a reservation taken by take, if any.
+func (l *Limiter) refund(r *rate.Reservation) {
+	if r == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r.CancelAt(l.now())
+}
+
+func (l *Limiter) bucket(now time.Time, key string, limit *Limit) *rate.Limiter {
+	b, ok := l.buckets[key]
+	if !ok {
+		burst := limit.Burst
+		if burst == 0 {
+			burst = limit.Requests
+		}
+
+		b = &bucket{
+			limiter: rate.NewLimiter(rate.Limit(float64(limit.Requests)/limit.Period.Seconds()), burst),
+			idle:    limit.Period * time.Duration(burst) / time.Duration(limit.Requests),
+		}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	return b.limiter
+}
+
+// sweep drops the buckets that refilled completely, so clients that went away do not accumulate.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) >= b.idle {
+			delete(l.buckets, key)
+		}
+	}
+}
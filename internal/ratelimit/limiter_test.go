@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/auth"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testProvider authenticates the bearer token as the subject, mirror is a member of the mirrors group.
+// The token invalid is rejected.
+type testProvider struct{}
+
+func (p testProvider) String() string { return "test" }
+
+func (p testProvider) Verify(_ *fiber.Ctx, key string) (auth.Identity, error) {
+	if key == "invalid" {
+		return auth.Identity{}, auth.ErrInvalidCredentials
+	}
+
+	identity := auth.Identity{Subject: key}
+	if key == "mirror" {
+		identity.Groups = []string{"mirrors"}
+	}
+
+	return identity, nil
+}
+
+func TestLimiter(t *testing.T) {
+	t.Parallel()
+
+	policy := Policy{
+		Default: Limits{
+			Identity: &Limit{Requests: 10, Period: time.Minute},
+		},
+		Groups: []Group{{
+			Name:    "versions",
+			Methods: []string{http.MethodGet},
+			Paths:   []string{"/v1/modules/*/*/*/versions"},
+			Limits: Limits{
+				Identity: &Limit{Requests: 1, Period: time.Minute, Burst: 2},
+				IP:       &Limit{Requests: 1, Period: time.Minute, Burst: 3},
+			},
+		}},
+		Exempt: Exempt{Groups: []string{"mirrors"}},
+	}
+	require.NoError(t, policy.Validate())
+
+	limiter := NewLimiter(zerolog.Nop(), policy)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	app := fiber.New(fiber.Config{ProxyHeader: fiber.HeaderXForwardedFor})
+	authenticate := auth.Middleware(zerolog.Nop(), testProvider{})
+	app.Get("/v1/modules/:namespace/:name/:provider/versions", limiter.IPHandler, auth.AnonymousReads(authenticate, nil), limiter.IdentityHandler, func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+	app.Get("/v1/modules/:namespace/:name/:provider/:version", limiter.IPHandler, auth.AnonymousReads(authenticate, nil), limiter.IdentityHandler, func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	request := func(path, ip, token string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(fiber.HeaderXForwardedFor, ip)
+		if token != "" {
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+		}
+
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	const versions = "/v1/modules/acme/vpc/aws/versions"
+
+	// The identity spends its burst, from any address
+	assert.Equal(t, http.StatusOK, request(versions, "10.0.0.1", "pipeline").StatusCode)
+	assert.Equal(t, http.StatusOK, request(versions, "10.0.0.2", "pipeline").StatusCode)
+
+	resp := request(versions, "10.0.0.3", "pipeline")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "60", resp.Header.Get(fiber.HeaderRetryAfter))
+
+	// Other identities and route groups have their own buckets
+	assert.Equal(t, http.StatusOK, request(versions, "10.0.0.3", "alice").StatusCode)
+	assert.Equal(t, http.StatusOK, request("/v1/modules/acme/vpc/aws/1.0.0", "10.0.0.1", "pipeline").StatusCode)
+
+	// Anonymous requests are limited by their address, whose burst the requests above spent partly
+	assert.Equal(t, http.StatusOK, request(versions, "10.0.0.1", "").StatusCode)
+	assert.Equal(t, http.StatusOK, request(versions, "10.0.0.1", "").StatusCode)
+
+	resp = request(versions, "10.0.0.1", "")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "60", resp.Header.Get(fiber.HeaderRetryAfter))
+
+	// Exempt identities are not limited and do not spend the tokens of their address,
+	// but cannot pass an address that is limited already
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, request(versions, "10.0.0.6", "mirror").StatusCode)
+	}
+	assert.Equal(t, http.StatusOK, request(versions, "10.0.0.6", "").StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, request(versions, "10.0.0.1", "mirror").StatusCode)
+
+	// Failed authentications are limited by their address
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusUnauthorized, request(versions, "10.0.0.7", "invalid").StatusCode)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, request(versions, "10.0.0.7", "invalid").StatusCode)
+
+	// Rejected requests do not take tokens, so the bucket refills at its rate
+	now = now.Add(30 * time.Second)
+
+	resp = request(versions, "10.0.0.4", "pipeline")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "30", resp.Header.Get(fiber.HeaderRetryAfter))
+
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, http.StatusOK, request(versions, "10.0.0.4", "pipeline").StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, request(versions, "10.0.0.4", "pipeline").StatusCode)
+
+	// Buckets that refilled completely are dropped
+	now = now.Add(time.Hour)
+	request(versions, "10.0.0.5", "")
+	assert.Len(t, limiter.buckets, 1)
+}
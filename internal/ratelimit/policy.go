@@ -0,0 +1,195 @@
+package ratelimit
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/auth"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Policy limits the requests of identities and IP addresses. A request is limited by the first group
+// matching it, or by the default limits when no group matches.
+type Policy struct {
+	Default Limits  `yaml:"default"`
+	Groups  []Group `yaml:"groups"`
+	Exempt  Exempt  `yaml:"exempt"`
+}
+
+// Limits of a route group, requests are only limited by the limits that are set.
+type Limits struct {
+	// Identity limits the requests of each authenticated identity.
+	Identity *Limit `yaml:"identity"`
+	// IP limits the requests of each client IP address, authenticated or not, including failed authentications.
+	IP *Limit `yaml:"ip"`
+}
+
+// Limit is a token bucket refilled with Requests tokens per Period, holding up to Burst tokens.
+type Limit struct {
+	Requests int           `yaml:"requests"`
+	Period   time.Duration `yaml:"period"`
+	// Burst defaults to Requests.
+	Burst int `yaml:"burst"`
+}
+
+// Group limits the routes matching its methods and paths separately from the other routes.
+type Group struct {
+	Name string `yaml:"name"`
+	// Methods the group applies to, all methods without them.
+	Methods []string `yaml:"methods"`
+	// Paths are matched with path.Match against the request path (e.g. "/v1/modules/*/*/*/versions").
+	Paths  []string `yaml:"paths"`
+	Limits `yaml:",inline"`
+}
+
+// Exempt selects the identities that are not limited, e.g. a mirror job. Any match is sufficient.
+// The IP address of a request is limited before its identity is known, so exempt identities do not spend
+// its tokens, but are rejected once other requests from the address exhausted them.
+type Exempt struct {
+	// Subjects are matched with path.Match against the subject of the identity.
+	Subjects []string `yaml:"subjects"`
+	Groups   []string `yaml:"groups"`
+}
+
+// LoadPolicy reads a YAML policy file.
+func LoadPolicy(file string) (Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return Policy{}, errors.Wrap(err, "failed to read rate limit policy")
+	}
+
+	var policy Policy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return Policy{}, errors.Wrapf(err, "failed to parse rate limit policy: %s", file)
+	}
+
+	return policy, policy.Validate()
+}
+
+// Validate ensures that a Policy is valid.
+func (p *Policy) Validate() error {
+	var result *multierror.Error
+
+	result = multierror.Append(result, p.Default.validate("default")...)
+
+	seen := make(map[string]bool)
+	for i, group := range p.Groups {
+		field := fmt.Sprintf("groups[%d]", i)
+
+		if group.Name == "" {
+			result = multierror.Append(result, fmt.Errorf("%s.name cannot be empty", field))
+		} else if seen[group.Name] {
+			result = multierror.Append(result, fmt.Errorf("%s.name %s is listed twice", field, group.Name))
+		}
+		seen[group.Name] = true
+
+		if len(group.Paths) == 0 {
+			result = multierror.Append(result, fmt.Errorf("%s.paths cannot be empty", field))
+		}
+
+		for _, pattern := range group.Paths {
+			if _, err := path.Match(pattern, ""); err != nil {
+				result = multierror.Append(result, fmt.Errorf("%s.paths: %w", field, err))
+			}
+		}
+
+		result = multierror.Append(result, group.validate(field)...)
+	}
+
+	for _, pattern := range append(append([]string{}, p.Exempt.Subjects...), p.Exempt.Groups...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			result = multierror.Append(result, fmt.Errorf("exempt: %w", err))
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+func (l *Limits) validate(field string) []error {
+	return append(l.Identity.validate(field+".identity"), l.IP.validate(field+".ip")...)
+}
+
+func (l *Limit) validate(field string) []error {
+	if l == nil {
+		return nil
+	}
+
+	var errs []error
+
+	if l.Requests <= 0 {
+		errs = append(errs, fmt.Errorf("%s.requests must be positive", field))
+	}
+
+	if l.Period <= 0 {
+		errs = append(errs, fmt.Errorf("%s.period must be positive", field))
+	}
+
+	if l.Burst < 0 {
+		errs = append(errs, fmt.Errorf("%s.burst cannot be negative", field))
+	}
+
+	return errs
+}
+
+// group returns the name and limits of the request, the default group has no name.
+func (p *Policy) group(method, requestPath string) (string, Limits) {
+	for _, group := range p.Groups {
+		if group.matches(method, requestPath) {
+			return group.Name, group.Limits
+		}
+	}
+
+	return "", p.Default
+}
+
+func (g *Group) matches(method, requestPath string) bool {
+	if len(g.Methods) > 0 {
+		found := false
+		for _, m := range g.Methods {
+			if strings.EqualFold(m, method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return matchesAny(g.Paths, requestPath)
+}
+
+// exempts reports whether the identity is never limited.
+func (e *Exempt) exempts(identity auth.Identity) bool {
+	if identity.Subject == "" {
+		return false
+	}
+
+	if matchesAny(e.Subjects, identity.Subject) {
+		return true
+	}
+
+	for _, group := range identity.Groups {
+		if matchesAny(e.Groups, group) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, value); err == nil && ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPolicy(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "ratelimit.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(`
+default:
+  identity:
+    requests: 600
+    period: 1m
+groups:
+  - name: versions
+    methods: [GET]
+    paths: ["/v1/modules/*/*/*/versions"]
+    identity:
+      requests: 60
+      period: 1m
+      burst: 10
+    ip:
+      requests: 30
+      period: 1m
+exempt:
+  subjects: [mirror]
+`), 0o600))
+
+	policy, err := LoadPolicy(file)
+	require.NoError(t, err)
+
+	assert.Equal(t, Policy{
+		Default: Limits{Identity: &Limit{Requests: 600, Period: time.Minute}},
+		Groups: []Group{{
+			Name:    "versions",
+			Methods: []string{"GET"},
+			Paths:   []string{"/v1/modules/*/*/*/versions"},
+			Limits: Limits{
+				Identity: &Limit{Requests: 60, Period: time.Minute, Burst: 10},
+				IP:       &Limit{Requests: 30, Period: time.Minute},
+			},
+		}},
+		Exempt: Exempt{Subjects: []string{"mirror"}},
+	}, policy)
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		annotation string
+		policy     Policy
+		wantErr    bool
+	}{
+		{
+			annotation: "empty",
+			policy:     Policy{},
+		},
+		{
+			annotation: "without requests",
+			policy:     Policy{Default: Limits{IP: &Limit{Period: time.Minute}}},
+			wantErr:    true,
+		},
+		{
+			annotation: "without period",
+			policy:     Policy{Default: Limits{Identity: &Limit{Requests: 10}}},
+			wantErr:    true,
+		},
+		{
+			annotation: "group without paths",
+			policy:     Policy{Groups: []Group{{Name: "versions"}}},
+			wantErr:    true,
+		},
+		{
+			annotation: "group without name",
+			policy:     Policy{Groups: []Group{{Paths: []string{"/v1/modules/*/*/*/versions"}}}},
+			wantErr:    true,
+		},
+		{
+			annotation: "duplicate group",
+			policy: Policy{Groups: []Group{
+				{Name: "versions", Paths: []string{"/v1/modules/*/*/*/versions"}},
+				{Name: "versions", Paths: []string{"/v1/modules/*/*/*/*/download"}},
+			}},
+			wantErr: true,
+		},
+		{
+			annotation: "invalid path pattern",
+			policy:     Policy{Groups: []Group{{Name: "versions", Paths: []string{"/v1/modules/["}}}},
+			wantErr:    true,
+		},
+		{
+			annotation: "invalid exempt pattern",
+			policy:     Policy{Exempt: Exempt{Subjects: []string{"mirror["}}},
+			wantErr:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.policy.Validate()
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/go-version"
+	"github.com/rs/zerolog"
+)
+
+const day = 24 * time.Hour
+
+// Storage represents the storage the Collector removes module versions from.
+type Storage interface {
+	ListModules(ctx context.Context) ([]core.Module, error)
+	GetModule(ctx context.Context, namespace, name, provider, version string) (core.Module, error)
+	DeleteModule(ctx context.Context, namespace, name, provider, version string) error
+}
+
+// Deletion is a module version that is not retained by the policy.
+type Deletion struct {
+	Module core.Module
+	Reason string
+}
+
+// Collector deletes the module versions that are not retained by a Policy.
+type Collector struct {
+	storage   Storage
+	policy    Policy
+	downloads DownloadHistory
+	hooks     []DeletionHook
+	logger    zerolog.Logger
+	now       func() time.Time
+}
+
+// CollectorOption provides additional options for the Collector.
+type CollectorOption func(*Collector)
+
+// WithDownloadHistory configures the download history used for the downloaded_within_days rules.
+// Without a history, versions covered by such a rule are never deleted.
+func WithDownloadHistory(downloads DownloadHistory) CollectorOption {
+	return func(c *Collector) {
+		c.downloads = downloads
+	}
+}
+
+// DeletionHook is called after the Collector attempted to delete a module version, err is nil if it was deleted.
+type DeletionHook func(ctx context.Context, deletion Deletion, err error)
+
+// WithDeletionHook registers a hook that is called for every deletion, dry-runs do not call it.
+func WithDeletionHook(hook DeletionHook) CollectorOption {
+	return func(c *Collector) {
+		c.hooks = append(c.hooks, hook)
+	}
+}
+
+// NewCollector returns a fully initialized Collector.
+func NewCollector(storage Storage, policy Policy, logger zerolog.Logger, options ...CollectorOption) *Collector {
+	c := &Collector{
+		storage: storage,
+		policy:  policy,
+		logger:  logger,
+		now:     time.Now,
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+// Plan returns the module versions that are not retained by the policy.
+func (c *Collector) Plan(ctx context.Context) ([]Deletion, error) {
+	modules, err := c.storage.ListModules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	grouped := make(map[string][]core.Module)
+	for _, m := range modules {
+		grouped[m.ID(false)] = append(grouped[m.ID(false)], m)
+	}
+
+	ids := make([]string, 0, len(grouped))
+	for id := range grouped {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var deletions []Deletion
+	for _, id := range ids {
+		rule := c.policy.match(grouped[id][0])
+		if rule == nil {
+			continue
+		}
+
+		d, err := c.plan(ctx, rule, grouped[id])
+		if err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, d...)
+	}
+
+	return deletions, nil
+}
+
+// plan returns the versions of a single module that are not retained by the rule.
+func (c *Collector) plan(ctx context.Context, rule *Rule, modules []core.Module) ([]Deletion, error) {
+	type moduleVersion struct {
+		module  core.Module
+		version *version.Version
+	}
+
+	var versions []moduleVersion
+	for _, m := range modules {
+		v, err := version.NewVersion(m.Version)
+		if err != nil {
+			// Versions we cannot order are always retained
+			c.logger.Warn().Str("module", m.ID(true)).Err(err).Msg("skipping module version that is not a valid version")
+			continue
+		}
+		versions = append(versions, moduleVersion{module: m, version: v})
+	}
+
+	// Latest version first
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].version.GreaterThan(versions[j].version)
+	})
+
+	now := c.now()
+
+	var (
+		deletions []Deletion
+		releases  int
+	)
+	for _, v := range versions {
+		var reason string
+
+		if v.version.Prerelease() == "" {
+			releases++
+			if rule.KeepLast > 0 && releases > rule.KeepLast {
+				reason = fmt.Sprintf("not one of the latest %d releases", rule.KeepLast)
+			}
+		} else if rule.PrereleaseMaxAgeDays > 0 {
+			m, err := c.storage.GetModule(ctx, v.module.Namespace, v.module.Name, v.module.Provider, v.module.Version)
+			if err != nil {
+				return nil, err
+			}
+
+			maxAge := time.Duration(rule.PrereleaseMaxAgeDays) * day
+			if m.Publish != nil && !m.Publish.PublishedAt.IsZero() && now.Sub(m.Publish.PublishedAt) > maxAge {
+				reason = fmt.Sprintf("prerelease older than %d days", rule.PrereleaseMaxAgeDays)
+			}
+		}
+
+		if reason == "" || c.recentlyDownloaded(rule, v.module, now) {
+			continue
+		}
+
+		deletions = append(deletions, Deletion{Module: v.module, Reason: reason})
+	}
+
+	return deletions, nil
+}
+
+// recentlyDownloaded reports whether the module version has to be retained because of recent downloads.
+// If the download history does not cover the whole period, the version is assumed to be downloaded.
+func (c *Collector) recentlyDownloaded(rule *Rule, m core.Module, now time.Time) bool {
+	if rule.DownloadedWithinDays == 0 {
+		return false
+	}
+
+	if c.downloads == nil {
+		return true
+	}
+
+	window := now.Add(-time.Duration(rule.DownloadedWithinDays) * day)
+	if c.downloads.Since().After(window) {
+		return true
+	}
+
+	last, ok := c.downloads.LastDownload(m)
+	return ok && last.After(window)
+}
+
+// Run deletes the module versions that are not retained by the policy and returns them.
+// In dry-run mode the versions are only reported.
+func (c *Collector) Run(ctx context.Context, dryRun bool) ([]Deletion, error) {
+	deletions, err := c.Plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		result  *multierror.Error
+		deleted []Deletion
+	)
+	for _, d := range deletions {
+		sublogger := c.logger.With().Str("module", d.Module.ID(true)).Str("reason", d.Reason).Logger()
+
+		if dryRun {
+			sublogger.Info().Msg("module version would be deleted (dry-run)")
+			deleted = append(deleted, d)
+			continue
+		}
+
+		err := c.storage.DeleteModule(ctx, d.Module.Namespace, d.Module.Name, d.Module.Provider, d.Module.Version)
+		for _, hook := range c.hooks {
+			hook(ctx, d, err)
+		}
+
+		if err != nil {
+			sublogger.Error().Err(err).Msg("failed to delete module version")
+			result = multierror.Append(result, err)
+			continue
+		}
+
+		sublogger.Info().Msg("module version deleted")
+		deleted = append(deleted, d)
+	}
+
+	return deleted, result.ErrorOrNil()
+}
@@ -0,0 +1,160 @@
+package retention
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+	"github.com/MichielBijland/uncomplicated-registry/internal/module"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+// staticDownloads is a DownloadHistory with fixed downloads.
+type staticDownloads struct {
+	since time.Time
+	last  map[string]time.Time
+}
+
+func (d *staticDownloads) LastDownload(m core.Module) (time.Time, bool) {
+	last, ok := d.last[m.ID(true)]
+	return last, ok
+}
+
+func (d *staticDownloads) Since() time.Time { return d.since }
+
+func TestCollector_Run(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	testCases := []struct {
+		annotation string
+		versions   []string
+		rule       Rule
+		downloads  DownloadHistory
+		now        time.Time
+		dryRun     bool
+		expected   []string
+	}{
+		{
+			annotation: "keep last releases",
+			versions:   []string{"1.0.0", "1.1.0", "1.10.0", "1.2.0"},
+			rule:       Rule{Module: "test/*/*", KeepLast: 2},
+			now:        now,
+			expected:   []string{"1.1.0", "1.0.0"},
+		},
+		{
+			annotation: "dry-run does not delete",
+			versions:   []string{"1.0.0", "1.1.0"},
+			rule:       Rule{Module: "test/*/*", KeepLast: 1},
+			now:        now,
+			dryRun:     true,
+			expected:   []string{"1.0.0"},
+		},
+		{
+			annotation: "prereleases are not counted as releases",
+			versions:   []string{"1.0.0", "2.0.0-beta1"},
+			rule:       Rule{Module: "test/*/*", KeepLast: 1},
+			now:        now,
+			expected:   nil,
+		},
+		{
+			annotation: "old prereleases",
+			versions:   []string{"1.0.0", "2.0.0-beta1"},
+			rule:       Rule{Module: "test/*/*", PrereleaseMaxAgeDays: 30},
+			now:        now.Add(31 * day),
+			expected:   []string{"2.0.0-beta1"},
+		},
+		{
+			annotation: "recent prereleases",
+			versions:   []string{"1.0.0", "2.0.0-beta1"},
+			rule:       Rule{Module: "test/*/*", PrereleaseMaxAgeDays: 30},
+			now:        now.Add(29 * day),
+			expected:   nil,
+		},
+		{
+			annotation: "no matching rule",
+			versions:   []string{"1.0.0", "1.1.0"},
+			rule:       Rule{Module: "other/*/*", KeepLast: 1},
+			now:        now,
+			expected:   nil,
+		},
+		{
+			annotation: "recently downloaded versions are retained",
+			versions:   []string{"1.0.0", "1.1.0", "1.2.0"},
+			rule:       Rule{Module: "test/*/*", KeepLast: 1, DownloadedWithinDays: 7},
+			downloads: &staticDownloads{
+				since: now.Add(-30 * day),
+				last: map[string]time.Time{
+					"test/s3/aws/1.0.0": now.Add(-1 * day),
+					"test/s3/aws/1.1.0": now.Add(-10 * day),
+				},
+			},
+			now:      now,
+			expected: []string{"1.1.0"},
+		},
+		{
+			annotation: "incomplete download history retains versions",
+			versions:   []string{"1.0.0", "1.1.0"},
+			rule:       Rule{Module: "test/*/*", KeepLast: 1, DownloadedWithinDays: 7},
+			downloads:  &staticDownloads{since: now.Add(-1 * day)},
+			now:        now,
+			expected:   nil,
+		},
+		{
+			annotation: "missing download history retains versions",
+			versions:   []string{"1.0.0", "1.1.0"},
+			rule:       Rule{Module: "test/*/*", KeepLast: 1, DownloadedWithinDays: 7},
+			now:        now,
+			expected:   nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.annotation, func(t *testing.T) {
+			ctx := context.Background()
+			storage := module.NewInmemStorage()
+
+			for _, v := range tc.versions {
+				_, err := storage.UploadModule(ctx, "test", "s3", "aws", v, bytes.NewBufferString("data"), core.PublishMetadata{})
+				assert.NoError(t, err)
+			}
+
+			var hooked []string
+			options := []CollectorOption{WithDeletionHook(func(_ context.Context, d Deletion, err error) {
+				assert.NoError(t, err)
+				hooked = append(hooked, d.Module.Version)
+			})}
+			if tc.downloads != nil {
+				options = append(options, WithDownloadHistory(tc.downloads))
+			}
+
+			collector := NewCollector(storage, Policy{Rules: []Rule{tc.rule}}, zerolog.Nop(), options...)
+			collector.now = func() time.Time { return tc.now }
+
+			deletions, err := collector.Run(ctx, tc.dryRun)
+			assert.NoError(t, err)
+
+			var deleted []string
+			for _, d := range deletions {
+				deleted = append(deleted, d.Module.Version)
+			}
+			assert.Equal(t, tc.expected, deleted)
+
+			remaining, err := storage.ListModules(ctx)
+			assert.NoError(t, err)
+			if tc.dryRun {
+				assert.Len(t, remaining, len(tc.versions))
+				assert.Empty(t, hooked)
+			} else {
+				assert.Equal(t, tc.expected, hooked)
+				assert.Len(t, remaining, len(tc.versions)-len(tc.expected))
+			}
+		})
+	}
+}
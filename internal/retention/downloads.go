@@ -0,0 +1,52 @@
+package retention
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+)
+
+// DownloadHistory provides the last download of module versions.
+type DownloadHistory interface {
+	// LastDownload returns when a module version was last downloaded, if it was.
+	LastDownload(module core.Module) (time.Time, bool)
+	// Since returns the moment from which downloads are known.
+	Since() time.Time
+}
+
+// Downloads is an in-memory DownloadHistory, recording downloads from the moment it was created.
+type Downloads struct {
+	mu    sync.RWMutex
+	since time.Time
+	last  map[string]time.Time
+}
+
+// Record registers a download of the module, it can be used as a module.DownloadHook.
+func (d *Downloads) Record(_ context.Context, module core.Module) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.last[module.ID(true)] = time.Now()
+}
+
+func (d *Downloads) LastDownload(module core.Module) (time.Time, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	last, ok := d.last[module.ID(true)]
+	return last, ok
+}
+
+func (d *Downloads) Since() time.Time {
+	return d.since
+}
+
+// NewDownloads returns an empty download history.
+func NewDownloads() *Downloads {
+	return &Downloads{
+		since: time.Now(),
+		last:  make(map[string]time.Time),
+	}
+}
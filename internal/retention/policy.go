@@ -0,0 +1,92 @@
+package retention
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Policy is a declarative set of retention rules.
+// The first rule matching a module decides which of its versions are retained.
+type Policy struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule describes which versions of the matching modules are retained.
+type Rule struct {
+	// Module is a namespace/name/provider pattern, each part is matched with path.Match (e.g. "network/*/*").
+	Module string `yaml:"module"`
+	// KeepLast retains the latest N releases, older releases are deleted. Zero retains all releases.
+	KeepLast int `yaml:"keep_last"`
+	// PrereleaseMaxAgeDays deletes prereleases published more than N days ago. Zero retains all prereleases.
+	PrereleaseMaxAgeDays int `yaml:"prerelease_max_age_days"`
+	// DownloadedWithinDays never deletes versions downloaded in the last N days.
+	DownloadedWithinDays int `yaml:"downloaded_within_days"`
+}
+
+// LoadPolicy reads a YAML policy file.
+func LoadPolicy(file string) (Policy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return Policy{}, errors.Wrap(err, "failed to read retention policy")
+	}
+
+	var policy Policy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return Policy{}, errors.Wrapf(err, "failed to parse retention policy: %s", file)
+	}
+
+	return policy, policy.Validate()
+}
+
+// Validate ensures that a Policy is valid.
+func (p *Policy) Validate() error {
+	var result *multierror.Error
+
+	for i, rule := range p.Rules {
+		parts := strings.Split(rule.Module, "/")
+		if len(parts) != 3 {
+			result = multierror.Append(result, fmt.Errorf("rules[%d].module must be a namespace/name/provider pattern", i))
+		}
+
+		for _, part := range parts {
+			if _, err := path.Match(part, ""); err != nil {
+				result = multierror.Append(result, fmt.Errorf("rules[%d].module: %w", i, err))
+			}
+		}
+
+		if rule.KeepLast < 0 || rule.PrereleaseMaxAgeDays < 0 || rule.DownloadedWithinDays < 0 {
+			result = multierror.Append(result, fmt.Errorf("rules[%d] cannot contain negative values", i))
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+// match returns the first rule matching the module, or nil if no rule matches.
+func (p *Policy) match(m core.Module) *Rule {
+	for i, rule := range p.Rules {
+		parts := strings.Split(rule.Module, "/")
+		if len(parts) != 3 {
+			continue
+		}
+
+		if matchPart(parts[0], m.Namespace) && matchPart(parts[1], m.Name) && matchPart(parts[2], m.Provider) {
+			return &p.Rules[i]
+		}
+	}
+
+	return nil
+}
+
+func matchPart(pattern, value string) bool {
+	ok, err := path.Match(pattern, value)
+	return err == nil && ok
+}
@@ -0,0 +1,49 @@
+package retention
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Validate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		annotation    string
+		policy        Policy
+		expectedError bool
+	}{
+		{
+			annotation: "valid policy",
+			policy:     Policy{Rules: []Rule{{Module: "network/*/aws", KeepLast: 5}}},
+		},
+		{
+			annotation:    "module pattern with too few parts",
+			policy:        Policy{Rules: []Rule{{Module: "network/*"}}},
+			expectedError: true,
+		},
+		{
+			annotation:    "malformed module pattern",
+			policy:        Policy{Rules: []Rule{{Module: "network/[/*"}}},
+			expectedError: true,
+		},
+		{
+			annotation:    "negative values",
+			policy:        Policy{Rules: []Rule{{Module: "*/*/*", KeepLast: -1}}},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.annotation, func(t *testing.T) {
+			err := tc.policy.Validate()
+			if tc.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
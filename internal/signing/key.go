@@ -0,0 +1,128 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/pkg/errors"
+)
+
+// Key signs the JWTs issued by the registry, and provides the key set to verify them with.
+type Key struct {
+	signer jose.Signer
+	keySet *oidc.StaticKeySet
+}
+
+// Sign returns the compact serialization of a JWT with the claims.
+func (k *Key) Sign(claims ...interface{}) (string, error) {
+	builder := jwt.Signed(k.signer)
+	for _, c := range claims {
+		builder = builder.Claims(c)
+	}
+
+	token, err := builder.CompactSerialize()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign token")
+	}
+
+	return token, nil
+}
+
+// KeySet returns the key set verifying the tokens signed with the Key.
+func (k *Key) KeySet() oidc.KeySet { return k.keySet }
+
+// NewKey returns a Key for the private key, RSA, ECDSA and Ed25519 keys are supported.
+func NewKey(key crypto.Signer) (*Key, error) {
+	var algorithm jose.SignatureAlgorithm
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		algorithm = jose.RS256
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			algorithm = jose.ES256
+		case elliptic.P384():
+			algorithm = jose.ES384
+		case elliptic.P521():
+			algorithm = jose.ES512
+		default:
+			return nil, errors.New("unsupported ecdsa signing key curve")
+		}
+	case ed25519.PrivateKey:
+		algorithm = jose.EdDSA
+	default:
+		return nil, errors.Errorf("unsupported signing key type %T", key)
+	}
+
+	jwk := jose.JSONWebKey{Key: key.Public(), Algorithm: string(algorithm), Use: "sig"}
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compute signing key id")
+	}
+	keyID := base64.RawURLEncoding.EncodeToString(thumbprint)
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: algorithm, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", keyID),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create token signer")
+	}
+
+	return &Key{
+		signer: signer,
+		keySet: &oidc.StaticKeySet{PublicKeys: []crypto.PublicKey{key.Public()}},
+	}, nil
+}
+
+// LoadKey returns a Key for a PEM encoded private key, or for a generated ECDSA P-256 key without a file.
+func LoadKey(file string) (*Key, error) {
+	if file == "" {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to generate signing key")
+		}
+		return NewKey(key)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read signing key")
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Errorf("signing key %s is not PEM encoded", file)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse signing key %s", file)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("signing key %s is not a private key", file)
+	}
+
+	return NewKey(signer)
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+)
+
+// Content-addressed storage keeps every distinct archive once, as a blob named after its digest.
+// Module versions are manifests referencing a blob, stored under the key layout with the manifestExt extension.
+const (
+	blobsDir       = "blobs"
+	refsDir        = "refs"
+	digestSHA256   = "sha256"
+	manifestExt    = "json"
+	manifestSchema = 1
+)
+
+// manifest describes a module version in content-addressed storage.
+type manifest struct {
+	SchemaVersion int                  `json:"schemaVersion"`
+	Digest        string               `json:"digest"`
+	Size          int64                `json:"size"`
+	Format        string               `json:"format"`
+	Publish       core.PublishMetadata `json:"publish"`
+}
+
+// newManifest returns the manifest of an archive.
+func newManifest(data []byte, format string, publish core.PublishMetadata) manifest {
+	sum := sha256.Sum256(data)
+
+	publish.Size = int64(len(data))
+	publish.Digest = digestSHA256 + ":" + hex.EncodeToString(sum[:])
+
+	return manifest{
+		SchemaVersion: manifestSchema,
+		Digest:        publish.Digest,
+		Size:          publish.Size,
+		Format:        format,
+		Publish:       publish,
+	}
+}
+
+// decodeManifest parses and validates a manifest.
+func decodeManifest(data []byte) (manifest, error) {
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return manifest{}, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	if m.SchemaVersion != manifestSchema {
+		return manifest{}, fmt.Errorf("unsupported manifest schema version %d", m.SchemaVersion)
+	}
+
+	if _, err := m.hex(); err != nil {
+		return manifest{}, err
+	}
+
+	return m, nil
+}
+
+// hex returns the hex encoded SHA-256 digest of the blob.
+func (m manifest) hex() (string, error) {
+	algorithm, digest, ok := strings.Cut(m.Digest, ":")
+	if !ok || algorithm != digestSHA256 {
+		return "", fmt.Errorf("unsupported digest %q", m.Digest)
+	}
+
+	if b, err := hex.DecodeString(digest); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid digest %q", m.Digest)
+	}
+
+	return digest, nil
+}
+
+// downloadURL adds the archive format and checksum to the URL of the blob.
+func (m manifest) downloadURL(blobURL string) (string, error) {
+	return archiveDownloadURL(blobURL, m.Format, m.Digest)
+}
+
+// archiveDownloadURL adds the archive format and checksum to the URL of an archive without extension.
+// Terraform (go-getter) uses the archive parameter to unpack it and verifies the download with the checksum parameter.
+func archiveDownloadURL(blobURL, format, digest string) (string, error) {
+	u, err := url.Parse(blobURL)
+	if err != nil {
+		return "", err
+	}
+
+	// The parameters are appended, re-encoding the query could invalidate a presigned URL
+	params := url.Values{}
+	params.Set("archive", format)
+	params.Set("checksum", digest)
+
+	if u.RawQuery == "" {
+		u.RawQuery = params.Encode()
+	} else {
+		u.RawQuery += "&" + params.Encode()
+	}
+
+	return u.String(), nil
+}
+
+// blobKey returns the object key of a blob.
+func blobKey(prefix, digest string) string {
+	return path.Join(prefix, blobsDir, digestSHA256, digest)
+}
+
+// refsPrefix returns the key prefix of the references to a blob.
+// Every manifest referencing the blob has an empty object below the prefix.
+func refsPrefix(prefix, digest string) string {
+	return path.Join(prefix, refsDir, digestSHA256, digest) + "/"
+}
+
+// refKey returns the object key of the reference from a module version to a blob.
+func refKey(prefix, digest string, m core.Module) string {
+	return refsPrefix(prefix, digest) + m.ID(true)
+}
+
+// isBlobKey reports whether the key, relative to the bucket prefix, belongs to a blob or a reference.
+func isBlobKey(key string) bool {
+	return strings.HasPrefix(key, blobsDir+"/"+digestSHA256+"/") || strings.HasPrefix(key, refsDir+"/"+digestSHA256+"/")
+}
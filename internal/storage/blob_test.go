@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+	"github.com/MichielBijland/uncomplicated-registry/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// sha256 of "foo"
+const fooDigest = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+func TestNewManifest(t *testing.T) {
+	t.Parallel()
+
+	publish := core.PublishMetadata{Publisher: "ci@example.com", Commit: "4a4faad"}
+
+	mf := newManifest([]byte("foo"), utils.ArchiveFormatZip, publish)
+	assert.Equal(t, manifestSchema, mf.SchemaVersion)
+	assert.Equal(t, "sha256:"+fooDigest, mf.Digest)
+	assert.Equal(t, int64(3), mf.Size)
+	assert.Equal(t, utils.ArchiveFormatZip, mf.Format)
+	assert.Equal(t, core.PublishMetadata{
+		Publisher: "ci@example.com",
+		Commit:    "4a4faad",
+		Size:      3,
+		Digest:    "sha256:" + fooDigest,
+	}, mf.Publish)
+
+	// Identical content results in the same blob
+	other := newManifest([]byte("foo"), utils.ArchiveFormatTarGz, core.PublishMetadata{})
+	assert.Equal(t, mf.Digest, other.Digest)
+
+	data, err := json.Marshal(mf)
+	assert.NoError(t, err)
+
+	decoded, err := decodeManifest(data)
+	assert.NoError(t, err)
+	assert.Equal(t, mf, decoded)
+}
+
+func TestDecodeManifest(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		annotation    string
+		data          string
+		expectedError bool
+	}{
+		{
+			annotation: "valid manifest",
+			data:       `{"schemaVersion":1,"digest":"sha256:` + fooDigest + `","size":3,"format":"zip"}`,
+		},
+		{
+			annotation:    "invalid json",
+			data:          `{`,
+			expectedError: true,
+		},
+		{
+			annotation:    "unsupported schema version",
+			data:          `{"schemaVersion":2,"digest":"sha256:` + fooDigest + `"}`,
+			expectedError: true,
+		},
+		{
+			annotation:    "unsupported digest algorithm",
+			data:          `{"schemaVersion":1,"digest":"md5:acbd18db4cc2f85cedef654fccc4a4d8"}`,
+			expectedError: true,
+		},
+		{
+			annotation:    "truncated digest",
+			data:          `{"schemaVersion":1,"digest":"sha256:2c26b46b"}`,
+			expectedError: true,
+		},
+		{
+			annotation:    "digest is not hex",
+			data:          `{"schemaVersion":1,"digest":"sha256:../../modules"}`,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := decodeManifest([]byte(tc.data))
+			if tc.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestManifest_DownloadURL(t *testing.T) {
+	t.Parallel()
+
+	mf := manifest{Digest: "sha256:" + fooDigest, Format: utils.ArchiveFormatTarGz}
+
+	testCases := []struct {
+		annotation string
+		url        string
+		expected   string
+	}{
+		{
+			annotation: "plain url",
+			url:        "https://bucket.s3.amazonaws.com/blobs/sha256/" + fooDigest,
+			expected:   "https://bucket.s3.amazonaws.com/blobs/sha256/" + fooDigest + "?archive=tar.gz&checksum=sha256%3A" + fooDigest,
+		},
+		{
+			annotation: "presigned url keeps its query as is",
+			url:        "https://bucket.s3.amazonaws.com/blobs/sha256/" + fooDigest + "?X-Amz-Signature=abc%2Fdef&X-Amz-Expires=30",
+			expected:   "https://bucket.s3.amazonaws.com/blobs/sha256/" + fooDigest + "?X-Amz-Signature=abc%2Fdef&X-Amz-Expires=30&archive=tar.gz&checksum=sha256%3A" + fooDigest,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := mf.downloadURL(tc.url)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestBlobKeys(t *testing.T) {
+	t.Parallel()
+
+	m := core.Module{Namespace: "hashicorp", Name: "consul", Provider: "aws", Version: "0.11.0"}
+
+	assert.Equal(t, "registry/blobs/sha256/"+fooDigest, blobKey("registry", fooDigest))
+	assert.Equal(t, "blobs/sha256/"+fooDigest, blobKey("", fooDigest))
+	assert.Equal(t, "registry/refs/sha256/"+fooDigest+"/hashicorp/consul/aws/0.11.0", refKey("registry", fooDigest, m))
+
+	assert.True(t, isBlobKey("blobs/sha256/"+fooDigest))
+	assert.True(t, isBlobKey("refs/sha256/"+fooDigest+"/hashicorp/consul/aws/0.11.0"))
+	assert.False(t, isBlobKey("modules/hashicorp/consul/aws/hashicorp-consul-aws-0.11.0.json"))
+}
+
+func TestS3Storage_ModuleFromKey(t *testing.T) {
+	t.Parallel()
+
+	m := core.Module{Namespace: "hashicorp", Name: "consul", Provider: "aws", Version: "0.11.0"}
+
+	testCases := []struct {
+		annotation       string
+		layout           string
+		contentAddressed bool
+		key              string
+		expectedFormat   string
+		expectedError    bool
+	}{
+		{
+			annotation:     "archive",
+			layout:         DefaultModuleKeyLayout,
+			key:            "registry/modules/hashicorp/consul/aws/hashicorp-consul-aws-0.11.0.zip",
+			expectedFormat: utils.ArchiveFormatZip,
+		},
+		{
+			annotation:    "manifest without content addressing",
+			layout:        DefaultModuleKeyLayout,
+			key:           "registry/modules/hashicorp/consul/aws/hashicorp-consul-aws-0.11.0.json",
+			expectedError: true,
+		},
+		{
+			annotation:       "manifest",
+			layout:           DefaultModuleKeyLayout,
+			contentAddressed: true,
+			key:              "registry/modules/hashicorp/consul/aws/hashicorp-consul-aws-0.11.0.json",
+			expectedFormat:   manifestExt,
+		},
+		{
+			annotation:       "archive published before content addressing",
+			layout:           DefaultModuleKeyLayout,
+			contentAddressed: true,
+			key:              "registry/modules/hashicorp/consul/aws/hashicorp-consul-aws-0.11.0.tar.gz",
+			expectedFormat:   utils.ArchiveFormatTarGz,
+		},
+		{
+			annotation:       "manifest in layout without extension",
+			layout:           "{namespace}/{name}/{provider}/{version}",
+			contentAddressed: true,
+			key:              "registry/hashicorp/consul/aws/0.11.0",
+			expectedFormat:   manifestExt,
+		},
+		{
+			annotation:       "reference in layout without extension",
+			layout:           "{namespace}/{name}/{provider}/{version}",
+			contentAddressed: true,
+			key:              "registry/refs/sha256/" + fooDigest + "/hashicorp/consul/aws/0.11.0",
+			expectedError:    true,
+		},
+		{
+			annotation:    "metadata in layout without extension",
+			layout:        "{namespace}/{name}/{provider}/{version}",
+			key:           "registry/_registry/tokens/e4f1/0.json",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			layout, err := parseKeyLayout(tc.layout)
+			assert.NoError(t, err)
+
+			s := &S3Storage{
+				bucketPrefix:        "registry",
+				moduleArchiveFormat: DefaultModuleArchiveFormat,
+				keyLayout:           layout,
+				contentAddressed:    tc.contentAddressed,
+			}
+
+			actual, format, err := s.moduleFromKey(tc.key)
+			if tc.expectedError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, m, *actual)
+			assert.Equal(t, tc.expectedFormat, format)
+		})
+	}
+}
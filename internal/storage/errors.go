@@ -1,12 +1,22 @@
 package storage
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/module"
+)
 
 // Storage errors.
 var (
-	// module errors
+	// module errors, ErrModuleAlreadyExists and ErrModuleNotFound are shared with the module service
 	ErrModuleUploadFailed  = errors.New("failed to upload module")
-	ErrModuleAlreadyExists = errors.New("module already exists")
-	ErrModuleNotFound      = errors.New("failed to locate module")
+	ErrModuleAlreadyExists = module.ErrModuleAlreadyExists
+	ErrModuleNotFound      = module.ErrModuleNotFound
 	ErrModuleListFailed    = errors.New("failed to list module versions")
+	ErrModuleDeleteFailed  = errors.New("failed to delete module")
+
+	ErrModuleEncryptionMismatch = errors.New("module is not encrypted as required")
+
+	// metadata errors
+	ErrMetadataNotFound = errors.New("failed to locate metadata")
 )
@@ -0,0 +1,506 @@
+package storage
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+	"github.com/MichielBijland/uncomplicated-registry/internal/utils"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/hashicorp/go-multierror"
+	goversion "github.com/hashicorp/go-version"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultGitArchiveCacheSize is the number of archives the GitStorage keeps in memory.
+const DefaultGitArchiveCacheSize = 64
+
+// GitConfig maps modules to git repositories.
+type GitConfig struct {
+	Modules []GitModule `yaml:"modules"`
+}
+
+// GitModule is a module served from the tags of a git repository.
+type GitModule struct {
+	// Module is the namespace/name/provider of the module.
+	Module string `yaml:"module"`
+	// Repository is the path of a local repository or mirror, bare or with a work tree.
+	Repository string `yaml:"repository"`
+	// Path is the directory of the module in the repository, the root by default.
+	Path string `yaml:"path"`
+}
+
+// LoadGitConfig reads a YAML file mapping modules to git repositories.
+func LoadGitConfig(file string) (GitConfig, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return GitConfig{}, errors.Wrap(err, "failed to read git config")
+	}
+
+	var config GitConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return GitConfig{}, errors.Wrapf(err, "failed to parse git config: %s", file)
+	}
+
+	return config, config.Validate()
+}
+
+// Validate ensures that a GitConfig is valid.
+func (c *GitConfig) Validate() error {
+	var result *multierror.Error
+
+	seen := make(map[string]bool)
+	for i, m := range c.Modules {
+		parts := strings.Split(m.Module, "/")
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			result = multierror.Append(result, fmt.Errorf("modules[%d].module must be namespace/name/provider", i))
+		}
+
+		if seen[m.Module] {
+			result = multierror.Append(result, fmt.Errorf("modules[%d].module %s is mapped more than once", i, m.Module))
+		}
+		seen[m.Module] = true
+
+		if m.Repository == "" {
+			result = multierror.Append(result, fmt.Errorf("modules[%d].repository is required", i))
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+// GitStorage is a read-only Storage implementation serving modules from git tags.
+// Every semver tag (optionally prefixed with a "v") is a module version, its archive
+// is built from the tagged tree when it is downloaded.
+// GitStorage implements module.Storage
+type GitStorage struct {
+	modules        map[string]GitModule
+	archiveFormat  string
+	downloadPrefix string
+	cache          *archiveCache
+}
+
+// gitVersion is a module version backed by a tag.
+type gitVersion struct {
+	tag    string
+	commit *object.Commit
+	tagger *object.Signature
+}
+
+// GetModule retrieves information about a module from its git repository.
+func (s *GitStorage) GetModule(_ context.Context, namespace, name, provider, version string) (core.Module, error) {
+	m := core.Module{
+		Namespace: namespace,
+		Name:      name,
+		Provider:  provider,
+		Version:   version,
+	}
+
+	gm, repo, err := s.open(m)
+	if err != nil {
+		return core.Module{}, err
+	}
+
+	v, err := s.resolve(repo, version)
+	if err != nil {
+		return core.Module{}, errors.Wrapf(ErrModuleNotFound, "%s: %s", gm.Repository, err.Error())
+	}
+
+	author := v.commit.Author
+	if v.tagger != nil {
+		author = *v.tagger
+	}
+
+	m.DownloadURL = s.downloadURL(m)
+	m.Publish = &core.PublishMetadata{
+		Publisher:   author.Email,
+		Commit:      v.commit.Hash.String(),
+		PublishedAt: author.When.UTC(),
+	}
+
+	return m, nil
+}
+
+// ListModuleVersions returns the semver tags of the repository of a module.
+func (s *GitStorage) ListModuleVersions(_ context.Context, namespace, name, provider string) ([]core.Module, error) {
+	m := core.Module{
+		Namespace: namespace,
+		Name:      name,
+		Provider:  provider,
+	}
+
+	gm, repo, err := s.open(m)
+	if errors.Is(err, ErrModuleNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	versions, err := gitVersions(repo)
+	if err != nil {
+		return nil, errors.Wrapf(ErrModuleListFailed, "%s: %s", gm.Repository, err.Error())
+	}
+
+	modules := make([]core.Module, 0, len(versions))
+	for _, v := range versions {
+		m.Version = v
+		m.DownloadURL = s.downloadURL(m)
+		modules = append(modules, m)
+	}
+
+	return modules, nil
+}
+
+// ListModules returns the versions of all configured modules.
+func (s *GitStorage) ListModules(ctx context.Context) ([]core.Module, error) {
+	ids := make([]string, 0, len(s.modules))
+	for id := range s.modules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var modules []core.Module
+	for _, id := range ids {
+		parts := strings.Split(id, "/")
+
+		versions, err := s.ListModuleVersions(ctx, parts[0], parts[1], parts[2])
+		if err != nil {
+			return nil, err
+		}
+
+		modules = append(modules, versions...)
+	}
+
+	return modules, nil
+}
+
+// UploadModule is not supported, versions are published by pushing a tag.
+func (s *GitStorage) UploadModule(_ context.Context, namespace, name, provider, version string, _ io.Reader, _ core.PublishMetadata) (core.Module, error) {
+	return core.Module{}, errors.Wrapf(ErrModuleUploadFailed, "git storage is read-only, push tag %s to publish %s/%s/%s", version, namespace, name, provider)
+}
+
+// DeleteModule is not supported, versions are removed by deleting their tag.
+func (s *GitStorage) DeleteModule(_ context.Context, namespace, name, provider, version string) error {
+	return errors.Wrapf(ErrModuleDeleteFailed, "git storage is read-only, delete tag %s to remove %s/%s/%s", version, namespace, name, provider)
+}
+
+// GetModuleArchive builds the archive of a module version from the tagged tree.
+// Archives are cached by commit, so a moved tag results in a new archive.
+func (s *GitStorage) GetModuleArchive(_ context.Context, namespace, name, provider, version string) (io.Reader, error) {
+	m := core.Module{
+		Namespace: namespace,
+		Name:      name,
+		Provider:  provider,
+		Version:   version,
+	}
+
+	gm, repo, err := s.open(m)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := s.resolve(repo, version)
+	if err != nil {
+		return nil, errors.Wrapf(ErrModuleNotFound, "%s: %s", gm.Repository, err.Error())
+	}
+
+	key := strings.Join([]string{gm.Repository, v.commit.Hash.String(), gm.Path, s.archiveFormat}, "\x00")
+	if data, ok := s.cache.get(key); ok {
+		return bytes.NewReader(data), nil
+	}
+
+	data, err := s.archive(v.commit, gm.Path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to archive %s at %s", m.ID(true), v.tag)
+	}
+	s.cache.add(key, data)
+
+	return bytes.NewReader(data), nil
+}
+
+// open returns the configuration and the repository of a module.
+// The repository is opened for every request, so updates of a mirror are picked up.
+func (s *GitStorage) open(m core.Module) (GitModule, *git.Repository, error) {
+	gm, ok := s.modules[m.ID(false)]
+	if !ok {
+		return GitModule{}, nil, errors.Wrapf(ErrModuleNotFound, "%s is not mapped to a git repository", m.ID(false))
+	}
+
+	repo, err := git.PlainOpen(gm.Repository)
+	if err != nil {
+		return GitModule{}, nil, errors.Wrapf(err, "failed to open git repository %s", gm.Repository)
+	}
+
+	return gm, repo, nil
+}
+
+// resolve returns the commit tagged with the version.
+func (s *GitStorage) resolve(repo *git.Repository, version string) (gitVersion, error) {
+	if _, err := goversion.NewSemver(version); err != nil {
+		return gitVersion{}, err
+	}
+
+	for _, tag := range []string{version, "v" + version} {
+		ref, err := repo.Tag(tag)
+		if errors.Is(err, git.ErrTagNotFound) {
+			continue
+		} else if err != nil {
+			return gitVersion{}, err
+		}
+
+		v := gitVersion{tag: tag}
+
+		// Annotated tags point to a tag object, lightweight tags to the commit
+		t, err := repo.TagObject(ref.Hash())
+		switch {
+		case err == nil:
+			v.tagger = &t.Tagger
+			v.commit, err = t.Commit()
+		case errors.Is(err, plumbing.ErrObjectNotFound):
+			v.commit, err = repo.CommitObject(ref.Hash())
+		}
+		if err != nil {
+			return gitVersion{}, errors.Wrapf(err, "tag %s does not point to a commit", tag)
+		}
+
+		return v, nil
+	}
+
+	return gitVersion{}, errors.Errorf("no tag for version %s", version)
+}
+
+// archive builds the archive of the module in dir of the commit, applying the same file filtering as utils.ArchiveModule.
+func (s *GitStorage) archive(commit *object.Commit, dir string) ([]byte, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	if dir = strings.Trim(dir, "/"); dir != "" {
+		if tree, err = tree.Tree(dir); err != nil {
+			return nil, errors.Wrapf(err, "directory %s", dir)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	aw, err := utils.NewArchiveWriter(buf, s.archiveFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tree.Files().ForEach(func(f *object.File) error {
+		// Symlinks and submodules are not regular files
+		if f.Mode != filemode.Regular && f.Mode != filemode.Executable {
+			return nil
+		}
+
+		if !utils.IsModuleFile(path.Base(f.Name)) {
+			return nil
+		}
+
+		mode, err := f.Mode.ToOSFileMode()
+		if err != nil {
+			return err
+		}
+
+		r, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		fi := gitFileInfo{name: path.Base(f.Name), size: f.Size, mode: mode, modTime: commit.Committer.When}
+		return aw.WriteFile(f.Name, fi, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := aw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (s *GitStorage) downloadURL(m core.Module) string {
+	return path.Join("/", s.downloadPrefix, m.Namespace, m.Name, m.Provider, m.Version, "archive", archiveFileName(m, s.archiveFormat))
+}
+
+// gitVersions returns the versions of the semver tags in the repository, in ascending order.
+func gitVersions(repo *git.Repository) ([]string, error) {
+	tags, err := repo.Tags()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var versions []*goversion.Version
+	err = tags.ForEach(func(ref *plumbing.Reference) error {
+		tag := ref.Name().Short()
+
+		v, err := goversion.NewSemver(tag)
+		if err != nil {
+			return nil
+		}
+
+		// v1.0.0 and 1.0.0 are the same version
+		version := strings.TrimPrefix(tag, "v")
+		if !seen[version] {
+			seen[version] = true
+			versions = append(versions, v)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(goversion.Collection(versions))
+
+	result := make([]string, 0, len(versions))
+	for _, v := range versions {
+		result = append(result, strings.TrimPrefix(v.Original(), "v"))
+	}
+
+	return result, nil
+}
+
+// gitFileInfo describes a file in a git tree.
+type gitFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi gitFileInfo) Name() string       { return fi.name }
+func (fi gitFileInfo) Size() int64        { return fi.size }
+func (fi gitFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi gitFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi gitFileInfo) IsDir() bool        { return false }
+func (fi gitFileInfo) Sys() interface{}   { return nil }
+
+// archiveCache is a least recently used cache of archives.
+type archiveCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type archiveCacheEntry struct {
+	key  string
+	data []byte
+}
+
+func newArchiveCache(size int) *archiveCache {
+	return &archiveCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *archiveCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(e)
+	return e.Value.(*archiveCacheEntry).data, true
+}
+
+func (c *archiveCache) add(key string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size <= 0 {
+		return
+	}
+
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&archiveCacheEntry{key: key, data: data})
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*archiveCacheEntry).key)
+	}
+}
+
+// GitStorageOption provides additional options for the GitStorage.
+type GitStorageOption func(*GitStorage)
+
+// WithGitStorageArchiveFormat configures the format (tar.gz, tgz or zip) archives are built in.
+func WithGitStorageArchiveFormat(archiveFormat string) GitStorageOption {
+	return func(s *GitStorage) {
+		s.archiveFormat = archiveFormat
+	}
+}
+
+// WithGitStorageDownloadPrefix configures the path under which the module routes are registered,
+// used to build the download URLs.
+func WithGitStorageDownloadPrefix(prefix string) GitStorageOption {
+	return func(s *GitStorage) {
+		s.downloadPrefix = prefix
+	}
+}
+
+// WithGitStorageCacheSize configures the number of archives kept in memory. Zero disables the cache.
+func WithGitStorageCacheSize(size int) GitStorageOption {
+	return func(s *GitStorage) {
+		s.cache = newArchiveCache(size)
+	}
+}
+
+// NewGitStorage returns a fully initialized git storage.
+func NewGitStorage(config GitConfig, options ...GitStorageOption) (*GitStorage, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	s := &GitStorage{
+		modules:       make(map[string]GitModule),
+		archiveFormat: DefaultModuleArchiveFormat,
+		cache:         newArchiveCache(DefaultGitArchiveCacheSize),
+	}
+
+	for _, m := range config.Modules {
+		s.modules[m.Module] = m
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	// Fail early on an archive format the archives cannot be built in
+	if _, err := utils.NewArchiveWriter(io.Discard, s.archiveFormat); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+)
+
+// testGitRepository creates a repository with the tags v1.0.0 (lightweight), 1.1.0 (annotated) and latest.
+func testGitRepository(t *testing.T) (string, map[string]plumbing.Hash) {
+	t.Helper()
+
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	assert.NoError(t, err)
+
+	wt, err := repo.Worktree()
+	assert.NoError(t, err)
+
+	sig := &object.Signature{Name: "CI", Email: "ci@example.com", When: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)}
+	commit := func(files map[string]string) plumbing.Hash {
+		for name, content := range files {
+			assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755))
+			assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+		}
+		assert.NoError(t, wt.AddGlob("."))
+
+		hash, err := wt.Commit("release", &git.CommitOptions{Author: sig})
+		assert.NoError(t, err)
+		return hash
+	}
+
+	commits := map[string]plumbing.Hash{
+		"v1.0.0": commit(map[string]string{
+			"main.tf":             `name = "foo"`,
+			"README.md":           "# foo",
+			"notes.txt":           "not part of the module",
+			"modules/vpc/main.tf": `name = "vpc"`,
+		}),
+	}
+	assert.NoError(t, os.Symlink("main.tf", filepath.Join(dir, "link.tf")))
+	commits["1.1.0"] = commit(map[string]string{"variables.tf": `variable "foo" {}`})
+
+	_, err = repo.CreateTag("v1.0.0", commits["v1.0.0"], nil)
+	assert.NoError(t, err)
+	_, err = repo.CreateTag("1.1.0", commits["1.1.0"], &git.CreateTagOptions{Tagger: &object.Signature{Name: "Releaser", Email: "releaser@example.com", When: sig.When.Add(time.Hour)}, Message: "1.1.0"})
+	assert.NoError(t, err)
+	_, err = repo.CreateTag("latest", commits["1.1.0"], nil)
+	assert.NoError(t, err)
+
+	return dir, commits
+}
+
+func TestGitStorage(t *testing.T) {
+	t.Parallel()
+
+	dir, commits := testGitRepository(t)
+	ctx := context.Background()
+
+	s, err := NewGitStorage(GitConfig{
+		Modules: []GitModule{
+			{Module: "example/foo/aws", Repository: dir},
+			{Module: "example/vpc/aws", Repository: dir, Path: "modules/vpc"},
+		},
+	}, WithGitStorageDownloadPrefix("/v1/modules"))
+	assert.NoError(t, err)
+
+	versions, err := s.ListModuleVersions(ctx, "example", "foo", "aws")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.0.0", "1.1.0"}, moduleVersions(versions))
+	assert.Equal(t, "/v1/modules/example/foo/aws/1.0.0/archive/example-foo-aws-1.0.0.tar.gz", versions[0].DownloadURL)
+
+	versions, err = s.ListModuleVersions(ctx, "example", "unknown", "aws")
+	assert.NoError(t, err)
+	assert.Empty(t, versions)
+
+	modules, err := s.ListModules(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, modules, 4)
+
+	// Lightweight tags are published by the commit author
+	m, err := s.GetModule(ctx, "example", "foo", "aws", "1.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, &core.PublishMetadata{
+		Publisher:   "ci@example.com",
+		Commit:      commits["v1.0.0"].String(),
+		PublishedAt: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC),
+	}, m.Publish)
+
+	// Annotated tags by the tagger
+	m, err = s.GetModule(ctx, "example", "foo", "aws", "1.1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "releaser@example.com", m.Publish.Publisher)
+	assert.Equal(t, commits["1.1.0"].String(), m.Publish.Commit)
+
+	_, err = s.GetModule(ctx, "example", "foo", "aws", "2.0.0")
+	assert.ErrorIs(t, err, ErrModuleNotFound)
+
+	_, err = s.GetModule(ctx, "example", "foo", "aws", "latest")
+	assert.ErrorIs(t, err, ErrModuleNotFound)
+
+	_, err = s.UploadModule(ctx, "example", "foo", "aws", "2.0.0", nil, core.PublishMetadata{})
+	assert.ErrorIs(t, err, ErrModuleUploadFailed)
+
+	assert.ErrorIs(t, s.DeleteModule(ctx, "example", "foo", "aws", "1.0.0"), ErrModuleDeleteFailed)
+}
+
+func TestGitStorage_GetModuleArchive(t *testing.T) {
+	t.Parallel()
+
+	dir, _ := testGitRepository(t)
+	ctx := context.Background()
+
+	s, err := NewGitStorage(GitConfig{
+		Modules: []GitModule{
+			{Module: "example/foo/aws", Repository: dir},
+			{Module: "example/vpc/aws", Repository: dir, Path: "modules/vpc"},
+		},
+	})
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		annotation string
+		name       string
+		version    string
+		expected   []string
+	}{
+		{
+			annotation: "root",
+			name:       "foo",
+			version:    "1.0.0",
+			expected:   []string{"README.md", "main.tf", "modules/vpc/main.tf"},
+		},
+		{
+			annotation: "symlinks are skipped",
+			name:       "foo",
+			version:    "1.1.0",
+			expected:   []string{"README.md", "main.tf", "modules/vpc/main.tf", "variables.tf"},
+		},
+		{
+			annotation: "directory",
+			name:       "vpc",
+			version:    "1.0.0",
+			expected:   []string{"main.tf"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			archive, err := s.GetModuleArchive(ctx, "example", tc.name, "aws", tc.version)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, tarGzFiles(t, archive))
+
+			// The second download is served from the cache
+			archive, err = s.GetModuleArchive(ctx, "example", tc.name, "aws", tc.version)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, tarGzFiles(t, archive))
+		})
+	}
+
+	assert.Equal(t, len(testCases), s.cache.order.Len())
+}
+
+func TestArchiveCache(t *testing.T) {
+	t.Parallel()
+
+	c := newArchiveCache(2)
+	c.add("a", []byte("a"))
+	c.add("b", []byte("b"))
+
+	// a is used more recently than b
+	_, ok := c.get("a")
+	assert.True(t, ok)
+
+	c.add("c", []byte("c"))
+
+	_, ok = c.get("b")
+	assert.False(t, ok)
+
+	for _, key := range []string{"a", "c"} {
+		data, ok := c.get(key)
+		assert.True(t, ok)
+		assert.Equal(t, []byte(key), data)
+	}
+
+	disabled := newArchiveCache(0)
+	disabled.add("a", []byte("a"))
+	_, ok = disabled.get("a")
+	assert.False(t, ok)
+}
+
+func TestGitConfig_Validate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		annotation    string
+		config        GitConfig
+		expectedError bool
+	}{
+		{
+			annotation: "valid",
+			config: GitConfig{Modules: []GitModule{
+				{Module: "example/foo/aws", Repository: "/srv/git/foo.git"},
+				{Module: "example/vpc/aws", Repository: "/srv/git/foo.git", Path: "modules/vpc"},
+			}},
+		},
+		{
+			annotation:    "invalid module",
+			config:        GitConfig{Modules: []GitModule{{Module: "example/foo", Repository: "/srv/git/foo.git"}}},
+			expectedError: true,
+		},
+		{
+			annotation:    "missing repository",
+			config:        GitConfig{Modules: []GitModule{{Module: "example/foo/aws"}}},
+			expectedError: true,
+		},
+		{
+			annotation: "duplicate module",
+			config: GitConfig{Modules: []GitModule{
+				{Module: "example/foo/aws", Repository: "/srv/git/foo.git"},
+				{Module: "example/foo/aws", Repository: "/srv/git/bar.git"},
+			}},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.config.Validate()
+			if tc.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func tarGzFiles(t *testing.T, r io.Reader) []string {
+	t.Helper()
+
+	gr, err := gzip.NewReader(r)
+	assert.NoError(t, err)
+
+	var names []string
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+
+	sort.Strings(names)
+	return names
+}
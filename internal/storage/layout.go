@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+	"github.com/MichielBijland/uncomplicated-registry/internal/utils"
+)
+
+// DefaultModuleKeyLayout is the object key layout of module archives, relative to the bucket prefix.
+const DefaultModuleKeyLayout = "modules/{namespace}/{name}/{provider}/{namespace}-{name}-{provider}-{version}.{ext}"
+
+// Placeholders of a key layout.
+const (
+	layoutNamespace = "namespace"
+	layoutName      = "name"
+	layoutProvider  = "provider"
+	layoutVersion   = "version"
+	layoutExt       = "ext"
+)
+
+// keyLayout maps modules to object keys and back, based on a template like DefaultModuleKeyLayout.
+// Placeholders may occur more than once, all occurrences must have the same value. Values never contain a "/".
+type keyLayout struct {
+	template string
+	tokens   []layoutToken
+	// format is the archive format of layouts without {ext}, taken from the extension of their keys.
+	format string
+}
+
+// layoutToken is either a literal or a placeholder.
+type layoutToken struct {
+	literal     string
+	placeholder string
+}
+
+// parseKeyLayout parses a key layout template.
+func parseKeyLayout(template string) (*keyLayout, error) {
+	l := &keyLayout{template: template}
+
+	rest := template
+	for rest != "" {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			l.tokens = append(l.tokens, layoutToken{literal: rest})
+			break
+		}
+
+		if start > 0 {
+			l.tokens = append(l.tokens, layoutToken{literal: rest[:start]})
+		}
+
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("key layout %q: unterminated placeholder", template)
+		}
+
+		placeholder := rest[start+1 : start+end]
+		switch placeholder {
+		case layoutNamespace, layoutName, layoutProvider, layoutVersion, layoutExt:
+		default:
+			return nil, fmt.Errorf("key layout %q: unknown placeholder {%s}", template, placeholder)
+		}
+
+		// Adjacent placeholders cannot be told apart when parsing a key
+		if n := len(l.tokens); n > 0 && l.tokens[n-1].placeholder != "" {
+			return nil, fmt.Errorf("key layout %q: placeholders must be separated by a literal", template)
+		}
+
+		l.tokens = append(l.tokens, layoutToken{placeholder: placeholder})
+		rest = rest[start+end+1:]
+	}
+
+	for _, required := range []string{layoutNamespace, layoutName, layoutProvider, layoutVersion} {
+		if !strings.Contains(template, "{"+required+"}") {
+			return nil, fmt.Errorf("key layout %q: missing placeholder {%s}", template, required)
+		}
+	}
+
+	if !l.hasExt() {
+		for _, format := range utils.ArchiveFormats {
+			if strings.HasSuffix(template, "."+format) {
+				l.format = format
+			}
+		}
+	}
+
+	return l, nil
+}
+
+// validate ensures that keys rendered by the layout are parsed back into the same module.
+func (l *keyLayout) validate(ext string) error {
+	samples := []core.Module{
+		{Namespace: "hashicorp", Name: "consul", Provider: "aws", Version: "0.11.0"},
+		{Namespace: "hashicorp", Name: "private-key", Provider: "aws", Version: "1.2.3-beta.1"},
+	}
+
+	for _, sample := range samples {
+		key := l.render("prefix", sample, ext)
+
+		m, _, err := l.moduleFromKey(key, []string{ext})
+		if err != nil {
+			return fmt.Errorf("key layout %q: cannot parse %q: %w", l.template, key, err)
+		}
+
+		if *m != sample {
+			return fmt.Errorf("key layout %q: %q was parsed as %s instead of %s", l.template, key, m.ID(true), sample.ID(true))
+		}
+	}
+
+	return nil
+}
+
+// render returns the object key of a module version.
+func (l *keyLayout) render(prefix string, m core.Module, ext string) string {
+	values := layoutValues(m, ext)
+
+	var b strings.Builder
+	for _, t := range l.tokens {
+		if t.placeholder == "" {
+			b.WriteString(t.literal)
+		} else {
+			b.WriteString(values[t.placeholder])
+		}
+	}
+
+	return path.Join(prefix, b.String())
+}
+
+// modulePrefix returns the key prefix shared by all versions of a module.
+func (l *keyLayout) modulePrefix(prefix, namespace, name, provider string) string {
+	values := layoutValues(core.Module{Namespace: namespace, Name: name, Provider: provider}, "")
+
+	var b strings.Builder
+	for _, t := range l.tokens {
+		if t.placeholder == layoutVersion || t.placeholder == layoutExt {
+			break
+		}
+
+		if t.placeholder == "" {
+			b.WriteString(t.literal)
+		} else {
+			b.WriteString(values[t.placeholder])
+		}
+	}
+
+	// path.Join would remove a trailing slash, which is significant for a prefix
+	if prefix == "" {
+		return b.String()
+	}
+
+	return strings.TrimSuffix(prefix, "/") + "/" + b.String()
+}
+
+// staticPrefix returns the key prefix shared by all modules.
+func (l *keyLayout) staticPrefix(prefix string) string {
+	var static string
+	if len(l.tokens) > 0 && l.tokens[0].placeholder == "" {
+		// Only complete directories, the remainder could be part of a value
+		static = l.tokens[0].literal[:strings.LastIndex(l.tokens[0].literal, "/")+1]
+	}
+
+	if prefix == "" {
+		return static
+	}
+
+	return strings.TrimSuffix(prefix, "/") + "/" + static
+}
+
+// moduleFromKey parses an object key into a module and returns its archive format, one of formats.
+// The key may contain an arbitrary prefix in front of the layout.
+func (l *keyLayout) moduleFromKey(key string, formats []string) (*core.Module, string, error) {
+	for rest := key; ; {
+		for _, format := range formats {
+			values := map[string]string{layoutExt: format}
+			if l.match(l.tokens, rest, values) {
+				return &core.Module{
+					Namespace: values[layoutNamespace],
+					Name:      values[layoutName],
+					Provider:  values[layoutProvider],
+					Version:   values[layoutVersion],
+				}, format, nil
+			}
+		}
+
+		i := strings.Index(rest, "/")
+		if i < 0 {
+			return nil, "", fmt.Errorf("key %q does not match layout %q", key, l.template)
+		}
+		rest = rest[i+1:]
+	}
+}
+
+// hasExt reports whether the archive format is part of the layout.
+// Otherwise all keys share a single archive format.
+func (l *keyLayout) hasExt() bool {
+	for _, t := range l.tokens {
+		if t.placeholder == layoutExt {
+			return true
+		}
+	}
+
+	return false
+}
+
+// match matches the tokens against s, binding placeholder values. The shortest values that match are used.
+func (l *keyLayout) match(tokens []layoutToken, s string, values map[string]string) bool {
+	if len(tokens) == 0 {
+		return s == ""
+	}
+
+	t := tokens[0]
+	if t.placeholder == "" {
+		return strings.HasPrefix(s, t.literal) && l.match(tokens[1:], s[len(t.literal):], values)
+	}
+
+	if v, ok := values[t.placeholder]; ok {
+		return v != "" && strings.HasPrefix(s, v) && l.match(tokens[1:], s[len(v):], values)
+	}
+
+	for i := 1; i <= len(s) && s[i-1] != '/'; i++ {
+		values[t.placeholder] = s[:i]
+		if l.match(tokens[1:], s[i:], values) {
+			return true
+		}
+	}
+
+	delete(values, t.placeholder)
+	return false
+}
+
+func layoutValues(m core.Module, ext string) map[string]string {
+	return map[string]string{
+		layoutNamespace: m.Namespace,
+		layoutName:      m.Name,
+		layoutProvider:  m.Provider,
+		layoutVersion:   m.Version,
+		layoutExt:       ext,
+	}
+}
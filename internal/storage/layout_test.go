@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+	"github.com/MichielBijland/uncomplicated-registry/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyLayout_ModuleFromKey(t *testing.T) {
+	t.Parallel()
+
+	testCase := []struct {
+		annotation    string
+		key           string
+		fileExtension string
+		expectedError bool
+		result        core.Module
+	}{
+		{
+			annotation:    "empty path",
+			key:           "",
+			expectedError: true,
+		},
+		{
+			annotation:    "empty file extension",
+			key:           "/",
+			fileExtension: "",
+			expectedError: true,
+		},
+		{
+			annotation:    "valid key without prefix",
+			key:           "/modules/hashicorp/consul/aws/hashicorp-consul-aws-0.11.0.tar.gz",
+			fileExtension: "tar.gz",
+			expectedError: false,
+			result: core.Module{
+				Namespace: "hashicorp",
+				Name:      "consul",
+				Provider:  "aws",
+				Version:   "0.11.0",
+			},
+		},
+		{
+			annotation:    "valid key with prefix",
+			key:           "/uncomplicated-registry/modules/hashicorp/consul/aws/hashicorp-consul-aws-0.11.0.tar.gz",
+			fileExtension: "tar.gz",
+			expectedError: false,
+			result: core.Module{
+				Namespace: "hashicorp",
+				Name:      "consul",
+				Provider:  "aws",
+				Version:   "0.11.0",
+			},
+		},
+		{
+			annotation:    "valid key with longer prefix",
+			key:           "/uncomplicated-registry/test/modules/hashicorp/consul/aws/hashicorp-consul-aws-0.11.0.tar.gz",
+			fileExtension: "tar.gz",
+			expectedError: false,
+			result: core.Module{
+				Namespace: "hashicorp",
+				Name:      "consul",
+				Provider:  "aws",
+				Version:   "0.11.0",
+			},
+		},
+		{
+			annotation:    "key with another file extension than provided",
+			key:           "/uncomplicated-registry/test/modules/hashicorp/consul/aws/hashicorp-consul-aws-0.11.0.zip",
+			fileExtension: "tar.gz",
+			expectedError: true,
+		},
+		{
+			annotation:    "key with 4 hyphens in the file",
+			key:           "/uncomplicated-registry/test/modules/hashicorp/consul/aws/hashicorp-consul-hashicorp-aws-0.11.0-beta1.tar.gz",
+			fileExtension: "tar.gz",
+			expectedError: true,
+		},
+		{
+			annotation:    "module with a hyphen in the name",
+			key:           "/uncomplicated-registry/test/modules/hashicorp/private-key/aws/hashicorp-private-key-aws-0.11.0.tar.gz",
+			fileExtension: "tar.gz",
+			expectedError: false,
+			result: core.Module{
+				Namespace: "hashicorp",
+				Name:      "private-key",
+				Provider:  "aws",
+				Version:   "0.11.0",
+			},
+		},
+		{
+			annotation:    "key with pre-release version",
+			key:           "/uncomplicated-registry/test/modules/hashicorp/consul/aws/hashicorp-consul-aws-0.11.0-beta1.tar.gz",
+			fileExtension: "tar.gz",
+			expectedError: false,
+			result: core.Module{
+				Namespace: "hashicorp",
+				Name:      "consul",
+				Provider:  "aws",
+				Version:   "0.11.0-beta1",
+			},
+		},
+	}
+
+	for _, tc := range testCase {
+		tc := tc
+		t.Run(tc.annotation, func(t *testing.T) {
+			layout, err := parseKeyLayout(DefaultModuleKeyLayout)
+			assert.NoError(t, err)
+
+			result, _, err := layout.moduleFromKey(tc.key, []string{tc.fileExtension})
+			if tc.expectedError {
+				assert.Error(t, err)
+				return
+			} else {
+				assert.NoError(t, err)
+			}
+
+			assert.EqualValues(t, tc.result, *result)
+		})
+	}
+}
+
+func TestParseKeyLayout(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		annotation     string
+		layout         string
+		expectedFormat string
+		expectedError  bool
+	}{
+		{
+			annotation: "default layout",
+			layout:     DefaultModuleKeyLayout,
+		},
+		{
+			annotation:     "directory per version",
+			layout:         "{namespace}/{name}/{provider}/{version}/module.zip",
+			expectedFormat: utils.ArchiveFormatZip,
+		},
+		{
+			annotation:     "directory per version with tar.gz archives",
+			layout:         "{namespace}/{name}/{provider}/{version}/module.tar.gz",
+			expectedFormat: utils.ArchiveFormatTarGz,
+		},
+		{
+			annotation: "directory per version without extension",
+			layout:     "{namespace}/{name}/{provider}/{version}/module",
+		},
+		{
+			annotation:    "unknown placeholder",
+			layout:        "{namespace}/{name}/{provider}/{version}/{file}",
+			expectedError: true,
+		},
+		{
+			annotation:    "missing version",
+			layout:        "{namespace}/{name}/{provider}/module.zip",
+			expectedError: true,
+		},
+		{
+			annotation:    "unterminated placeholder",
+			layout:        "{namespace}/{name}/{provider}/{version",
+			expectedError: true,
+		},
+		{
+			annotation:    "adjacent placeholders",
+			layout:        "{namespace}/{name}/{provider}/{version}{ext}",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.annotation, func(t *testing.T) {
+			layout, err := parseKeyLayout(tc.layout)
+			if tc.expectedError {
+				assert.Error(t, err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, tc.expectedFormat, layout.format)
+			}
+		})
+	}
+}
+
+func TestKeyLayout_Validate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		annotation    string
+		layout        string
+		expectedError bool
+	}{
+		{
+			annotation: "default layout",
+			layout:     DefaultModuleKeyLayout,
+		},
+		{
+			annotation: "directory per version",
+			layout:     "{namespace}/{name}/{provider}/{version}/module.zip",
+		},
+		{
+			annotation:    "ambiguous name and provider",
+			layout:        "{namespace}/{name}-{provider}/{version}.{ext}",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.annotation, func(t *testing.T) {
+			layout, err := parseKeyLayout(tc.layout)
+			assert.NoError(t, err)
+
+			err = layout.validate(DefaultModuleArchiveFormat)
+			if tc.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestKeyLayout_Render(t *testing.T) {
+	t.Parallel()
+
+	m := core.Module{
+		Namespace: "hashicorp",
+		Name:      "consul",
+		Provider:  "aws",
+		Version:   "0.11.0",
+	}
+
+	testCases := []struct {
+		annotation           string
+		layout               string
+		prefix               string
+		expectedKey          string
+		expectedModulePrefix string
+		expectedStaticPrefix string
+	}{
+		{
+			annotation:           "default layout without prefix",
+			layout:               DefaultModuleKeyLayout,
+			expectedKey:          "modules/hashicorp/consul/aws/hashicorp-consul-aws-0.11.0.tar.gz",
+			expectedModulePrefix: "modules/hashicorp/consul/aws/hashicorp-consul-aws-",
+			expectedStaticPrefix: "modules/",
+		},
+		{
+			annotation:           "default layout with prefix",
+			layout:               DefaultModuleKeyLayout,
+			prefix:               "registry",
+			expectedKey:          "registry/modules/hashicorp/consul/aws/hashicorp-consul-aws-0.11.0.tar.gz",
+			expectedModulePrefix: "registry/modules/hashicorp/consul/aws/hashicorp-consul-aws-",
+			expectedStaticPrefix: "registry/modules/",
+		},
+		{
+			annotation:           "directory per version",
+			layout:               "{namespace}/{name}/{provider}/{version}/module.zip",
+			prefix:               "registry/",
+			expectedKey:          "registry/hashicorp/consul/aws/0.11.0/module.zip",
+			expectedModulePrefix: "registry/hashicorp/consul/aws/",
+			expectedStaticPrefix: "registry/",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.annotation, func(t *testing.T) {
+			layout, err := parseKeyLayout(tc.layout)
+			assert.NoError(t, err)
+
+			key := layout.render(tc.prefix, m, DefaultModuleArchiveFormat)
+			assert.Equal(t, tc.expectedKey, key)
+			assert.Equal(t, tc.expectedModulePrefix, layout.modulePrefix(tc.prefix, m.Namespace, m.Name, m.Provider))
+			assert.Equal(t, tc.expectedStaticPrefix, layout.staticPrefix(tc.prefix))
+
+			result, format, err := layout.moduleFromKey(key, utils.ArchiveFormats)
+			assert.NoError(t, err)
+			assert.Equal(t, DefaultModuleArchiveFormat, format)
+			assert.NoError(t, err)
+			assert.Equal(t, m, *result)
+		})
+	}
+}
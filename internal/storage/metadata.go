@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/pkg/errors"
+)
+
+// metadataDir holds the registry metadata relative to the bucket prefix, outside of the module key layout.
+const metadataDir = "_registry"
+
+// versionsDir holds the markers of the published module versions below the metadataDir.
+const versionsDir = "versions"
+
+// MetadataStorage is implemented by storages that can keep registry metadata, e.g. API tokens, next to the modules.
+// Keys are slash separated paths.
+type MetadataStorage interface {
+	// GetMetadata returns the data stored under the key, or ErrMetadataNotFound.
+	GetMetadata(ctx context.Context, key string) ([]byte, error)
+	// PutMetadata stores the data under the key, replacing existing data.
+	PutMetadata(ctx context.Context, key string, data []byte) error
+	// ListMetadata returns the keys starting with the prefix.
+	ListMetadata(ctx context.Context, prefix string) ([]string, error)
+}
+
+func metadataKey(prefix, key string) string {
+	return path.Join(prefix, metadataDir, key)
+}
+
+// isMetadataKey reports whether the key, relative to the bucket prefix, holds registry metadata.
+func isMetadataKey(key string) bool {
+	return strings.HasPrefix(key, metadataDir+"/")
+}
+
+func (s *S3Storage) GetMetadata(ctx context.Context, key string) ([]byte, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(metadataKey(s.bucketPrefix, key)),
+	}
+
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.sseCustomerParams()
+
+	resp, err := s.client.GetObject(ctx, input)
+	if err != nil {
+		var notFound *types.NoSuchKey
+		if errors.As(err, &notFound) {
+			return nil, errors.Wrap(ErrMetadataNotFound, key)
+		}
+		return nil, errors.Wrapf(err, "failed to get metadata: %s", key)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read metadata: %s", key)
+	}
+
+	return data, nil
+}
+
+func (s *S3Storage) PutMetadata(ctx context.Context, key string, data []byte) error {
+	input := s.putObjectInput(metadataKey(s.bucketPrefix, key), bytes.NewReader(data), nil)
+
+	if _, err := s.client.PutObject(ctx, input); err != nil {
+		return errors.Wrapf(err, "failed to put metadata: %s", key)
+	}
+
+	return nil
+}
+
+func (s *S3Storage) ListMetadata(ctx context.Context, prefix string) ([]string, error) {
+	base := metadataKey(s.bucketPrefix, "") + "/"
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(base + prefix),
+	}
+
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(s.client, input)
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list metadata: %s", prefix)
+		}
+
+		for _, obj := range resp.Contents {
+			keys = append(keys, strings.TrimPrefix(*obj.Key, base))
+		}
+	}
+
+	return keys, nil
+}
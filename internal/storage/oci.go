@@ -0,0 +1,568 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+	"github.com/MichielBijland/uncomplicated-registry/internal/utils"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	ociname "github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/pkg/errors"
+)
+
+// OCIModuleArtifactType is the artifact type of module versions stored in an OCI registry.
+const OCIModuleArtifactType = "application/vnd.uncomplicated-registry.module.v1"
+
+// Media types of the module artifacts.
+const (
+	ociEmptyConfigMediaType = "application/vnd.oci.empty.v1+json"
+	ociLayerTarGzMediaType  = "application/vnd.uncomplicated-registry.module.layer.v1.tar+gzip"
+	ociLayerZipMediaType    = "application/vnd.uncomplicated-registry.module.layer.v1.zip"
+)
+
+// Manifest annotations holding the publish metadata of a module.
+// The standard OCI annotations are used where they exist.
+const (
+	ociAnnotationTitle       = "org.opencontainers.image.title"
+	ociAnnotationCreated     = "org.opencontainers.image.created"
+	ociAnnotationSource      = "org.opencontainers.image.source"
+	ociAnnotationRevision    = "org.opencontainers.image.revision"
+	ociAnnotationPublisher   = "io.uncomplicated-registry.module.publisher"
+	ociAnnotationCIRunURL    = "io.uncomplicated-registry.module.ci-run-url"
+	ociAnnotationLabelPrefix = "io.uncomplicated-registry.module.label."
+)
+
+// ociEmptyConfig is the config blob of module artifacts, see the OCI image spec on artifacts.
+var ociEmptyConfig = []byte("{}")
+
+// OCIStorage is a Storage implementation backed by an OCI registry.
+// Every module version is an artifact tagged <repository>/<namespace>/<name>-<provider>:<version>.
+// OCIStorage implements module.Storage
+type OCIStorage struct {
+	repository     string
+	insecure       bool
+	username       string
+	password       string
+	transport      http.RoundTripper
+	downloadPrefix string
+	nameOptions    []ociname.Option
+}
+
+// ociManifest is an OCI image manifest, including the artifactType field of image spec v1.1.
+type ociManifest struct {
+	SchemaVersion int64             `json:"schemaVersion"`
+	MediaType     types.MediaType   `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        v1.Descriptor     `json:"config"`
+	Layers        []v1.Descriptor   `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// ociRawManifest implements remote.Taggable for an encoded manifest.
+type ociRawManifest []byte
+
+func (m ociRawManifest) RawManifest() ([]byte, error) {
+	return m, nil
+}
+
+func (m ociRawManifest) MediaType() (types.MediaType, error) {
+	return types.OCIManifestSchema1, nil
+}
+
+// GetModule retrieves information about a module from the OCI registry.
+func (s *OCIStorage) GetModule(ctx context.Context, namespace, name, provider, version string) (core.Module, error) {
+	repo, err := s.repo(namespace, name, provider)
+	if err != nil {
+		return core.Module{}, err
+	}
+
+	manifest, err := s.manifest(ctx, repo, version)
+	if err != nil {
+		return core.Module{}, err
+	}
+
+	layer, format, err := manifest.moduleLayer()
+	if err != nil {
+		return core.Module{}, errors.Wrap(err, repo.Tag(ociTag(version)).String())
+	}
+
+	m := core.Module{
+		Namespace: namespace,
+		Name:      name,
+		Provider:  provider,
+		Version:   version,
+		Publish:   manifest.publishMetadata(layer),
+	}
+
+	if m.DownloadURL, err = s.downloadURL(m, repo, layer, format); err != nil {
+		return core.Module{}, err
+	}
+
+	return m, nil
+}
+
+// ListModuleVersions returns the module versions tagged in the repository of the module.
+// Tags that are not module artifacts are skipped.
+func (s *OCIStorage) ListModuleVersions(ctx context.Context, namespace, name, provider string) ([]core.Module, error) {
+	repo, err := s.repo(namespace, name, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := remote.List(repo, s.options(ctx)...)
+	if isOCINotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(ErrModuleListFailed, err.Error())
+	}
+
+	var modules []core.Module
+	for _, tag := range tags {
+		m, err := s.GetModule(ctx, namespace, name, provider, ociVersion(tag))
+		if err != nil {
+			continue
+		}
+
+		m.Publish = nil
+		modules = append(modules, m)
+	}
+
+	return modules, nil
+}
+
+// ListModules returns all module versions in the OCI registry.
+// The registry has to support the catalog API.
+func (s *OCIStorage) ListModules(ctx context.Context) ([]core.Module, error) {
+	base, err := ociname.NewRepository(s.repository, s.nameOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	repositories, err := remote.Catalog(ctx, base.Registry, s.options(ctx)...)
+	if err != nil {
+		return nil, errors.Wrap(ErrModuleListFailed, err.Error())
+	}
+
+	var modules []core.Module
+	for _, repository := range repositories {
+		namespace, name, provider, ok := ociModuleFromRepository(base.RepositoryStr(), repository)
+		if !ok {
+			continue
+		}
+
+		versions, err := s.ListModuleVersions(ctx, namespace, name, provider)
+		if err != nil {
+			return nil, err
+		}
+
+		modules = append(modules, versions...)
+	}
+
+	return modules, nil
+}
+
+// UploadModule pushes a module as an artifact to the OCI registry.
+// Tags cannot be written conditionally, a concurrent upload of the same version is not detected.
+func (s *OCIStorage) UploadModule(ctx context.Context, namespace, name, provider, version string, body io.Reader, publish core.PublishMetadata) (core.Module, error) {
+	if namespace == "" {
+		return core.Module{}, errors.New("namespace not defined")
+	}
+
+	if name == "" {
+		return core.Module{}, errors.New("name not defined")
+	}
+
+	if provider == "" {
+		return core.Module{}, errors.New("provider not defined")
+	}
+
+	if version == "" {
+		return core.Module{}, errors.New("version not defined")
+	}
+
+	repo, err := s.repo(namespace, name, provider)
+	if err != nil {
+		return core.Module{}, err
+	}
+
+	tag := repo.Tag(ociTag(version))
+	if _, err := remote.Head(tag, s.options(ctx)...); err == nil {
+		return core.Module{}, errors.Wrap(ErrModuleAlreadyExists, tag.String())
+	}
+
+	// Detect the archive format from the content
+	br := bufio.NewReader(body)
+	header, _ := br.Peek(4)
+	format := utils.DetectArchiveFormat(header, DefaultModuleArchiveFormat)
+
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return core.Module{}, errors.Wrap(ErrModuleUploadFailed, err.Error())
+	}
+
+	layer := static.NewLayer(data, ociLayerMediaType(format))
+	config := static.NewLayer(ociEmptyConfig, ociEmptyConfigMediaType)
+
+	var descriptors []v1.Descriptor
+	for _, l := range []v1.Layer{config, layer} {
+		if err := remote.WriteLayer(repo, l, s.options(ctx)...); err != nil {
+			return core.Module{}, errors.Wrap(ErrModuleUploadFailed, err.Error())
+		}
+
+		digest, _ := l.Digest()
+		mediaType, _ := l.MediaType()
+		size, _ := l.Size()
+		descriptors = append(descriptors, v1.Descriptor{MediaType: mediaType, Digest: digest, Size: size})
+	}
+
+	m := core.Module{
+		Namespace: namespace,
+		Name:      name,
+		Provider:  provider,
+		Version:   version,
+	}
+
+	descriptors[1].Annotations = map[string]string{
+		ociAnnotationTitle: archiveFileName(m, format),
+	}
+
+	publish.PublishedAt = time.Now().UTC()
+	manifest := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		ArtifactType:  OCIModuleArtifactType,
+		Config:        descriptors[0],
+		Layers:        descriptors[1:],
+		Annotations:   ociAnnotations(publish),
+	}
+
+	raw, err := json.Marshal(manifest)
+	if err != nil {
+		return core.Module{}, errors.Wrap(ErrModuleUploadFailed, err.Error())
+	}
+
+	if err := remote.Put(tag, ociRawManifest(raw), s.options(ctx)...); err != nil {
+		return core.Module{}, errors.Wrap(ErrModuleUploadFailed, err.Error())
+	}
+
+	return s.GetModule(ctx, namespace, name, provider, version)
+}
+
+// DeleteModule removes the manifest of a module version from the OCI registry.
+// Blobs are removed by the garbage collection of the registry.
+func (s *OCIStorage) DeleteModule(ctx context.Context, namespace, name, provider, version string) error {
+	repo, err := s.repo(namespace, name, provider)
+	if err != nil {
+		return err
+	}
+
+	tag := repo.Tag(ociTag(version))
+
+	desc, err := remote.Head(tag, s.options(ctx)...)
+	if err != nil {
+		return errors.Wrap(ErrModuleDeleteFailed, err.Error())
+	}
+
+	// Deleting a tag is optional in the distribution spec, registries that don't support it
+	// remove the tags together with the manifest.
+	_ = remote.Delete(tag, s.options(ctx)...)
+
+	if err := remote.Delete(repo.Digest(desc.Digest.String()), s.options(ctx)...); err != nil && !isOCINotFound(err) {
+		return errors.Wrap(ErrModuleDeleteFailed, err.Error())
+	}
+
+	return nil
+}
+
+// GetModuleArchive streams the archive of a module from the OCI registry.
+// It serves the download URLs when the storage is configured with a download prefix.
+func (s *OCIStorage) GetModuleArchive(ctx context.Context, namespace, name, provider, version string) (io.Reader, error) {
+	repo, err := s.repo(namespace, name, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := s.manifest(ctx, repo, version)
+	if err != nil {
+		return nil, err
+	}
+
+	layer, _, err := manifest.moduleLayer()
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := remote.Layer(repo.Digest(layer.Digest.String()), s.options(ctx)...)
+	if err != nil {
+		return nil, errors.Wrap(ErrModuleNotFound, err.Error())
+	}
+
+	return blob.Compressed()
+}
+
+// manifest fetches and decodes the manifest of a module version.
+func (s *OCIStorage) manifest(ctx context.Context, repo ociname.Repository, version string) (ociManifest, error) {
+	tag := repo.Tag(ociTag(version))
+
+	desc, err := remote.Get(tag, s.options(ctx)...)
+	if err != nil {
+		return ociManifest{}, errors.Wrap(ErrModuleNotFound, err.Error())
+	}
+
+	var manifest ociManifest
+	if err := json.Unmarshal(desc.Manifest, &manifest); err != nil {
+		return ociManifest{}, errors.Wrapf(err, "invalid manifest: %s", tag)
+	}
+
+	if manifest.ArtifactType != OCIModuleArtifactType {
+		return ociManifest{}, errors.Wrapf(ErrModuleNotFound, "%s is not a module artifact", tag)
+	}
+
+	return manifest, nil
+}
+
+// repo returns the repository of a module.
+func (s *OCIStorage) repo(namespace, name, provider string) (ociname.Repository, error) {
+	return ociRepository(s.repository, namespace, name, provider, s.nameOptions...)
+}
+
+// downloadURL returns the URL of the archive, served by the OCI registry or by the registry itself.
+func (s *OCIStorage) downloadURL(m core.Module, repo ociname.Repository, layer v1.Descriptor, format string) (string, error) {
+	if s.downloadPrefix != "" {
+		return path.Join("/", s.downloadPrefix, m.Namespace, m.Name, m.Provider, m.Version, "archive", archiveFileName(m, format)), nil
+	}
+
+	blob := url.URL{
+		Scheme: repo.Scheme(),
+		Host:   repo.RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/blobs/%s", repo.RepositoryStr(), layer.Digest),
+	}
+
+	return archiveDownloadURL(blob.String(), format, layer.Digest.String())
+}
+
+func (s *OCIStorage) options(ctx context.Context) []remote.Option {
+	options := []remote.Option{
+		remote.WithContext(ctx),
+	}
+
+	if s.username != "" {
+		options = append(options, remote.WithAuth(&authn.Basic{Username: s.username, Password: s.password}))
+	} else {
+		options = append(options, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	}
+
+	if s.transport != nil {
+		options = append(options, remote.WithTransport(s.transport))
+	}
+
+	return options
+}
+
+// moduleLayer returns the layer holding the module archive and its format.
+func (m ociManifest) moduleLayer() (v1.Descriptor, string, error) {
+	for _, layer := range m.Layers {
+		switch layer.MediaType {
+		case ociLayerTarGzMediaType, ociLayerZipMediaType:
+		default:
+			continue
+		}
+
+		// The title keeps tgz and tar.gz apart, which share a media type
+		title := layer.Annotations[ociAnnotationTitle]
+		for _, format := range utils.ArchiveFormats {
+			if strings.HasSuffix(title, "."+format) && ociLayerMediaType(format) == layer.MediaType {
+				return layer, format, nil
+			}
+		}
+
+		if layer.MediaType == ociLayerZipMediaType {
+			return layer, utils.ArchiveFormatZip, nil
+		}
+
+		return layer, utils.ArchiveFormatTarGz, nil
+	}
+
+	return v1.Descriptor{}, "", errors.New("manifest has no module layer")
+}
+
+// publishMetadata restores the publish metadata from the manifest annotations.
+func (m ociManifest) publishMetadata(layer v1.Descriptor) *core.PublishMetadata {
+	publish := &core.PublishMetadata{
+		Publisher: m.Annotations[ociAnnotationPublisher],
+		Source:    m.Annotations[ociAnnotationSource],
+		Commit:    m.Annotations[ociAnnotationRevision],
+		CIRunURL:  m.Annotations[ociAnnotationCIRunURL],
+		Size:      layer.Size,
+		Digest:    layer.Digest.String(),
+	}
+
+	if created, err := time.Parse(time.RFC3339, m.Annotations[ociAnnotationCreated]); err == nil {
+		publish.PublishedAt = created.UTC()
+	}
+
+	for k, v := range m.Annotations {
+		if strings.HasPrefix(k, ociAnnotationLabelPrefix) {
+			if publish.Labels == nil {
+				publish.Labels = make(map[string]string)
+			}
+			publish.Labels[strings.TrimPrefix(k, ociAnnotationLabelPrefix)] = v
+		}
+	}
+
+	return publish
+}
+
+// ociAnnotations converts publish metadata into manifest annotations.
+func ociAnnotations(publish core.PublishMetadata) map[string]string {
+	annotations := map[string]string{
+		ociAnnotationCreated: publish.PublishedAt.Format(time.RFC3339),
+	}
+
+	set := func(key, value string) {
+		if value != "" {
+			annotations[key] = value
+		}
+	}
+
+	set(ociAnnotationPublisher, publish.Publisher)
+	set(ociAnnotationSource, publish.Source)
+	set(ociAnnotationRevision, publish.Commit)
+	set(ociAnnotationCIRunURL, publish.CIRunURL)
+
+	for k, v := range publish.Labels {
+		set(ociAnnotationLabelPrefix+k, v)
+	}
+
+	return annotations
+}
+
+// ociLayerMediaType returns the layer media type of an archive format.
+func ociLayerMediaType(format string) types.MediaType {
+	if format == utils.ArchiveFormatZip {
+		return ociLayerZipMediaType
+	}
+
+	return ociLayerTarGzMediaType
+}
+
+// ociRepository returns the repository of a module below the base repository.
+func ociRepository(base, namespace, name, provider string, options ...ociname.Option) (ociname.Repository, error) {
+	return ociname.NewRepository(path.Join(base, namespace, name+"-"+provider), options...)
+}
+
+// ociModuleFromRepository parses a repository path returned by the catalog API.
+// Provider names cannot contain a "-", so the name ends at the last one.
+func ociModuleFromRepository(base, repository string) (namespace, name, provider string, ok bool) {
+	rest := strings.TrimPrefix(repository, base+"/")
+	if base != "" && rest == repository {
+		return "", "", "", false
+	}
+
+	namespace, nameProvider, ok := strings.Cut(rest, "/")
+	if !ok || strings.Contains(nameProvider, "/") {
+		return "", "", "", false
+	}
+
+	i := strings.LastIndex(nameProvider, "-")
+	if i <= 0 || i == len(nameProvider)-1 {
+		return "", "", "", false
+	}
+
+	return namespace, nameProvider[:i], nameProvider[i+1:], true
+}
+
+// ociTag converts a version into a tag. Tags cannot contain a "+", which is replaced by "_".
+func ociTag(version string) string {
+	return strings.ReplaceAll(version, "+", "_")
+}
+
+// ociVersion converts a tag back into a version.
+func ociVersion(tag string) string {
+	return strings.ReplaceAll(tag, "_", "+")
+}
+
+// isOCINotFound reports whether the registry does not know the repository.
+func isOCINotFound(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound
+}
+
+// archiveFileName returns the file name of a module archive.
+func archiveFileName(m core.Module, format string) string {
+	return fmt.Sprintf("%s-%s-%s-%s.%s", m.Namespace, m.Name, m.Provider, m.Version, format)
+}
+
+// OCIStorageOption provides additional options for the OCIStorage.
+type OCIStorageOption func(*OCIStorage)
+
+// WithOCIStorageInsecure allows plain HTTP connections to the registry.
+// TLS certificates are still verified, use WithOCIStorageTransport to trust other certificates.
+func WithOCIStorageInsecure(insecure bool) OCIStorageOption {
+	return func(s *OCIStorage) {
+		s.insecure = insecure
+	}
+}
+
+// WithOCIStorageBasicAuth configures static credentials for the registry.
+// Without credentials, the Docker config of the user is used.
+func WithOCIStorageBasicAuth(username, password string) OCIStorageOption {
+	return func(s *OCIStorage) {
+		s.username = username
+		s.password = password
+	}
+}
+
+// WithOCIStorageTransport configures the HTTP transport used for requests to the registry.
+func WithOCIStorageTransport(t http.RoundTripper) OCIStorageOption {
+	return func(s *OCIStorage) {
+		s.transport = t
+	}
+}
+
+// WithOCIStorageDownloadPrefix serves the archives through the registry under the given path,
+// instead of handing out download URLs of the OCI registry. This is required if the OCI registry
+// does not allow anonymous pulls.
+func WithOCIStorageDownloadPrefix(prefix string) OCIStorageOption {
+	return func(s *OCIStorage) {
+		s.downloadPrefix = prefix
+	}
+}
+
+// NewOCIStorage returns a fully initialized OCI storage.
+// The repository is the registry host followed by a path, e.g. ghcr.io/example/modules.
+func NewOCIStorage(repository string, options ...OCIStorageOption) (*OCIStorage, error) {
+	s := &OCIStorage{
+		repository: strings.TrimSuffix(repository, "/"),
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	if s.insecure {
+		s.nameOptions = append(s.nameOptions, ociname.Insecure)
+	}
+
+	// Catch invalid repositories early, module repositories are built from it
+	if _, err := ociname.NewRepository(s.repository, s.nameOptions...); err != nil {
+		return nil, errors.Wrapf(err, "invalid repository %q", s.repository)
+	}
+
+	return s, nil
+}
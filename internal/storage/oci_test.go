@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+	"github.com/MichielBijland/uncomplicated-registry/internal/utils"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestOCIStorage returns an OCIStorage backed by an in-process OCI distribution server.
+func newTestOCIStorage(t *testing.T, options ...OCIStorageOption) (*OCIStorage, *httptest.Server) {
+	t.Helper()
+
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	s, err := NewOCIStorage(host+"/terraform/modules", append([]OCIStorageOption{WithOCIStorageInsecure(true)}, options...)...)
+	assert.NoError(t, err)
+
+	return s, server
+}
+
+func testZipArchive(t *testing.T) []byte {
+	t.Helper()
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	f, err := zw.Create("main.tf")
+	assert.NoError(t, err)
+	_, err = f.Write([]byte(`name = "foo"`))
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+
+	return buf.Bytes()
+}
+
+func TestOCIStorage(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx       = context.Background()
+		s, server = newTestOCIStorage(t)
+		data      = testZipArchive(t)
+		publish   = core.PublishMetadata{
+			Publisher: "ci@example.com",
+			Source:    "https://github.com/example/terraform-aws-vpc",
+			Commit:    "4a4faad",
+			Labels:    map[string]string{"team": "network"},
+		}
+	)
+
+	m, err := s.UploadModule(ctx, "example", "vpc", "aws", "1.0.0+build.1", bytes.NewReader(data), publish)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.0.0+build.1", m.Version)
+
+	_, err = s.UploadModule(ctx, "example", "vpc", "aws", "1.1.0", bytes.NewReader(data), core.PublishMetadata{})
+	assert.NoError(t, err)
+
+	// Versions are immutable
+	_, err = s.UploadModule(ctx, "example", "vpc", "aws", "1.1.0", bytes.NewReader(data), core.PublishMetadata{})
+	assert.ErrorIs(t, err, ErrModuleAlreadyExists)
+
+	m, err = s.GetModule(ctx, "example", "vpc", "aws", "1.0.0+build.1")
+	assert.NoError(t, err)
+	if assert.NotNil(t, m.Publish) {
+		assert.Equal(t, publish.Publisher, m.Publish.Publisher)
+		assert.Equal(t, publish.Source, m.Publish.Source)
+		assert.Equal(t, publish.Commit, m.Publish.Commit)
+		assert.Equal(t, publish.Labels, m.Publish.Labels)
+		assert.Equal(t, int64(len(data)), m.Publish.Size)
+		assert.True(t, strings.HasPrefix(m.Publish.Digest, "sha256:"))
+		assert.False(t, m.Publish.PublishedAt.IsZero())
+	}
+
+	// The download URL is served by the OCI registry
+	u, err := url.Parse(m.DownloadURL)
+	assert.NoError(t, err)
+	assert.Equal(t, utils.ArchiveFormatZip, u.Query().Get("archive"))
+	assert.Equal(t, m.Publish.Digest, u.Query().Get("checksum"))
+	assert.Equal(t, "/v2/terraform/modules/example/vpc-aws/blobs/"+m.Publish.Digest, u.Path)
+
+	resp, err := http.Get(server.URL + u.Path)
+	assert.NoError(t, err)
+	downloaded, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, data, downloaded)
+
+	archive, err := s.GetModuleArchive(ctx, "example", "vpc", "aws", "1.0.0+build.1")
+	assert.NoError(t, err)
+	downloaded, err = io.ReadAll(archive)
+	assert.NoError(t, err)
+	assert.Equal(t, data, downloaded)
+
+	versions, err := s.ListModuleVersions(ctx, "example", "vpc", "aws")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"1.0.0+build.1", "1.1.0"}, moduleVersions(versions))
+
+	modules, err := s.ListModules(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, modules, 2)
+
+	_, err = s.GetModule(ctx, "example", "vpc", "aws", "2.0.0")
+	assert.ErrorIs(t, err, ErrModuleNotFound)
+
+	versions, err = s.ListModuleVersions(ctx, "example", "unknown", "aws")
+	assert.NoError(t, err)
+	assert.Empty(t, versions)
+
+	assert.NoError(t, s.DeleteModule(ctx, "example", "vpc", "aws", "1.1.0"))
+
+	versions, err = s.ListModuleVersions(ctx, "example", "vpc", "aws")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.0.0+build.1"}, moduleVersions(versions))
+}
+
+func TestOCIStorage_DownloadPrefix(t *testing.T) {
+	t.Parallel()
+
+	var (
+		ctx  = context.Background()
+		s, _ = newTestOCIStorage(t, WithOCIStorageDownloadPrefix("/v1/modules"))
+	)
+
+	_, err := s.UploadModule(ctx, "example", "vpc", "aws", "1.0.0", bytes.NewReader(testZipArchive(t)), core.PublishMetadata{})
+	assert.NoError(t, err)
+
+	m, err := s.GetModule(ctx, "example", "vpc", "aws", "1.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "/v1/modules/example/vpc/aws/1.0.0/archive/example-vpc-aws-1.0.0.zip", m.DownloadURL)
+}
+
+func TestOCIModuleFromRepository(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		annotation string
+		base       string
+		repository string
+		expected   core.Module
+		ok         bool
+	}{
+		{
+			annotation: "module",
+			base:       "terraform/modules",
+			repository: "terraform/modules/example/private-key-aws",
+			expected:   core.Module{Namespace: "example", Name: "private-key", Provider: "aws"},
+			ok:         true,
+		},
+		{
+			annotation: "other repository",
+			base:       "terraform/modules",
+			repository: "terraform/images/example/vpc-aws",
+		},
+		{
+			annotation: "nested repository",
+			base:       "terraform/modules",
+			repository: "terraform/modules/example/vpc-aws/cache",
+		},
+		{
+			annotation: "without provider",
+			base:       "terraform/modules",
+			repository: "terraform/modules/example/vpc",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			namespace, name, provider, ok := ociModuleFromRepository(tc.base, tc.repository)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.expected, core.Module{Namespace: namespace, Name: name, Provider: provider})
+		})
+	}
+}
+
+func moduleVersions(modules []core.Module) []string {
+	versions := make([]string, 0, len(modules))
+	for _, m := range modules {
+		versions = append(versions, m.Version)
+	}
+
+	return versions
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"strings"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+)
+
+// Object metadata keys holding the publish metadata of a module.
+const (
+	publishMetadataPublisher   = "publisher"
+	publishMetadataSource      = "source"
+	publishMetadataCommit      = "commit"
+	publishMetadataCIRunURL    = "ci-run-url"
+	publishMetadataLabelPrefix = "label-"
+)
+
+// publishObjectMetadata converts publish metadata into user-defined object metadata.
+// Label keys are lowercased, as object metadata keys are case-insensitive.
+func publishObjectMetadata(publish core.PublishMetadata) map[string]string {
+	metadata := make(map[string]string)
+
+	set := func(key, value string) {
+		if value != "" {
+			metadata[key] = value
+		}
+	}
+
+	set(publishMetadataPublisher, publish.Publisher)
+	set(publishMetadataSource, publish.Source)
+	set(publishMetadataCommit, publish.Commit)
+	set(publishMetadataCIRunURL, publish.CIRunURL)
+
+	for k, v := range publish.Labels {
+		set(publishMetadataLabelPrefix+strings.ToLower(k), v)
+	}
+
+	return metadata
+}
+
+// publishMetadataFromObject restores the publish metadata from user-defined object metadata.
+// The size and publish time are taken from the object itself.
+func publishMetadataFromObject(metadata map[string]string, size int64, lastModified time.Time) *core.PublishMetadata {
+	publish := &core.PublishMetadata{
+		PublishedAt: lastModified.UTC(),
+		Size:        size,
+	}
+
+	for k, v := range metadata {
+		switch k = strings.ToLower(k); {
+		case k == publishMetadataPublisher:
+			publish.Publisher = v
+		case k == publishMetadataSource:
+			publish.Source = v
+		case k == publishMetadataCommit:
+			publish.Commit = v
+		case k == publishMetadataCIRunURL:
+			publish.CIRunURL = v
+		case strings.HasPrefix(k, publishMetadataLabelPrefix):
+			if publish.Labels == nil {
+				publish.Labels = make(map[string]string)
+			}
+			publish.Labels[strings.TrimPrefix(k, publishMetadataLabelPrefix)] = v
+		}
+	}
+
+	return publish
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishMetadataRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	publishedAt := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		annotation string
+		publish    core.PublishMetadata
+		expected   core.PublishMetadata
+	}{
+		{
+			annotation: "empty metadata",
+			publish:    core.PublishMetadata{},
+			expected: core.PublishMetadata{
+				PublishedAt: publishedAt,
+				Size:        42,
+			},
+		},
+		{
+			annotation: "full metadata",
+			publish: core.PublishMetadata{
+				Publisher: "ci@example.com",
+				Source:    "https://github.com/example/terraform-aws-s3",
+				Commit:    "4a4faad",
+				CIRunURL:  "https://github.com/example/terraform-aws-s3/actions/runs/1",
+				Labels:    map[string]string{"Team": "network"},
+			},
+			expected: core.PublishMetadata{
+				Publisher:   "ci@example.com",
+				Source:      "https://github.com/example/terraform-aws-s3",
+				Commit:      "4a4faad",
+				CIRunURL:    "https://github.com/example/terraform-aws-s3/actions/runs/1",
+				Labels:      map[string]string{"team": "network"},
+				PublishedAt: publishedAt,
+				Size:        42,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.annotation, func(t *testing.T) {
+			metadata := publishObjectMetadata(tc.publish)
+			result := publishMetadataFromObject(metadata, 42, publishedAt)
+			assert.Equal(t, tc.expected, *result)
+		})
+	}
+}
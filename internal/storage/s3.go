@@ -1,17 +1,43 @@
 package storage
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+	"github.com/MichielBijland/uncomplicated-registry/internal/utils"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-sdk-go-v2/otelaws"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
 )
 
 // s3DownloaderAPI is used to mock the AWS APIs
@@ -32,44 +58,145 @@ type S3Storage struct {
 	bucketRegion        string
 	bucketEndpoint      string
 	moduleArchiveFormat string
+	moduleKeyLayout     string
+	keyLayout           *keyLayout
 	forcePathStyle      bool
 	signedURLExpiry     time.Duration
+	contentAddressed    bool
+	downloadPrefix      string
+	logger              zerolog.Logger
+	now                 func() time.Time
+
+	// Client options
+	profile           string
+	accessKeyID       string
+	secretAccessKey   string
+	sessionToken      string
+	assumeRole        s3AssumeRole
+	presignAssumeRole s3AssumeRole
+	maxRetries        int
+	timeout           time.Duration
+	caBundle          string
+	tracerProvider    trace.TracerProvider
+
+	// Upload options
+	sseMode           string
+	sseKMSKeyID       string
+	sseCustomerKey    string
+	sseCustomerKeyMD5 string
+	objectTags        map[string]string
+	storageClass      string
+	acl               string
+}
+
+// Server-side encryption modes of the S3Storage.
+const (
+	S3SSEModeNone     = ""
+	S3SSEModeS3       = "s3"
+	S3SSEModeKMS      = "kms"
+	S3SSEModeCustomer = "customer"
+)
+
+// s3AssumeRole describes an IAM role assumed through STS.
+type s3AssumeRole struct {
+	roleARN     string
+	externalID  string
+	sessionName string
+}
+
+// credentials returns a cached credentials provider assuming the role with the credentials of cfg.
+func (r s3AssumeRole) credentials(cfg aws.Config) aws.CredentialsProvider {
+	return aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), r.roleARN, func(o *stscreds.AssumeRoleOptions) {
+		if r.externalID != "" {
+			o.ExternalID = aws.String(r.externalID)
+		}
+		if r.sessionName != "" {
+			o.RoleSessionName = r.sessionName
+		}
+	}))
 }
 
 // GetModule retrieves information about a module from the S3 storage.
 func (s *S3Storage) GetModule(ctx context.Context, namespace, name, provider, version string) (core.Module, error) {
-	key := modulePath(s.bucketPrefix, namespace, name, provider, version, s.moduleArchiveFormat)
+	if s.contentAddressed {
+		m, err := s.getManifestModule(ctx, namespace, name, provider, version)
+		if err == nil || !errors.Is(err, ErrModuleNotFound) || !s.keyLayout.hasExt() {
+			return m, err
+		}
 
-	input := &s3.HeadObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(key),
+		// Versions published before content addressing was enabled are plain archives
+	}
+
+	return s.getArchiveModule(ctx, namespace, name, provider, version)
+}
+
+// getArchiveModule retrieves information about a module stored as a plain archive.
+func (s *S3Storage) getArchiveModule(ctx context.Context, namespace, name, provider, version string) (core.Module, error) {
+	var (
+		key    string
+		format string
+		head   *s3.HeadObjectOutput
+		err    error
+	)
+
+	// The archive format is not known upfront, try the configured format first
+	for _, format = range s.archiveFormats() {
+		key = s.moduleKey(namespace, name, provider, version, format)
+
+		input := &s3.HeadObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+		}
+
+		input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.sseCustomerParams()
+
+		if head, err = s.client.HeadObject(ctx, input); err == nil {
+			break
+		}
+		if err = s.objectError(err); !errors.Is(err, ErrModuleNotFound) {
+			break
+		}
+	}
+	if err != nil {
+		return core.Module{}, errors.Wrap(err, key)
 	}
 
-	if _, err := s.client.HeadObject(ctx, input); err != nil {
-		return core.Module{}, errors.Wrap(ErrModuleNotFound, err.Error())
+	if err := s.verifyEncryption(head); err != nil {
+		return core.Module{}, errors.Wrap(err, key)
 	}
 
-	presigned, err := s.presignedURL(ctx, key)
+	m := core.Module{
+		Namespace: namespace,
+		Name:      name,
+		Provider:  provider,
+		Version:   version,
+		Publish:   publishMetadataFromObject(head.Metadata, head.ContentLength, aws.ToTime(head.LastModified)),
+	}
+
+	m.DownloadURL, err = s.downloadURL(ctx, key, m, format)
 	if err != nil {
 		return core.Module{}, err
 	}
 
-	return core.Module{
-		Namespace:   namespace,
-		Name:        name,
-		Provider:    provider,
-		Version:     version,
-		DownloadURL: presigned,
-	}, nil
+	return m, nil
 }
 
+// manifestReadConcurrency bounds the manifests read at once when listing the versions of a module.
+const manifestReadConcurrency = 8
+
+// ListModuleVersions returns the versions of a module, taken from the keys of the list response.
+// The manifests of content-addressed versions are read concurrently, to resolve their download URL.
 func (s *S3Storage) ListModuleVersions(ctx context.Context, namespace, name, provider string) ([]core.Module, error) {
 	input := &s3.ListObjectsV2Input{
 		Bucket: aws.String(s.bucket),
-		Prefix: aws.String(modulePathPrefix(s.bucketPrefix, namespace, name, provider)),
+		Prefix: aws.String(s.keyLayout.modulePrefix(s.bucketPrefix, namespace, name, provider)),
 	}
 
-	var modules []core.Module
+	var (
+		modules   []core.Module
+		manifests []int
+	)
+	seen := make(map[string]bool)
 	paginator := s3.NewListObjectsV2Paginator(s.client, input)
 	for paginator.HasMorePages() {
 		resp, err := paginator.NextPage(ctx)
@@ -78,15 +205,26 @@ func (s *S3Storage) ListModuleVersions(ctx context.Context, namespace, name, pro
 		}
 
 		for _, obj := range resp.Contents {
-			m, err := moduleFromObject(*obj.Key, s.moduleArchiveFormat)
+			m, format, err := s.moduleFromKey(*obj.Key)
 			if err != nil {
 				// TODO: we're skipping possible failures silently
 				continue
 			}
 
-			// The download URL is probably not necessary for ListModules
-			m.DownloadURL, err = s.presignedURL(ctx, modulePath(s.bucketPrefix, m.Namespace, m.Name, m.Provider, m.Version, s.moduleArchiveFormat))
-			if err != nil {
+			// The prefix can be shared with other modules, depending on the key layout
+			if m.Namespace != namespace || m.Name != name || m.Provider != provider {
+				continue
+			}
+
+			// A version is listed once, even if it exists in multiple archive formats
+			if seen[m.Version] {
+				continue
+			}
+			seen[m.Version] = true
+
+			if format == manifestExt {
+				manifests = append(manifests, len(modules))
+			} else if m.DownloadURL, err = s.downloadURL(ctx, *obj.Key, *m, format); err != nil {
 				return []core.Module{}, err
 			}
 
@@ -94,11 +232,100 @@ func (s *S3Storage) ListModuleVersions(ctx context.Context, namespace, name, pro
 		}
 	}
 
+	if len(manifests) == 0 {
+		return modules, nil
+	}
+
+	// Versions with an unreadable manifest are skipped
+	skipped := make([]bool, len(modules))
+
+	var group errgroup.Group
+	group.SetLimit(manifestReadConcurrency)
+	for _, i := range manifests {
+		i := i
+		group.Go(func() error {
+			m, err := s.getManifestModule(ctx, namespace, name, provider, modules[i].Version)
+			if err != nil {
+				skipped[i] = true
+				return nil
+			}
+
+			modules[i].DownloadURL = m.DownloadURL
+			return nil
+		})
+	}
+	_ = group.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(ErrModuleListFailed, err.Error())
+	}
+
+	listed := modules[:0]
+	for i, m := range modules {
+		if !skipped[i] {
+			listed = append(listed, m)
+		}
+	}
+
+	return listed, nil
+}
+
+// ListModules returns all module versions in the S3 storage.
+func (s *S3Storage) ListModules(ctx context.Context) ([]core.Module, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.keyLayout.staticPrefix(s.bucketPrefix)),
+	}
+
+	var modules []core.Module
+	seen := make(map[string]bool)
+	paginator := s3.NewListObjectsV2Paginator(s.client, input)
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Wrap(ErrModuleListFailed, err.Error())
+		}
+
+		for _, obj := range resp.Contents {
+			m, _, err := s.moduleFromKey(*obj.Key)
+			if err != nil || seen[m.ID(true)] {
+				continue
+			}
+			seen[m.ID(true)] = true
+
+			modules = append(modules, *m)
+		}
+	}
+
 	return modules, nil
 }
 
+// DeleteModule removes a module version in all archive formats from the S3 storage.
+// With content addressing, the blob is removed as well when no other version references it.
+func (s *S3Storage) DeleteModule(ctx context.Context, namespace, name, provider, version string) error {
+	if s.contentAddressed {
+		if err := s.deleteManifest(ctx, namespace, name, provider, version); err != nil {
+			return err
+		}
+	}
+
+	for _, format := range s.archiveFormats() {
+		input := &s3.DeleteObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.moduleKey(namespace, name, provider, version, format)),
+		}
+
+		if _, err := s.client.DeleteObject(ctx, input); err != nil {
+			return errors.Wrap(ErrModuleDeleteFailed, err.Error())
+		}
+	}
+
+	// The marker is removed last, so the version cannot be published again while archives are left
+	return s.releaseVersion(ctx, namespace, name, provider, version)
+}
+
 // UploadModule uploads a module to the S3 storage.
-func (s *S3Storage) UploadModule(ctx context.Context, namespace, name, provider, version string, body io.Reader) (core.Module, error) {
+func (s *S3Storage) UploadModule(ctx context.Context, namespace, name, provider, version string, body io.Reader, publish core.PublishMetadata) (core.Module, error) {
 	if namespace == "" {
 		return core.Module{}, errors.New("namespace not defined")
 	}
@@ -115,37 +342,616 @@ func (s *S3Storage) UploadModule(ctx context.Context, namespace, name, provider,
 		return core.Module{}, errors.New("version not defined")
 	}
 
-	key := modulePath(s.bucketPrefix, namespace, name, provider, version, DefaultModuleArchiveFormat)
+	// Detect the archive format from the content
+	br := bufio.NewReader(body)
+	header, _ := br.Peek(4)
+	format := utils.DetectArchiveFormat(header, s.moduleArchiveFormat)
+
+	if s.contentAddressed {
+		return s.uploadManifestModule(ctx, namespace, name, provider, version, br, format, publish)
+	}
+
+	if !s.keyLayout.hasExt() && format != s.moduleArchiveFormat {
+		return core.Module{}, errors.Errorf("key layout only supports %s archives, but module is a %s archive", s.moduleArchiveFormat, format)
+	}
+
+	key := s.moduleKey(namespace, name, provider, version, format)
+
+	// The conditional write of the archive only protects the key of its format,
+	// publishers of the same version in another format are stopped by the version marker.
+	if s.keyLayout.hasExt() {
+		if err := s.claimVersion(ctx, namespace, name, provider, version); err != nil {
+			return core.Module{}, err
+		}
+	}
 
+	// Versions published before the markers were introduced have none
 	if _, err := s.GetModule(ctx, namespace, name, provider, version); err == nil {
 		return core.Module{}, errors.Wrap(ErrModuleAlreadyExists, key)
 	}
 
+	input := s.putObjectInput(key, br, publishObjectMetadata(publish))
+
+	// The uploader sends If-None-Match: * (see conditionalWriteMiddleware), so the backend
+	// rejects the write if another publisher created the object first.
+	if _, err := s.uploader.Upload(ctx, input); err != nil {
+		if isConditionalWriteConflict(err) {
+			return core.Module{}, errors.Wrap(ErrModuleAlreadyExists, key)
+		}
+
+		// The version can be published again, even when the upload failed because the request was canceled
+		if s.keyLayout.hasExt() {
+			if err := s.releaseVersion(context.WithoutCancel(ctx), namespace, name, provider, version); err != nil {
+				s.logger.Error().Err(err).Str("key", key).Msg("failed to release module version")
+			}
+		}
+		return core.Module{}, errors.Wrap(ErrModuleUploadFailed, err.Error())
+	}
+
+	return s.GetModule(ctx, namespace, name, provider, version)
+}
+
+// versionClaimTimeout is the time a publisher has to upload the archive of a claimed version.
+// Markers of versions without an archive are taken over once it passed, e.g. after a publisher crashed.
+const versionClaimTimeout = time.Hour
+
+// claimVersion conditionally writes the marker of a module version, which is independent of the archive format.
+// Only one publisher of a version can create it, every other publisher gets ErrModuleAlreadyExists.
+func (s *S3Storage) claimVersion(ctx context.Context, namespace, name, provider, version string) error {
+	key := s.versionKey(namespace, name, provider, version)
+
+	// Every claim has its own content, so a marker that was taken over has another ETag
+	claim := make([]byte, 16)
+	if _, err := rand.Read(claim); err != nil {
+		return err
+	}
+
+	body := hex.EncodeToString(claim)
+
+	input := s.putObjectInput(key, strings.NewReader(body), nil)
+	_, err := s.uploader.Upload(ctx, input)
+	if err == nil {
+		return nil
+	}
+	if !isConditionalWriteConflict(err) {
+		return errors.Wrap(ErrModuleUploadFailed, err.Error())
+	}
+
+	head := &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	head.SSECustomerAlgorithm, head.SSECustomerKey, head.SSECustomerKeyMD5 = s.sseCustomerParams()
+
+	marker, err := s.client.HeadObject(ctx, head)
+	if err != nil {
+		return errors.Wrap(ErrModuleUploadFailed, err.Error())
+	}
+
+	if s.now().Sub(aws.ToTime(marker.LastModified)) < versionClaimTimeout {
+		return errors.Wrap(ErrModuleAlreadyExists, key)
+	}
+
+	if _, err := s.GetModule(ctx, namespace, name, provider, version); !errors.Is(err, ErrModuleNotFound) {
+		if err != nil {
+			return err
+		}
+		return errors.Wrap(ErrModuleAlreadyExists, key)
+	}
+
+	// The marker is stale, only one publisher takes it over as the others no longer match its ETag
+	input = s.putObjectInput(key, strings.NewReader(body), nil)
+	if _, err := s.client.PutObject(ctx, input, ifMatch(aws.ToString(marker.ETag))); err != nil {
+		if isConditionalWriteConflict(err) {
+			return errors.Wrap(ErrModuleAlreadyExists, key)
+		}
+		return errors.Wrap(ErrModuleUploadFailed, err.Error())
+	}
+
+	return nil
+}
+
+// releaseVersion removes the marker of a module version.
+func (s *S3Storage) releaseVersion(ctx context.Context, namespace, name, provider, version string) error {
+	input := &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.versionKey(namespace, name, provider, version)),
+	}
+
+	if _, err := s.client.DeleteObject(ctx, input); err != nil {
+		return errors.Wrap(ErrModuleDeleteFailed, err.Error())
+	}
+
+	return nil
+}
+
+// putObjectInput returns the input to write an object with the configured encryption, tags, storage class and ACL.
+func (s *S3Storage) putObjectInput(key string, body io.Reader, metadata map[string]string) *s3.PutObjectInput {
 	input := &s3.PutObjectInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		Body:     body,
+		Metadata: metadata,
+	}
+
+	switch s.sseMode {
+	case S3SSEModeS3:
+		input.ServerSideEncryption = types.ServerSideEncryptionAes256
+	case S3SSEModeKMS:
+		input.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+		if s.sseKMSKeyID != "" {
+			input.SSEKMSKeyId = aws.String(s.sseKMSKeyID)
+		}
+	case S3SSEModeCustomer:
+		input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.sseCustomerParams()
+	}
+
+	if len(s.objectTags) > 0 {
+		tags := url.Values{}
+		for k, v := range s.objectTags {
+			tags.Set(k, v)
+		}
+		input.Tagging = aws.String(tags.Encode())
+	}
+
+	if s.storageClass != "" {
+		input.StorageClass = types.StorageClass(s.storageClass)
+	}
+
+	if s.acl != "" {
+		input.ACL = types.ObjectCannedACL(s.acl)
+	}
+
+	return input
+}
+
+// getManifestModule retrieves information about a module stored as a manifest referencing a blob.
+func (s *S3Storage) getManifestModule(ctx context.Context, namespace, name, provider, version string) (core.Module, error) {
+	mf, err := s.readManifest(ctx, s.moduleKey(namespace, name, provider, version, manifestExt))
+	if err != nil {
+		return core.Module{}, err
+	}
+
+	digest, _ := mf.hex()
+	key := blobKey(s.bucketPrefix, digest)
+
+	input := &s3.HeadObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(key),
-		Body:   body,
 	}
 
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.sseCustomerParams()
+
+	head, err := s.client.HeadObject(ctx, input)
+	if err != nil {
+		return core.Module{}, errors.Wrapf(s.objectError(err), "blob %s", mf.Digest)
+	}
+
+	if err := s.verifyEncryption(head); err != nil {
+		return core.Module{}, errors.Wrap(err, key)
+	}
+
+	publish := mf.Publish
+	m := core.Module{
+		Namespace: namespace,
+		Name:      name,
+		Provider:  provider,
+		Version:   version,
+		Publish:   &publish,
+	}
+
+	blobURL, err := s.downloadURL(ctx, key, m, mf.Format)
+	if err != nil {
+		return core.Module{}, err
+	}
+
+	m.DownloadURL, err = mf.downloadURL(blobURL)
+	if err != nil {
+		return core.Module{}, err
+	}
+
+	return m, nil
+}
+
+// readManifest downloads and decodes a manifest.
+func (s *S3Storage) readManifest(ctx context.Context, key string) (manifest, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.sseCustomerParams()
+
+	resp, err := s.client.GetObject(ctx, input)
+	if err != nil {
+		return manifest{}, errors.Wrap(s.objectError(err), key)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return manifest{}, errors.Wrapf(err, "failed to read manifest: %s", key)
+	}
+
+	mf, err := decodeManifest(data)
+	if err != nil {
+		return manifest{}, errors.Wrap(err, key)
+	}
+
+	return mf, nil
+}
+
+// uploadManifestModule stores the archive as a blob, unless a blob with the same digest exists already,
+// and writes the manifest of the module version.
+func (s *S3Storage) uploadManifestModule(ctx context.Context, namespace, name, provider, version string, body io.Reader, format string, publish core.PublishMetadata) (core.Module, error) {
+	m := core.Module{
+		Namespace: namespace,
+		Name:      name,
+		Provider:  provider,
+		Version:   version,
+	}
+	key := s.moduleKey(namespace, name, provider, version, manifestExt)
+
+	if _, err := s.GetModule(ctx, namespace, name, provider, version); err == nil {
+		return core.Module{}, errors.Wrap(ErrModuleAlreadyExists, key)
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return core.Module{}, errors.Wrap(ErrModuleUploadFailed, err.Error())
+	}
+
+	publish.PublishedAt = time.Now().UTC()
+	mf := newManifest(data, format, publish)
+	digest, _ := mf.hex()
+
+	encoded, err := json.Marshal(mf)
+	if err != nil {
+		return core.Module{}, errors.Wrap(ErrModuleUploadFailed, err.Error())
+	}
+
+	// The reference is created before the blob is needed, so deleting another version
+	// referencing the same blob keeps it.
+	ref := s.putObjectInput(refKey(s.bucketPrefix, digest, m), bytes.NewReader(nil), nil)
+	if _, err := s.client.PutObject(ctx, ref); err != nil {
+		return core.Module{}, errors.Wrap(ErrModuleUploadFailed, err.Error())
+	}
+
+	if err := s.ensureBlob(ctx, digest, data); err != nil {
+		return core.Module{}, err
+	}
+
+	input := s.putObjectInput(key, bytes.NewReader(encoded), nil)
+	input.ContentType = aws.String("application/json")
+
+	// The manifest is written conditionally, like plain archives
 	if _, err := s.uploader.Upload(ctx, input); err != nil {
-		return core.Module{}, errors.Wrapf(ErrModuleUploadFailed, err.Error())
+		if isConditionalWriteConflict(err) {
+			// The reference is shared with the publisher that won if it uploaded the same archive,
+			// otherwise ours would keep the blob forever.
+			if winner, err := s.readManifest(ctx, key); err == nil && winner.Digest != mf.Digest {
+				_ = s.releaseBlob(ctx, digest, m)
+			}
+			return core.Module{}, errors.Wrap(ErrModuleAlreadyExists, key)
+		}
+
+		_ = s.releaseBlob(ctx, digest, m)
+		return core.Module{}, errors.Wrap(ErrModuleUploadFailed, err.Error())
+	}
+
+	// A deletion of the last other version that checked the references before ours was created
+	// might have removed the blob in the meantime.
+	if err := s.ensureBlob(ctx, digest, data); err != nil {
+		return core.Module{}, err
 	}
 
 	return s.GetModule(ctx, namespace, name, provider, version)
 }
 
+// ensureBlob uploads the blob with the digest, unless it exists already.
+// S3 verifies the content against the digest.
+func (s *S3Storage) ensureBlob(ctx context.Context, digest string, data []byte) error {
+	key := blobKey(s.bucketPrefix, digest)
+
+	head := &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+
+	head.SSECustomerAlgorithm, head.SSECustomerKey, head.SSECustomerKeyMD5 = s.sseCustomerParams()
+
+	if _, err := s.client.HeadObject(ctx, head); err == nil {
+		return nil
+	}
+
+	sum, err := hex.DecodeString(digest)
+	if err != nil {
+		return errors.Wrap(ErrModuleUploadFailed, err.Error())
+	}
+
+	input := s.putObjectInput(key, bytes.NewReader(data), nil)
+	input.ChecksumAlgorithm = types.ChecksumAlgorithmSha256
+	input.ChecksumSHA256 = aws.String(base64.StdEncoding.EncodeToString(sum))
+
+	if _, err := s.client.PutObject(ctx, input); err != nil {
+		return errors.Wrap(ErrModuleUploadFailed, err.Error())
+	}
+
+	return nil
+}
+
+// deleteManifest removes the manifest of a module version and releases its blob.
+// Versions without a manifest are left alone.
+func (s *S3Storage) deleteManifest(ctx context.Context, namespace, name, provider, version string) error {
+	key := s.moduleKey(namespace, name, provider, version, manifestExt)
+
+	mf, err := s.readManifest(ctx, key)
+	if errors.Is(err, ErrModuleNotFound) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(ErrModuleDeleteFailed, err.Error())
+	}
+
+	input := &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+
+	if _, err := s.client.DeleteObject(ctx, input); err != nil {
+		return errors.Wrap(ErrModuleDeleteFailed, err.Error())
+	}
+
+	digest, _ := mf.hex()
+	m := core.Module{
+		Namespace: namespace,
+		Name:      name,
+		Provider:  provider,
+		Version:   version,
+	}
+
+	return s.releaseBlob(ctx, digest, m)
+}
+
+// releaseBlob removes the reference from the module version to the blob,
+// and the blob itself once no references are left.
+func (s *S3Storage) releaseBlob(ctx context.Context, digest string, m core.Module) error {
+	ref := &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(refKey(s.bucketPrefix, digest, m)),
+	}
+
+	if _, err := s.client.DeleteObject(ctx, ref); err != nil {
+		return errors.Wrap(ErrModuleDeleteFailed, err.Error())
+	}
+
+	refs, err := s.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(s.bucket),
+		Prefix:  aws.String(refsPrefix(s.bucketPrefix, digest)),
+		MaxKeys: 1,
+	})
+	if err != nil {
+		return errors.Wrap(ErrModuleDeleteFailed, err.Error())
+	}
+
+	if len(refs.Contents) > 0 {
+		return nil
+	}
+
+	blob := &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(blobKey(s.bucketPrefix, digest)),
+	}
+
+	if _, err := s.client.DeleteObject(ctx, blob); err != nil {
+		return errors.Wrap(ErrModuleDeleteFailed, err.Error())
+	}
+
+	return nil
+}
+
+// GetModuleArchive streams the archive of a module from S3.
+// It serves the download URLs of modules encrypted with a customer key.
+func (s *S3Storage) GetModuleArchive(ctx context.Context, namespace, name, provider, version string) (io.Reader, error) {
+	if s.contentAddressed {
+		mf, err := s.readManifest(ctx, s.moduleKey(namespace, name, provider, version, manifestExt))
+		if err == nil {
+			digest, _ := mf.hex()
+			return s.getObject(ctx, blobKey(s.bucketPrefix, digest))
+		}
+		if !errors.Is(err, ErrModuleNotFound) || !s.keyLayout.hasExt() {
+			return nil, err
+		}
+
+		// Versions published before content addressing was enabled are plain archives
+	}
+
+	var err error
+	for _, format := range s.archiveFormats() {
+		var body io.Reader
+		if body, err = s.getObject(ctx, s.moduleKey(namespace, name, provider, version, format)); !errors.Is(err, ErrModuleNotFound) {
+			return body, err
+		}
+	}
+
+	return nil, err
+}
+
+// getObject returns the content of an object, the caller must close it.
+func (s *S3Storage) getObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.sseCustomerParams()
+
+	resp, err := s.client.GetObject(ctx, input)
+	if err != nil {
+		return nil, errors.Wrap(s.objectError(err), key)
+	}
+
+	return resp.Body, nil
+}
+
+// downloadURL returns the URL the archive of a module is downloaded from.
+// Objects encrypted with a customer key can only be downloaded by clients sending the key headers,
+// so they are served through the registry instead of handing out a presigned URL.
+func (s *S3Storage) downloadURL(ctx context.Context, key string, m core.Module, format string) (string, error) {
+	if s.sseMode == S3SSEModeCustomer {
+		return path.Join("/", s.downloadPrefix, m.Namespace, m.Name, m.Provider, m.Version, "archive", archiveFileName(m, format)), nil
+	}
+
+	return s.presignedURL(ctx, key)
+}
+
 func (s *S3Storage) presignedURL(ctx context.Context, key string) (string, error) {
-	presignResult, err := s.presignClient.PresignGetObject(ctx,
-		&s3.GetObjectInput{
-			Bucket: aws.String(s.bucket),
-			Key:    aws.String(key),
-		},
-		s3.WithPresignExpires(s.signedURLExpiry),
-	)
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+
+	presignResult, err := s.presignClient.PresignGetObject(ctx, input, s3.WithPresignExpires(s.signedURLExpiry))
 
 	return presignResult.URL, err
 }
 
+// moduleKey returns the object key of a module version.
+func (s *S3Storage) moduleKey(namespace, name, provider, version, archiveFormat string) string {
+	m := core.Module{
+		Namespace: namespace,
+		Name:      name,
+		Provider:  provider,
+		Version:   version,
+	}
+
+	return s.keyLayout.render(s.bucketPrefix, m, archiveFormat)
+}
+
+// versionKey returns the object key of the marker of a module version, see claimVersion.
+// It is kept with the registry metadata, outside of the module key layout.
+func (s *S3Storage) versionKey(namespace, name, provider, version string) string {
+	m := core.Module{
+		Namespace: namespace,
+		Name:      name,
+		Provider:  provider,
+		Version:   version,
+	}
+
+	return metadataKey(s.bucketPrefix, path.Join(versionsDir, m.ID(true)))
+}
+
+// moduleFromKey parses the object key of a module version and returns its archive format,
+// or manifestExt if the key is a manifest.
+func (s *S3Storage) moduleFromKey(key string) (*core.Module, string, error) {
+	if s.bucketPrefix != "" {
+		key = strings.TrimPrefix(key, strings.TrimSuffix(s.bucketPrefix, "/")+"/")
+	}
+
+	// Blobs, references and metadata could match layouts without a static prefix
+	if isBlobKey(key) || isMetadataKey(key) {
+		return nil, "", errors.Errorf("key %q is not a module", key)
+	}
+
+	return s.keyLayout.moduleFromKey(key, s.keyFormats())
+}
+
+// keyFormats returns the extensions of the objects that represent a module version.
+func (s *S3Storage) keyFormats() []string {
+	if !s.contentAddressed {
+		return s.archiveFormats()
+	}
+
+	if !s.keyLayout.hasExt() {
+		return []string{manifestExt}
+	}
+
+	return append([]string{manifestExt}, s.archiveFormats()...)
+}
+
+// archiveFormats returns the archive formats modules can be stored in, starting with the configured format.
+func (s *S3Storage) archiveFormats() []string {
+	formats := []string{s.moduleArchiveFormat}
+	if !s.keyLayout.hasExt() {
+		return formats
+	}
+
+	for _, format := range utils.ArchiveFormats {
+		if format != s.moduleArchiveFormat {
+			formats = append(formats, format)
+		}
+	}
+
+	return formats
+}
+
+// sseCustomerParams returns the algorithm, key and key MD5 for requests on objects encrypted with a customer key.
+// All values are nil if no customer key is configured.
+func (s *S3Storage) sseCustomerParams() (algorithm, key, keyMD5 *string) {
+	if s.sseMode != S3SSEModeCustomer {
+		return nil, nil, nil
+	}
+
+	return aws.String(string(types.ServerSideEncryptionAes256)), aws.String(s.sseCustomerKey), aws.String(s.sseCustomerKeyMD5)
+}
+
+// verifyEncryption ensures an object is encrypted as configured for the storage.
+func (s *S3Storage) verifyEncryption(head *s3.HeadObjectOutput) error {
+	switch s.sseMode {
+	case S3SSEModeS3:
+		if head.ServerSideEncryption != types.ServerSideEncryptionAes256 {
+			return errors.Wrapf(ErrModuleEncryptionMismatch, "expected %s but was %q", types.ServerSideEncryptionAes256, head.ServerSideEncryption)
+		}
+	case S3SSEModeKMS:
+		if head.ServerSideEncryption != types.ServerSideEncryptionAwsKms {
+			return errors.Wrapf(ErrModuleEncryptionMismatch, "expected %s but was %q", types.ServerSideEncryptionAwsKms, head.ServerSideEncryption)
+		}
+
+		// S3 reports the key ARN, a configured key ID or ARN must match it. Aliases cannot be verified.
+		if s.sseKMSKeyID != "" && !strings.HasPrefix(s.sseKMSKeyID, "alias/") {
+			keyID := aws.ToString(head.SSEKMSKeyId)
+			if keyID != s.sseKMSKeyID && !strings.HasSuffix(keyID, "/"+s.sseKMSKeyID) {
+				return errors.Wrapf(ErrModuleEncryptionMismatch, "expected KMS key %s but was %q", s.sseKMSKeyID, keyID)
+			}
+		}
+	case S3SSEModeCustomer:
+		if aws.ToString(head.SSECustomerAlgorithm) == "" {
+			return errors.Wrap(ErrModuleEncryptionMismatch, "expected a customer provided key")
+		}
+	}
+
+	return nil
+}
+
+// validateEncryption checks the server-side encryption options and derives the customer key MD5.
+func (s *S3Storage) validateEncryption() error {
+	if s.sseKMSKeyID != "" && s.sseMode != S3SSEModeKMS {
+		return errors.New("a KMS key can only be used with the kms server-side encryption mode")
+	}
+
+	switch s.sseMode {
+	case S3SSEModeNone, S3SSEModeS3, S3SSEModeKMS:
+		return nil
+	case S3SSEModeCustomer:
+		if s.downloadPrefix == "" {
+			return errors.New("modules encrypted with a customer key must be served through the registry, but no download prefix is configured")
+		}
+
+		key, err := base64.StdEncoding.DecodeString(s.sseCustomerKey)
+		if err != nil {
+			return errors.Wrap(err, "customer key must be base64 encoded")
+		}
+		if len(key) != 32 {
+			return errors.Errorf("customer key must be 256 bits, but was %d bits", len(key)*8)
+		}
+
+		sum := md5.Sum(key)
+		s.sseCustomerKeyMD5 = base64.StdEncoding.EncodeToString(sum[:])
+		return nil
+	default:
+		return errors.Errorf("unknown server-side encryption mode: %s", s.sseMode)
+	}
+}
+
 func (s *S3Storage) download(ctx context.Context, path string) ([]byte, error) {
 	buf := s3manager.NewWriteAtBuffer([]byte{})
 
@@ -161,6 +967,71 @@ func (s *S3Storage) download(ctx context.Context, path string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// conditionalWriteMiddleware adds an If-None-Match: * header to the requests that create an object,
+// so S3 only accepts the write when no object exists under the key yet.
+// For multipart uploads the condition is evaluated on CompleteMultipartUpload.
+func conditionalWriteMiddleware(stack *middleware.Stack) error {
+	return stack.Build.Add(middleware.BuildMiddlewareFunc("ConditionalWrite", func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+		switch awsmiddleware.GetOperationName(ctx) {
+		case "PutObject", "CompleteMultipartUpload":
+			if req, ok := in.Request.(*smithyhttp.Request); ok {
+				req.Header.Set("If-None-Match", "*")
+			}
+		}
+
+		return next.HandleBuild(ctx, in)
+	}), middleware.After)
+}
+
+// ifMatch adds an If-Match header to the request, so S3 only accepts a write when the object still has the ETag.
+func ifMatch(etag string) func(*s3.Options) {
+	return func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+			return stack.Build.Add(middleware.BuildMiddlewareFunc("IfMatch", func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+				if req, ok := in.Request.(*smithyhttp.Request); ok {
+					req.Header.Set("If-Match", etag)
+				}
+
+				return next.HandleBuild(ctx, in)
+			}), middleware.After)
+		})
+	}
+}
+
+// objectError maps a failed read of an object to the storage errors. Only missing objects are not found,
+// with a customer key S3 answers 400 for objects encrypted otherwise.
+func (s *S3Storage) objectError(err error) error {
+	var (
+		apiErr  smithy.APIError
+		respErr interface{ HTTPStatusCode() int }
+	)
+
+	switch {
+	case errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NoSuchKey" || apiErr.ErrorCode() == "NotFound"):
+		return errors.Wrap(ErrModuleNotFound, err.Error())
+	case s.sseMode == S3SSEModeCustomer && errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusBadRequest:
+		return errors.Wrap(ErrModuleEncryptionMismatch, err.Error())
+	default:
+		return err
+	}
+}
+
+// isConditionalWriteConflict reports whether err is caused by a failed If-None-Match or If-Match precondition.
+// S3 answers 412 when the object already exists and 409 when a concurrent conditional write to the same key won.
+func isConditionalWriteConflict(err error) bool {
+	var respErr interface{ HTTPStatusCode() int }
+	if !errors.As(err, &respErr) {
+		return false
+	}
+
+	switch respErr.HTTPStatusCode() {
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		return true
+	default:
+		return false
+	}
+}
+
 // S3StorageOption provides additional options for the S3Storage.
 type S3StorageOption func(*S3Storage)
 
@@ -186,13 +1057,23 @@ func WithS3StorageBucketEndpoint(endpoint string) S3StorageOption {
 	}
 }
 
-// WithS3ArchiveFormat configures the module archive format (zip, tar, tgz, etc.)
+// WithS3ArchiveFormat configures the preferred module archive format (tar.gz, tgz or zip).
+// Modules in the other supported formats are read as well; uploads are stored in the format of their content.
+// Key layouts without {ext} ending in an archive extension, e.g. module.zip, only support the format of the extension.
 func WithS3ArchiveFormat(archiveFormat string) S3StorageOption {
 	return func(s *S3Storage) {
 		s.moduleArchiveFormat = archiveFormat
 	}
 }
 
+// WithS3StorageKeyLayout configures the object key layout of modules, relative to the bucket prefix.
+// The layout supports the {namespace}, {name}, {provider}, {version} and {ext} placeholders, see DefaultModuleKeyLayout.
+func WithS3StorageKeyLayout(layout string) S3StorageOption {
+	return func(s *S3Storage) {
+		s.moduleKeyLayout = layout
+	}
+}
+
 // WithS3StoragePathStyle configures if Path Style is used for a given s3 storage. (needed for MINIO)
 func WithS3StoragePathStyle(forcePathStyle bool) S3StorageOption {
 	return func(s *S3Storage) {
@@ -200,6 +1081,24 @@ func WithS3StoragePathStyle(forcePathStyle bool) S3StorageOption {
 	}
 }
 
+// WithS3StorageContentAddressed stores every distinct archive once, under blobs/sha256/<digest>,
+// and module versions as manifests referencing the blobs.
+// Versions stored as plain archives before remain readable, as long as the key layout has an {ext} placeholder.
+func WithS3StorageContentAddressed(contentAddressed bool) S3StorageOption {
+	return func(s *S3Storage) {
+		s.contentAddressed = contentAddressed
+	}
+}
+
+// WithS3StorageDownloadPrefix configures the path under which the module routes are registered.
+// Modules encrypted with a customer key are served through the registry under it, as a presigned URL
+// would require the clients to send the key.
+func WithS3StorageDownloadPrefix(prefix string) S3StorageOption {
+	return func(s *S3Storage) {
+		s.downloadPrefix = prefix
+	}
+}
+
 // WithS3StorageSignedUrlExpiry configures the duration until the signed url expires
 func WithS3StorageSignedUrlExpiry(t time.Duration) S3StorageOption {
 	return func(s *S3Storage) {
@@ -207,17 +1106,153 @@ func WithS3StorageSignedUrlExpiry(t time.Duration) S3StorageOption {
 	}
 }
 
+// WithS3StorageProfile configures the shared config profile used to load credentials and settings.
+func WithS3StorageProfile(profile string) S3StorageOption {
+	return func(s *S3Storage) {
+		s.profile = profile
+	}
+}
+
+// WithS3StorageStaticCredentials configures static credentials instead of the default credential chain.
+func WithS3StorageStaticCredentials(accessKeyID, secretAccessKey, sessionToken string) S3StorageOption {
+	return func(s *S3Storage) {
+		s.accessKeyID = accessKeyID
+		s.secretAccessKey = secretAccessKey
+		s.sessionToken = sessionToken
+	}
+}
+
+// WithS3StorageAssumeRole configures a role that is assumed for all S3 requests.
+func WithS3StorageAssumeRole(roleARN, externalID, sessionName string) S3StorageOption {
+	return func(s *S3Storage) {
+		s.assumeRole = s3AssumeRole{
+			roleARN:     roleARN,
+			externalID:  externalID,
+			sessionName: sessionName,
+		}
+	}
+}
+
+// WithS3StoragePresignAssumeRole configures a role that is assumed to sign download URLs.
+func WithS3StoragePresignAssumeRole(roleARN, externalID, sessionName string) S3StorageOption {
+	return func(s *S3Storage) {
+		s.presignAssumeRole = s3AssumeRole{
+			roleARN:     roleARN,
+			externalID:  externalID,
+			sessionName: sessionName,
+		}
+	}
+}
+
+// WithS3StorageMaxRetries configures the maximum number of attempts per request. Zero uses the SDK default.
+func WithS3StorageMaxRetries(maxRetries int) S3StorageOption {
+	return func(s *S3Storage) {
+		s.maxRetries = maxRetries
+	}
+}
+
+// WithS3StorageTimeout configures the timeout of a single HTTP request. Zero disables the timeout.
+func WithS3StorageTimeout(timeout time.Duration) S3StorageOption {
+	return func(s *S3Storage) {
+		s.timeout = timeout
+	}
+}
+
+// WithS3StorageCABundle configures a PEM file with the certificate authorities trusted by the client.
+func WithS3StorageCABundle(path string) S3StorageOption {
+	return func(s *S3Storage) {
+		s.caBundle = path
+	}
+}
+
+// WithS3StorageServerSideEncryption configures the server-side encryption of uploaded modules.
+// The mode is one of S3SSEModeS3, S3SSEModeKMS or S3SSEModeCustomer; kmsKeyID is only used with S3SSEModeKMS.
+func WithS3StorageServerSideEncryption(mode, kmsKeyID string) S3StorageOption {
+	return func(s *S3Storage) {
+		s.sseMode = mode
+		s.sseKMSKeyID = kmsKeyID
+	}
+}
+
+// WithS3StorageSSECustomerKey configures the base64 encoded 256-bit key used with S3SSEModeCustomer.
+func WithS3StorageSSECustomerKey(key string) S3StorageOption {
+	return func(s *S3Storage) {
+		s.sseCustomerKey = key
+	}
+}
+
+// WithS3StorageObjectTags configures the tags added to every uploaded module.
+func WithS3StorageObjectTags(tags map[string]string) S3StorageOption {
+	return func(s *S3Storage) {
+		s.objectTags = tags
+	}
+}
+
+// WithS3StorageStorageClass configures the storage class of uploaded modules.
+func WithS3StorageStorageClass(storageClass string) S3StorageOption {
+	return func(s *S3Storage) {
+		s.storageClass = storageClass
+	}
+}
+
+// WithS3StorageACL configures the canned ACL of uploaded modules.
+func WithS3StorageACL(acl string) S3StorageOption {
+	return func(s *S3Storage) {
+		s.acl = acl
+	}
+}
+
+// WithS3StorageLogger configures the logger of failures that do not fail the operation.
+func WithS3StorageLogger(logger zerolog.Logger) S3StorageOption {
+	return func(s *S3Storage) {
+		s.logger = logger
+	}
+}
+
+// WithS3StorageTracerProvider records a span for every AWS SDK call with the tracer provider.
+func WithS3StorageTracerProvider(provider trace.TracerProvider) S3StorageOption {
+	return func(s *S3Storage) {
+		s.tracerProvider = provider
+	}
+}
+
 // NewS3Storage returns a fully initialized S3 storage.
 func NewS3Storage(ctx context.Context, bucket string, options ...S3StorageOption) (*S3Storage, error) {
 	// Required- and default-values should be set here
 	s := &S3Storage{
-		bucket: bucket,
+		bucket:              bucket,
+		moduleArchiveFormat: DefaultModuleArchiveFormat,
+		moduleKeyLayout:     DefaultModuleKeyLayout,
+		logger:              zerolog.Nop(),
+		now:                 time.Now,
 	}
 
 	for _, option := range options {
 		option(s)
 	}
 
+	layout, err := parseKeyLayout(s.moduleKeyLayout)
+	if err != nil {
+		return nil, err
+	}
+	// The keys of layouts without {ext} are only valid for the format of their extension
+	if layout.format != "" {
+		s.moduleArchiveFormat = layout.format
+	}
+	if err := layout.validate(s.moduleArchiveFormat); err != nil {
+		return nil, err
+	}
+	if s.contentAddressed {
+		if err := layout.validate(manifestExt); err != nil {
+			return nil, err
+		}
+	}
+	s.keyLayout = layout
+
+	if err := s.validateEncryption(); err != nil {
+		return nil, err
+	}
+
 	// The EndpointResolver is used for compatibility with MinIO
 	customResolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
 		if s.bucketEndpoint != "" {
@@ -232,15 +1267,71 @@ func NewS3Storage(ctx context.Context, bucket string, options ...S3StorageOption
 		return aws.Endpoint{}, &aws.EndpointNotFoundError{}
 	})
 
+	loadOptions := []func(*config.LoadOptions) error{
+		config.WithRegion(s.bucketRegion),
+		config.WithEndpointResolverWithOptions(customResolver),
+	}
+
+	if s.profile != "" {
+		loadOptions = append(loadOptions, config.WithSharedConfigProfile(s.profile))
+	}
+
+	if s.accessKeyID != "" || s.secretAccessKey != "" {
+		if s.accessKeyID == "" || s.secretAccessKey == "" {
+			return nil, errors.New("both an access key ID and a secret access key are required for static credentials")
+		}
+		loadOptions = append(loadOptions, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(s.accessKeyID, s.secretAccessKey, s.sessionToken)))
+	}
+
+	if s.maxRetries > 0 {
+		loadOptions = append(loadOptions, config.WithRetryMaxAttempts(s.maxRetries))
+	}
+
+	if s.timeout > 0 {
+		loadOptions = append(loadOptions, config.WithHTTPClient(awshttp.NewBuildableClient().WithTimeout(s.timeout)))
+	}
+
+	if s.caBundle != "" {
+		caBundle, err := os.ReadFile(s.caBundle)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read CA bundle")
+		}
+		loadOptions = append(loadOptions, config.WithCustomCABundle(bytes.NewReader(caBundle)))
+	}
+
 	// Create the S3 client
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(s.bucketRegion), config.WithEndpointResolverWithOptions(customResolver))
+	cfg, err := config.LoadDefaultConfig(ctx, loadOptions...)
 	if err != nil {
 		return nil, err
 	}
 
-	s.client = s3.NewFromConfig(cfg)
+	// Registered before the clients are created, so the STS calls of the assumed roles are traced as well
+	if s.tracerProvider != nil {
+		otelaws.AppendMiddlewares(&cfg.APIOptions, otelaws.WithTracerProvider(s.tracerProvider))
+	}
+
+	if s.assumeRole.roleARN != "" {
+		cfg.Credentials = s.assumeRole.credentials(cfg)
+	}
+
+	clientOptions := func(o *s3.Options) {
+		o.UsePathStyle = s.forcePathStyle
+	}
+
+	s.client = s3.NewFromConfig(cfg, clientOptions)
 	s.presignClient = s3.NewPresignClient(s.client)
-	s.uploader = s3manager.NewUploader(s.client)
+
+	// Download URLs can be signed with a separate, more narrowly scoped identity
+	if s.presignAssumeRole.roleARN != "" {
+		presignCfg := cfg.Copy()
+		presignCfg.Credentials = s.presignAssumeRole.credentials(cfg)
+		s.presignClient = s3.NewPresignClient(s3.NewFromConfig(presignCfg, clientOptions))
+	}
+	s.uploader = s3manager.NewUploader(s.client, func(u *s3manager.Uploader) {
+		u.ClientOptions = append(u.ClientOptions, func(o *s3.Options) {
+			o.APIOptions = append(o.APIOptions, conditionalWriteMiddleware)
+		})
+	})
 	s.downloader = s3manager.NewDownloader(s.client)
 
 	if s.bucketRegion == "" {
@@ -1,13 +1,35 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
 
 	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	s3manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 )
 
 type mockS3Downloader struct {
@@ -35,3 +57,651 @@ func (m *mockS3Downloader) Download(ctx context.Context, w io.WriterAt, input *s
 	}
 	return 0, nil
 }
+
+func TestIsConditionalWriteConflict(t *testing.T) {
+	t.Parallel()
+
+	responseError := func(status int) error {
+		return &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+			Err:      errors.New("mocked response error"),
+		}
+	}
+
+	testCases := []struct {
+		annotation string
+		err        error
+		expected   bool
+	}{
+		{
+			annotation: "object already exists",
+			err:        responseError(http.StatusPreconditionFailed),
+			expected:   true,
+		},
+		{
+			annotation: "concurrent conditional write",
+			err:        responseError(http.StatusConflict),
+			expected:   true,
+		},
+		{
+			annotation: "wrapped precondition failure",
+			err:        errors.Wrap(responseError(http.StatusPreconditionFailed), "upload failed"),
+			expected:   true,
+		},
+		{
+			annotation: "other response error",
+			err:        responseError(http.StatusInternalServerError),
+			expected:   false,
+		},
+		{
+			annotation: "non response error",
+			err:        errors.New("mocked error"),
+			expected:   false,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.annotation, func(t *testing.T) {
+			assert.Equal(t, tc.expected, isConditionalWriteConflict(tc.err))
+		})
+	}
+}
+
+func TestNewS3Storage_InvalidClientOptions(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		annotation string
+		options    []S3StorageOption
+	}{
+		{
+			annotation: "access key ID without secret",
+			options:    []S3StorageOption{WithS3StorageStaticCredentials("AKIAEXAMPLE", "", "")},
+		},
+		{
+			annotation: "secret without access key ID",
+			options:    []S3StorageOption{WithS3StorageStaticCredentials("", "secret", "")},
+		},
+		{
+			annotation: "missing CA bundle",
+			options:    []S3StorageOption{WithS3StorageCABundle("/does/not/exist.pem")},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.annotation, func(t *testing.T) {
+			_, err := NewS3Storage(context.Background(), "bucket", tc.options...)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestS3Storage_VerifyEncryption(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		annotation    string
+		storage       S3Storage
+		head          s3.HeadObjectOutput
+		expectedError bool
+	}{
+		{
+			annotation: "no encryption required",
+			storage:    S3Storage{},
+			head:       s3.HeadObjectOutput{},
+		},
+		{
+			annotation: "s3 managed keys",
+			storage:    S3Storage{sseMode: S3SSEModeS3},
+			head:       s3.HeadObjectOutput{ServerSideEncryption: types.ServerSideEncryptionAes256},
+		},
+		{
+			annotation:    "s3 managed keys missing",
+			storage:       S3Storage{sseMode: S3SSEModeS3},
+			head:          s3.HeadObjectOutput{},
+			expectedError: true,
+		},
+		{
+			annotation: "kms with matching key ID",
+			storage:    S3Storage{sseMode: S3SSEModeKMS, sseKMSKeyID: "1234abcd"},
+			head: s3.HeadObjectOutput{
+				ServerSideEncryption: types.ServerSideEncryptionAwsKms,
+				SSEKMSKeyId:          aws.String("arn:aws:kms:eu-west-1:111122223333:key/1234abcd"),
+			},
+		},
+		{
+			annotation: "kms with another key",
+			storage:    S3Storage{sseMode: S3SSEModeKMS, sseKMSKeyID: "1234abcd"},
+			head: s3.HeadObjectOutput{
+				ServerSideEncryption: types.ServerSideEncryptionAwsKms,
+				SSEKMSKeyId:          aws.String("arn:aws:kms:eu-west-1:111122223333:key/5678efgh"),
+			},
+			expectedError: true,
+		},
+		{
+			annotation:    "kms but s3 managed keys used",
+			storage:       S3Storage{sseMode: S3SSEModeKMS},
+			head:          s3.HeadObjectOutput{ServerSideEncryption: types.ServerSideEncryptionAes256},
+			expectedError: true,
+		},
+		{
+			annotation: "customer key",
+			storage:    S3Storage{sseMode: S3SSEModeCustomer},
+			head:       s3.HeadObjectOutput{SSECustomerAlgorithm: aws.String("AES256")},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.annotation, func(t *testing.T) {
+			err := tc.storage.verifyEncryption(&tc.head)
+			if tc.expectedError {
+				assert.ErrorIs(t, err, ErrModuleEncryptionMismatch)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestS3Storage_ValidateEncryption(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		annotation    string
+		storage       S3Storage
+		expectedError bool
+	}{
+		{
+			annotation: "no encryption",
+			storage:    S3Storage{},
+		},
+		{
+			annotation: "kms with key",
+			storage:    S3Storage{sseMode: S3SSEModeKMS, sseKMSKeyID: "alias/registry"},
+		},
+		{
+			annotation:    "key without kms",
+			storage:       S3Storage{sseMode: S3SSEModeS3, sseKMSKeyID: "alias/registry"},
+			expectedError: true,
+		},
+		{
+			annotation: "valid customer key",
+			storage:    S3Storage{sseMode: S3SSEModeCustomer, sseCustomerKey: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=", downloadPrefix: "/v1/modules"},
+		},
+		{
+			annotation:    "customer key without download prefix",
+			storage:       S3Storage{sseMode: S3SSEModeCustomer, sseCustomerKey: "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="},
+			expectedError: true,
+		},
+		{
+			annotation:    "short customer key",
+			storage:       S3Storage{sseMode: S3SSEModeCustomer, sseCustomerKey: "MDEyMzQ1Njc4OWFiY2RlZg==", downloadPrefix: "/v1/modules"},
+			expectedError: true,
+		},
+		{
+			annotation:    "unknown mode",
+			storage:       S3Storage{sseMode: "aes"},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.annotation, func(t *testing.T) {
+			err := tc.storage.validateEncryption()
+			if tc.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestS3Storage_Tracing(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	s, err := NewS3Storage(context.Background(), "bucket",
+		WithS3StorageBucketRegion("eu-west-1"),
+		WithS3StorageBucketEndpoint(server.URL),
+		WithS3StoragePathStyle(true),
+		WithS3StorageStaticCredentials("AKIAEXAMPLE", "secret", ""),
+		WithS3StorageMaxRetries(1),
+		WithS3StorageTracerProvider(provider),
+	)
+	require.NoError(t, err)
+
+	ctx, span := provider.Tracer("test").Start(context.Background(), "request")
+	_, err = s.GetModule(ctx, "acme", "vpc", "aws", "1.0.0")
+	span.End()
+	assert.ErrorIs(t, err, ErrModuleNotFound)
+
+	spans := exporter.GetSpans()
+	require.Greater(t, len(spans), 1)
+
+	// Every SDK call is a child of the span in the context
+	for _, call := range spans[:len(spans)-1] {
+		assert.Equal(t, "S3.HeadObject", call.Name)
+		assert.Equal(t, span.SpanContext().SpanID(), call.Parent.SpanID())
+	}
+}
+
+// testS3Object is an object stored by the testS3Server.
+type testS3Object struct {
+	data     []byte
+	header   http.Header
+	modified time.Time
+}
+
+func (o testS3Object) etag() string {
+	sum := md5.Sum(o.data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// testS3Server is a minimal, path-style S3 API keeping the objects of a single bucket in memory.
+// It supports the operations of the S3Storage, including conditional writes with If-None-Match and If-Match.
+type testS3Server struct {
+	mu      sync.Mutex
+	objects map[string]testS3Object
+	// beforePut is called before an object is written, e.g. to let another publisher interfere.
+	beforePut func(key string)
+}
+
+func (s *testS3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Requests have the form /<bucket>/<key>
+	_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	if r.Method == http.MethodPut && s.beforePut != nil {
+		s.beforePut(key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && key == "" && r.URL.Query().Get("list-type") == "2":
+		s.list(w, r.URL.Query().Get("prefix"))
+	case r.Method == http.MethodPut:
+		obj, ok := s.objects[key]
+		if ok && r.Header.Get("If-None-Match") == "*" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		if etag := r.Header.Get("If-Match"); etag != "" && (!ok || obj.etag() != etag) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+
+		data, _ := io.ReadAll(r.Body)
+		header := http.Header{}
+		for name, values := range r.Header {
+			name = strings.ToLower(name)
+			if strings.HasPrefix(name, "x-amz-meta-") || strings.HasPrefix(name, "x-amz-server-side-encryption") {
+				header[name] = values
+			}
+		}
+		s.objects[key] = testS3Object{data: data, header: header, modified: time.Now()}
+		w.Header().Set("ETag", s.objects[key].etag())
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		obj, ok := s.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>NoSuchKey</Code></Error>`)
+			return
+		}
+
+		// Objects encrypted with a customer key are only read with the key, other objects only without
+		const algorithm = "x-amz-server-side-encryption-customer-algorithm"
+		if (r.Header.Get(algorithm) != "") != (len(obj.header[algorithm]) > 0) {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `<Error><Code>InvalidRequest</Code></Error>`)
+			return
+		}
+
+		for name, values := range obj.header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("ETag", obj.etag())
+		w.Header().Set("Last-Modified", obj.modified.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(obj.data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (s *testS3Server) list(w http.ResponseWriter, prefix string) {
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprint(w, `<ListBucketResult><IsTruncated>false</IsTruncated>`)
+	for _, key := range keys {
+		fmt.Fprintf(w, `<Contents><Key>%s</Key><Size>%d</Size></Contents>`, key, len(s.objects[key].data))
+	}
+	fmt.Fprint(w, `</ListBucketResult>`)
+}
+
+// keys returns the keys of the stored objects with the prefix.
+func (s *testS3Server) keys(prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// newTestS3Storage returns an S3Storage backed by a testS3Server.
+func newTestS3Storage(t *testing.T, options ...S3StorageOption) (*S3Storage, *testS3Server) {
+	t.Helper()
+
+	backend := &testS3Server{objects: make(map[string]testS3Object)}
+	server := httptest.NewServer(backend)
+	t.Cleanup(server.Close)
+
+	s, err := NewS3Storage(context.Background(), "bucket", append([]S3StorageOption{
+		WithS3StorageBucketRegion("eu-west-1"),
+		WithS3StorageBucketEndpoint(server.URL),
+		WithS3StoragePathStyle(true),
+		WithS3StorageStaticCredentials("AKIAEXAMPLE", "secret", ""),
+		WithS3StorageMaxRetries(1),
+	}, options...)...)
+	require.NoError(t, err)
+
+	return s, backend
+}
+
+func TestS3Storage_CustomerKeyDownloads(t *testing.T) {
+	t.Parallel()
+
+	const key = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+	for _, contentAddressed := range []bool{false, true} {
+		contentAddressed := contentAddressed
+		t.Run(fmt.Sprintf("content addressed %t", contentAddressed), func(t *testing.T) {
+			s, _ := newTestS3Storage(t,
+				WithS3StorageServerSideEncryption(S3SSEModeCustomer, ""),
+				WithS3StorageSSECustomerKey(key),
+				WithS3StorageDownloadPrefix("/v1/modules"),
+				WithS3StorageContentAddressed(contentAddressed),
+			)
+
+			data := []byte("\x1f\x8bmodule")
+			_, err := s.UploadModule(context.Background(), "acme", "vpc", "aws", "1.0.0", bytes.NewReader(data), core.PublishMetadata{})
+			require.NoError(t, err)
+
+			// The key headers cannot be part of a download URL, the registry serves the archive
+			m, err := s.GetModule(context.Background(), "acme", "vpc", "aws", "1.0.0")
+			require.NoError(t, err)
+			assert.True(t, strings.HasPrefix(m.DownloadURL, "/v1/modules/acme/vpc/aws/1.0.0/archive/acme-vpc-aws-1.0.0.tar.gz"), m.DownloadURL)
+			assert.NotContains(t, m.DownloadURL, "x-amz-server-side-encryption")
+
+			archive, err := s.GetModuleArchive(context.Background(), "acme", "vpc", "aws", "1.0.0")
+			require.NoError(t, err)
+			actual, err := io.ReadAll(archive)
+			assert.NoError(t, err)
+			assert.Equal(t, data, actual)
+
+			_, err = s.GetModuleArchive(context.Background(), "acme", "vpc", "aws", "2.0.0")
+			assert.ErrorIs(t, err, ErrModuleNotFound)
+		})
+	}
+}
+
+func TestS3Storage_CustomerKeyMismatch(t *testing.T) {
+	t.Parallel()
+
+	s, backend := newTestS3Storage(t,
+		WithS3StorageServerSideEncryption(S3SSEModeCustomer, ""),
+		WithS3StorageSSECustomerKey("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="),
+		WithS3StorageDownloadPrefix("/v1/modules"),
+	)
+
+	// A version published before the customer key was configured
+	backend.objects[s.moduleKey("acme", "vpc", "aws", "1.0.0", DefaultModuleArchiveFormat)] = testS3Object{
+		data:     []byte("\x1f\x8bmodule"),
+		header:   http.Header{},
+		modified: time.Now(),
+	}
+
+	_, err := s.GetModule(context.Background(), "acme", "vpc", "aws", "1.0.0")
+	assert.ErrorIs(t, err, ErrModuleEncryptionMismatch)
+
+	_, err = s.GetModuleArchive(context.Background(), "acme", "vpc", "aws", "1.0.0")
+	assert.ErrorIs(t, err, ErrModuleEncryptionMismatch)
+
+	_, err = s.GetModule(context.Background(), "acme", "vpc", "aws", "2.0.0")
+	assert.ErrorIs(t, err, ErrModuleNotFound)
+}
+
+func TestS3Storage_UploadModule_ConcurrentFormats(t *testing.T) {
+	t.Parallel()
+
+	s, backend := newTestS3Storage(t)
+
+	var (
+		archives = [][]byte{
+			[]byte("PK\x03\x04zip"),
+			[]byte("\x1f\x8btar.gz"),
+		}
+		start = make(chan struct{})
+		errs  = make(chan error, 2*len(archives))
+		wg    sync.WaitGroup
+	)
+
+	// Every archive is published twice, in parallel
+	for i := 0; i < 2; i++ {
+		for _, archive := range archives {
+			wg.Add(1)
+			go func(archive []byte) {
+				defer wg.Done()
+				<-start
+				_, err := s.UploadModule(context.Background(), "acme", "vpc", "aws", "1.0.0", bytes.NewReader(archive), core.PublishMetadata{})
+				errs <- err
+			}(archive)
+		}
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	var published int
+	for err := range errs {
+		if err == nil {
+			published++
+			continue
+		}
+		assert.ErrorIs(t, err, ErrModuleAlreadyExists)
+	}
+	assert.Equal(t, 1, published)
+	assert.Len(t, backend.keys("modules/"), 1)
+
+	// Deleting the version allows publishing it again, in another format
+	require.NoError(t, s.DeleteModule(context.Background(), "acme", "vpc", "aws", "1.0.0"))
+	assert.Empty(t, backend.keys(""))
+
+	_, err := s.UploadModule(context.Background(), "acme", "vpc", "aws", "1.0.0", bytes.NewReader(archives[0]), core.PublishMetadata{})
+	assert.NoError(t, err)
+}
+
+func TestS3Storage_UploadModule_StaleVersion(t *testing.T) {
+	t.Parallel()
+
+	s, backend := newTestS3Storage(t)
+	data := []byte("\x1f\x8bmodule")
+
+	// A publisher crashed after claiming the version
+	require.NoError(t, s.claimVersion(context.Background(), "acme", "vpc", "aws", "1.0.0"))
+
+	_, err := s.UploadModule(context.Background(), "acme", "vpc", "aws", "1.0.0", bytes.NewReader(data), core.PublishMetadata{})
+	assert.ErrorIs(t, err, ErrModuleAlreadyExists)
+
+	// The marker is taken over once the publisher ran out of time
+	s.now = func() time.Time { return time.Now().Add(versionClaimTimeout + time.Minute) }
+
+	_, err = s.UploadModule(context.Background(), "acme", "vpc", "aws", "1.0.0", bytes.NewReader(data), core.PublishMetadata{})
+	require.NoError(t, err)
+
+	// Markers of published versions are never stale
+	_, err = s.UploadModule(context.Background(), "acme", "vpc", "aws", "1.0.0", bytes.NewReader([]byte("PK\x03\x04zip")), core.PublishMetadata{})
+	assert.ErrorIs(t, err, ErrModuleAlreadyExists)
+	assert.Len(t, backend.keys("modules/"), 1)
+}
+
+func TestS3Storage_UploadModule_CanceledUpload(t *testing.T) {
+	t.Parallel()
+
+	s, backend := newTestS3Storage(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The client goes away during the upload of the archive
+	backend.beforePut = func(key string) {
+		if !strings.HasPrefix(key, "modules/") {
+			return
+		}
+
+		cancel()
+		for i := 0; i < 100 && len(backend.keys(metadataKey("", versionsDir)+"/")) > 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	_, err := s.UploadModule(ctx, "acme", "vpc", "aws", "1.0.0", bytes.NewReader([]byte("\x1f\x8bmodule")), core.PublishMetadata{})
+	assert.ErrorIs(t, err, ErrModuleUploadFailed)
+
+	// The version is released, although the request was canceled
+	assert.Empty(t, backend.keys(metadataKey("", versionsDir)+"/"))
+}
+
+func TestS3Storage_UploadModule_LayoutWithoutExt(t *testing.T) {
+	t.Parallel()
+
+	s, backend := newTestS3Storage(t,
+		WithS3StorageKeyLayout("{namespace}/{name}/{provider}/{version}/module.zip"),
+		WithS3ArchiveFormat(DefaultModuleArchiveFormat),
+	)
+
+	// The extension of the layout decides the format, whatever format is configured
+	_, err := s.UploadModule(context.Background(), "acme", "vpc", "aws", "1.0.0", bytes.NewReader([]byte("PK\x03\x04zip")), core.PublishMetadata{})
+	require.NoError(t, err)
+
+	_, err = s.UploadModule(context.Background(), "acme", "vpc", "aws", "2.0.0", bytes.NewReader([]byte("\x1f\x8btar.gz")), core.PublishMetadata{})
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"acme/vpc/aws/1.0.0/module.zip"}, backend.keys("acme/"))
+
+	m, err := s.GetModule(context.Background(), "acme", "vpc", "aws", "1.0.0")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0", m.Version)
+}
+
+func TestS3Storage_UploadModule_LosingManifest(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		annotation string
+		winner     []byte
+	}{
+		{
+			annotation: "same archive",
+			winner:     []byte("\x1f\x8bmodule"),
+		},
+		{
+			annotation: "different archive",
+			winner:     []byte("\x1f\x8bmodule with a tag bump"),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.annotation, func(t *testing.T) {
+			s, backend := newTestS3Storage(t, WithS3StorageContentAddressed(true))
+
+			// The winner publishes its manifest right before ours is written
+			var interfered atomic.Bool
+			backend.beforePut = func(key string) {
+				if strings.HasSuffix(key, "."+manifestExt) && interfered.CompareAndSwap(false, true) {
+					_, err := s.UploadModule(context.Background(), "acme", "vpc", "aws", "1.0.0", bytes.NewReader(tc.winner), core.PublishMetadata{})
+					assert.NoError(t, err)
+				}
+			}
+
+			_, err := s.UploadModule(context.Background(), "acme", "vpc", "aws", "1.0.0", bytes.NewReader([]byte("\x1f\x8bmodule")), core.PublishMetadata{})
+			assert.ErrorIs(t, err, ErrModuleAlreadyExists)
+
+			// Only the blob of the winner and its reference are left
+			digest := newManifest(tc.winner, "tar.gz", core.PublishMetadata{}).Digest
+			assert.Equal(t, []string{"blobs/" + strings.Replace(digest, ":", "/", 1)}, backend.keys(blobsDir+"/"))
+			assert.Equal(t, []string{"refs/" + strings.Replace(digest, ":", "/", 1) + "/acme/vpc/aws/1.0.0"}, backend.keys(refsDir+"/"))
+
+			m, err := s.GetModule(context.Background(), "acme", "vpc", "aws", "1.0.0")
+			require.NoError(t, err)
+			assert.Equal(t, digest, m.Publish.Digest)
+		})
+	}
+}
+
+func TestS3Storage_ListModuleVersions_ContentAddressed(t *testing.T) {
+	t.Parallel()
+
+	s, backend := newTestS3Storage(t, WithS3StorageContentAddressed(true))
+
+	versions := []string{"1.0.0", "1.1.0", "1.2.0", "2.0.0"}
+	for _, version := range versions {
+		_, err := s.UploadModule(context.Background(), "acme", "vpc", "aws", version, bytes.NewReader([]byte("\x1f\x8bmodule "+version)), core.PublishMetadata{})
+		require.NoError(t, err)
+	}
+
+	// A version published before content addressing was enabled
+	backend.objects[s.moduleKey("acme", "vpc", "aws", "0.9.0", DefaultModuleArchiveFormat)] = testS3Object{
+		data:     []byte("\x1f\x8bmodule 0.9.0"),
+		header:   http.Header{},
+		modified: time.Now(),
+	}
+
+	// A version whose blob is gone
+	digest, err := newManifest([]byte("\x1f\x8bmodule 1.1.0"), "tar.gz", core.PublishMetadata{}).hex()
+	require.NoError(t, err)
+	delete(backend.objects, blobKey("", digest))
+
+	modules, err := s.ListModuleVersions(context.Background(), "acme", "vpc", "aws")
+	require.NoError(t, err)
+
+	var listed []string
+	for _, m := range modules {
+		listed = append(listed, m.Version)
+		assert.NotEmpty(t, m.DownloadURL, m.Version)
+	}
+	assert.ElementsMatch(t, []string{"0.9.0", "1.0.0", "1.2.0", "2.0.0"}, listed)
+}
@@ -1,13 +1,22 @@
 package storage
 
 import (
+	"context"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
 	"github.com/MichielBijland/uncomplicated-registry/internal/module"
+	"github.com/MichielBijland/uncomplicated-registry/internal/utils"
 )
 
 const (
-	DefaultModuleArchiveFormat = "tar.gz"
+	DefaultModuleArchiveFormat = utils.ArchiveFormatTarGz
 )
 
 type Storage interface {
 	module.Storage
+
+	// ListModules returns all module versions in the storage.
+	ListModules(ctx context.Context) ([]core.Module, error)
+	// DeleteModule removes a module version from the storage.
+	DeleteModule(ctx context.Context, namespace, name, provider, version string) error
 }
@@ -0,0 +1,95 @@
+package token
+
+import (
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/auth"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type createRequest struct {
+	Name       string   `json:"name"`
+	Owner      string   `json:"owner,omitempty"`
+	Scopes     []string `json:"scopes,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+	// ExpiresIn is a duration like "720h", empty never expires the token.
+	ExpiresIn string `json:"expires_in,omitempty"`
+}
+
+type createResponse struct {
+	Token
+	Secret string `json:"token"`
+}
+
+type listResponse struct {
+	Tokens []Token `json:"tokens"`
+}
+
+func listEndpoint(store Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		tokens, err := store.List(c.UserContext())
+		if err != nil {
+			return errorHandler(c, err)
+		}
+
+		if tokens == nil {
+			tokens = []Token{}
+		}
+
+		return c.JSON(listResponse{Tokens: tokens})
+	}
+}
+
+func createEndpoint(store Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req createRequest
+		if err := c.BodyParser(&req); err != nil {
+			return errorHandler(c, fiber.NewError(fiber.StatusBadRequest, err.Error()))
+		}
+
+		options := Options{
+			Name:       req.Name,
+			Owner:      req.Owner,
+			Scopes:     req.Scopes,
+			Namespaces: req.Namespaces,
+		}
+
+		if req.ExpiresIn != "" {
+			expiresIn, err := time.ParseDuration(req.ExpiresIn)
+			if err != nil {
+				return errorHandler(c, fiber.NewError(fiber.StatusBadRequest, err.Error()))
+			}
+			options.ExpiresIn = expiresIn
+		}
+
+		// Tokens belong to the admin creating them, unless stated otherwise
+		if options.Owner == "" {
+			if identity, ok := auth.IdentityFromContext(c); ok {
+				options.Owner = identity.Name()
+			}
+		}
+
+		if err := options.Validate(); err != nil {
+			return errorHandler(c, fiber.NewError(fiber.StatusBadRequest, err.Error()))
+		}
+
+		t, secret, err := Create(c.UserContext(), store, options)
+		if err != nil {
+			return errorHandler(c, err)
+		}
+
+		return c.Status(fiber.StatusCreated).JSON(createResponse{Token: t, Secret: secret})
+	}
+}
+
+func revokeEndpoint(store Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		t, err := Revoke(c.UserContext(), store, c.Params("id"))
+		if err != nil {
+			return errorHandler(c, err)
+		}
+
+		return c.JSON(t)
+	}
+}
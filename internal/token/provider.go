@@ -0,0 +1,52 @@
+package token
+
+import (
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/auth"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/keyauth"
+	"github.com/pkg/errors"
+)
+
+// ProviderName is the name of the auth.Provider accepting managed tokens.
+const ProviderName = "token"
+
+// Provider accepts the active tokens of a Store.
+type Provider struct {
+	store Store
+	now   func() time.Time
+}
+
+// NewProvider returns a Provider for the tokens of the store, usually a CachedStore.
+func NewProvider(store Store) *Provider {
+	return &Provider{
+		store: store,
+		now:   time.Now,
+	}
+}
+
+func (p *Provider) String() string { return ProviderName }
+
+func (p *Provider) Verify(c *fiber.Ctx, secret string) (auth.Identity, error) {
+	id, ok := parseID(secret)
+	if !ok {
+		return auth.Identity{}, keyauth.ErrMissingOrMalformedAPIKey
+	}
+
+	t, err := p.store.Get(c.UserContext(), id)
+	if err != nil {
+		return auth.Identity{}, err
+	}
+
+	if !t.matches(secret) {
+		return auth.Identity{}, keyauth.ErrMissingOrMalformedAPIKey
+	}
+
+	if !t.Active(p.now()) {
+		return auth.Identity{}, errors.Errorf("token %s is revoked or expired", t.ID)
+	}
+
+	return t.Identity(), nil
+}
@@ -0,0 +1,270 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/storage"
+
+	"github.com/pkg/errors"
+)
+
+// ErrTokenNotFound is returned for unknown token IDs.
+var ErrTokenNotFound = errors.New("token not found")
+
+// metadataPrefix is the directory of the tokens in a storage.MetadataStorage.
+const metadataPrefix = "tokens/"
+
+// Store persists tokens by ID.
+type Store interface {
+	Get(ctx context.Context, id string) (Token, error)
+	List(ctx context.Context) ([]Token, error)
+	Put(ctx context.Context, t Token) error
+}
+
+// FileStore keeps the tokens in a JSON file. The file is read on every call,
+// so a server picks up the tokens created by the CLI on the same file.
+type FileStore struct {
+	file string
+	mu   sync.Mutex
+}
+
+type fileStoreData struct {
+	Tokens []Token `json:"tokens"`
+}
+
+// NewFileStore returns a FileStore, the file is created with the first token.
+func NewFileStore(file string) *FileStore {
+	return &FileStore{file: file}
+}
+
+func (s *FileStore) Get(ctx context.Context, id string) (Token, error) {
+	tokens, err := s.List(ctx)
+	if err != nil {
+		return Token{}, err
+	}
+
+	for _, t := range tokens {
+		if t.ID == id {
+			return t, nil
+		}
+	}
+
+	return Token{}, errors.Wrap(ErrTokenNotFound, id)
+}
+
+func (s *FileStore) List(ctx context.Context) ([]Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.read()
+}
+
+func (s *FileStore) Put(ctx context.Context, t Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	replaced := false
+	for i := range tokens {
+		if tokens[i].ID == t.ID {
+			tokens[i], replaced = t, true
+		}
+	}
+	if !replaced {
+		tokens = append(tokens, t)
+	}
+
+	data, err := json.MarshalIndent(fileStoreData{Tokens: tokens}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to encode tokens")
+	}
+
+	// Replace the file atomically, a server could read it at the same time
+	tmp, err := os.CreateTemp(filepath.Dir(s.file), filepath.Base(s.file)+".*")
+	if err != nil {
+		return errors.Wrap(err, "failed to write tokens")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write tokens")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write tokens")
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), s.file), "failed to write tokens")
+}
+
+func (s *FileStore) read() ([]Token, error) {
+	data, err := os.ReadFile(s.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read tokens")
+	}
+
+	var stored fileStoreData
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse tokens: %s", s.file)
+	}
+
+	return stored.Tokens, nil
+}
+
+// MetadataStore keeps every token in an object of a storage backend, e.g. the S3 bucket of the modules.
+type MetadataStore struct {
+	storage storage.MetadataStorage
+}
+
+// NewMetadataStore returns a MetadataStore.
+func NewMetadataStore(s storage.MetadataStorage) *MetadataStore {
+	return &MetadataStore{storage: s}
+}
+
+func (s *MetadataStore) Get(ctx context.Context, id string) (Token, error) {
+	// IDs are hex, but they come from untrusted requests
+	if id == "" || strings.ContainsAny(id, "/.") {
+		return Token{}, errors.Wrap(ErrTokenNotFound, id)
+	}
+
+	data, err := s.storage.GetMetadata(ctx, metadataPrefix+id+".json")
+	if errors.Is(err, storage.ErrMetadataNotFound) {
+		return Token{}, errors.Wrap(ErrTokenNotFound, id)
+	}
+	if err != nil {
+		return Token{}, err
+	}
+
+	var t Token
+	if err := json.Unmarshal(data, &t); err != nil {
+		return Token{}, errors.Wrapf(err, "failed to parse token %s", id)
+	}
+
+	return t, nil
+}
+
+func (s *MetadataStore) List(ctx context.Context) ([]Token, error) {
+	keys, err := s.storage.ListMetadata(ctx, metadataPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []Token
+	for _, key := range keys {
+		id := strings.TrimSuffix(strings.TrimPrefix(key, metadataPrefix), ".json")
+
+		t, err := s.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+
+	return tokens, nil
+}
+
+func (s *MetadataStore) Put(ctx context.Context, t Token) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode token")
+	}
+
+	return s.storage.PutMetadata(ctx, metadataPrefix+t.ID+".json", data)
+}
+
+// CachedStore keeps tokens read from a store in memory for a while, also unknown IDs,
+// so that requests with invalid tokens do not reach the store either.
+// Tokens revoked through another instance remain valid here until their entry expires.
+type CachedStore struct {
+	store Store
+	ttl   time.Duration
+	now   func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	token   Token
+	found   bool
+	expires time.Time
+}
+
+// NewCachedStore returns a CachedStore keeping the tokens of the store for the TTL.
+func NewCachedStore(store Store, ttl time.Duration) *CachedStore {
+	return &CachedStore{
+		store:   store,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+func (s *CachedStore) Get(ctx context.Context, id string) (Token, error) {
+	s.mu.Lock()
+	entry, ok := s.entries[id]
+	s.mu.Unlock()
+
+	if ok && s.now().Before(entry.expires) {
+		if !entry.found {
+			return Token{}, errors.Wrap(ErrTokenNotFound, id)
+		}
+		return entry.token, nil
+	}
+
+	t, err := s.store.Get(ctx, id)
+	if err != nil && !errors.Is(err, ErrTokenNotFound) {
+		return Token{}, err
+	}
+
+	s.set(id, t, err == nil)
+
+	return t, err
+}
+
+func (s *CachedStore) List(ctx context.Context) ([]Token, error) {
+	return s.store.List(ctx)
+}
+
+func (s *CachedStore) Put(ctx context.Context, t Token) error {
+	if err := s.store.Put(ctx, t); err != nil {
+		s.mu.Lock()
+		delete(s.entries, t.ID)
+		s.mu.Unlock()
+		return err
+	}
+
+	s.set(t.ID, t, true)
+
+	return nil
+}
+
+func (s *CachedStore) set(id string, t Token, found bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for k, v := range s.entries {
+		if !now.Before(v.expires) {
+			delete(s.entries, k)
+		}
+	}
+
+	s.entries[id] = cacheEntry{token: t, found: found, expires: now.Add(s.ttl)}
+}
@@ -0,0 +1,86 @@
+package token
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingStore counts the calls to Get of its store.
+type countingStore struct {
+	Store
+	gets int
+}
+
+func (s *countingStore) Get(ctx context.Context, id string) (Token, error) {
+	s.gets++
+	return s.Store.Get(ctx, id)
+}
+
+func TestFileStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "tokens.json")
+	store := NewFileStore(file)
+
+	tokens, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, tokens)
+
+	created, _, err := Create(ctx, store, Options{Name: "ci"})
+	require.NoError(t, err)
+
+	_, err = Revoke(ctx, store, created.ID)
+	require.NoError(t, err)
+
+	// Another store on the same file, like the CLI next to the server
+	tokens, err = NewFileStore(file).List(ctx)
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	assert.Equal(t, created.ID, tokens[0].ID)
+	assert.NotNil(t, tokens[0].RevokedAt)
+
+	_, err = store.Get(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrTokenNotFound)
+}
+
+func TestCachedStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	backend := &countingStore{Store: NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))}
+
+	created, _, err := Create(ctx, backend, Options{Name: "ci"})
+	require.NoError(t, err)
+
+	now := time.Now()
+	store := NewCachedStore(backend, time.Minute)
+	store.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		_, err = store.Get(ctx, created.ID)
+		require.NoError(t, err)
+
+		_, err = store.Get(ctx, "unknown")
+		assert.ErrorIs(t, err, ErrTokenNotFound)
+	}
+	assert.Equal(t, 2, backend.gets, "tokens and unknown IDs are cached")
+
+	// Revocations through the cache apply immediately
+	revoked, err := Revoke(ctx, store, created.ID)
+	require.NoError(t, err)
+
+	cached, err := store.Get(ctx, created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, revoked.RevokedAt, cached.RevokedAt)
+
+	now = now.Add(2 * time.Minute)
+	_, err = store.Get(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrTokenNotFound)
+	assert.Equal(t, 3, backend.gets, "expired entries are read again")
+}
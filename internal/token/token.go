@@ -0,0 +1,205 @@
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/auth"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+// secretPrefix makes registry tokens recognizable, e.g. for secret scanners.
+const secretPrefix = "urt_"
+
+// Scopes a token can be granted.
+var Scopes = []string{auth.ScopeRead, auth.ScopeWrite, auth.ScopeAdmin}
+
+// Token is a managed API token. Only the hash of its secret is stored.
+type Token struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Owner      string     `json:"owner,omitempty"`
+	Scopes     []string   `json:"scopes,omitempty"`
+	Namespaces []string   `json:"namespaces,omitempty"`
+	Hash       string     `json:"hash"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Options describe a token to create.
+type Options struct {
+	Name       string
+	Owner      string
+	Scopes     []string
+	Namespaces []string
+	// ExpiresIn is the lifetime of the token, zero never expires it.
+	ExpiresIn time.Duration
+}
+
+// Validate ensures that the Options are valid.
+func (o *Options) Validate() error {
+	var result *multierror.Error
+
+	if o.Name == "" {
+		result = multierror.Append(result, errors.New("name cannot be empty"))
+	}
+
+	for _, scope := range o.Scopes {
+		if !contains(Scopes, scope) {
+			result = multierror.Append(result, fmt.Errorf("unknown scope %q, must be one of %s", scope, strings.Join(Scopes, ", ")))
+		}
+	}
+
+	for _, namespace := range o.Namespaces {
+		if _, err := path.Match(namespace, ""); err != nil || namespace == "" {
+			result = multierror.Append(result, fmt.Errorf("invalid namespace pattern %q", namespace))
+		}
+	}
+
+	if o.ExpiresIn < 0 {
+		result = multierror.Append(result, errors.New("expires_in cannot be negative"))
+	}
+
+	return result.ErrorOrNil()
+}
+
+// Active reports whether the token is neither revoked nor expired.
+func (t *Token) Active(now time.Time) bool {
+	if t.RevokedAt != nil {
+		return false
+	}
+
+	return t.ExpiresAt == nil || now.Before(*t.ExpiresAt)
+}
+
+// matches reports whether the secret belongs to the token.
+func (t *Token) matches(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hash(secret)), []byte(t.Hash)) == 1
+}
+
+// Identity returns the identity requests made with the token are attributed to.
+func (t *Token) Identity() auth.Identity {
+	subject := t.Owner
+	if subject == "" {
+		subject = "token:" + t.ID
+	}
+
+	return auth.Identity{
+		Subject:    subject,
+		Provider:   ProviderName,
+		TokenID:    t.ID,
+		Scopes:     t.Scopes,
+		Namespaces: t.Namespaces,
+	}
+}
+
+// Create stores a new token and returns it with its secret, which cannot be retrieved later.
+func Create(ctx context.Context, store Store, options Options) (Token, string, error) {
+	if err := options.Validate(); err != nil {
+		return Token{}, "", err
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return Token{}, "", err
+	}
+
+	secret, err := randomSecret()
+	if err != nil {
+		return Token{}, "", err
+	}
+	secret = secretPrefix + id + "_" + secret
+
+	t := Token{
+		ID:         id,
+		Name:       options.Name,
+		Owner:      options.Owner,
+		Scopes:     options.Scopes,
+		Namespaces: options.Namespaces,
+		Hash:       hash(secret),
+		CreatedAt:  time.Now().UTC(),
+	}
+	if options.ExpiresIn > 0 {
+		expiresAt := t.CreatedAt.Add(options.ExpiresIn)
+		t.ExpiresAt = &expiresAt
+	}
+
+	if err := store.Put(ctx, t); err != nil {
+		return Token{}, "", err
+	}
+
+	return t, secret, nil
+}
+
+// Revoke marks the token as revoked, it is kept for auditing.
+func Revoke(ctx context.Context, store Store, id string) (Token, error) {
+	t, err := store.Get(ctx, id)
+	if err != nil {
+		return Token{}, err
+	}
+
+	if t.RevokedAt == nil {
+		revokedAt := time.Now().UTC()
+		t.RevokedAt = &revokedAt
+		if err := store.Put(ctx, t); err != nil {
+			return Token{}, err
+		}
+	}
+
+	return t, nil
+}
+
+// parseID returns the ID embedded in a secret.
+func parseID(secret string) (string, bool) {
+	rest, ok := strings.CutPrefix(secret, secretPrefix)
+	if !ok {
+		return "", false
+	}
+
+	id, _, ok := strings.Cut(rest, "_")
+	return id, ok && id != ""
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate token id")
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func randomSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate token secret")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
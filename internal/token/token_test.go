@@ -0,0 +1,244 @@
+package token
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/auth"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvider_Verify(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+
+	active, activeSecret, err := Create(ctx, store, Options{Name: "ci", Owner: "ci@example.com", Scopes: []string{auth.ScopeRead}})
+	require.NoError(t, err)
+
+	expired, expiredSecret, err := Create(ctx, store, Options{Name: "expired", ExpiresIn: time.Hour})
+	require.NoError(t, err)
+
+	revoked, revokedSecret, err := Create(ctx, store, Options{Name: "revoked"})
+	require.NoError(t, err)
+	_, err = Revoke(ctx, store, revoked.ID)
+	require.NoError(t, err)
+
+	provider := NewProvider(store)
+	provider.now = func() time.Time { return expired.ExpiresAt.Add(time.Second) }
+
+	app := fiber.New()
+	app.Use(auth.Middleware(zerolog.Nop(), provider))
+	app.Get("/", func(c *fiber.Ctx) error {
+		identity, _ := auth.IdentityFromContext(c)
+		return c.JSON(identity)
+	})
+
+	testCases := []struct {
+		annotation       string
+		secret           string
+		expectedStatus   int
+		expectedIdentity auth.Identity
+	}{
+		{
+			annotation:     "active token",
+			secret:         activeSecret,
+			expectedStatus: http.StatusOK,
+			expectedIdentity: auth.Identity{
+				Subject:  "ci@example.com",
+				Provider: ProviderName,
+				TokenID:  active.ID,
+				Scopes:   []string{auth.ScopeRead},
+			},
+		},
+		{
+			annotation:     "expired token",
+			secret:         expiredSecret,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			annotation:     "revoked token",
+			secret:         revokedSecret,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			annotation:     "wrong secret",
+			secret:         secretPrefix + active.ID + "_wrong",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			annotation:     "unknown token",
+			secret:         secretPrefix + "0000000000000000_secret",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			annotation:     "not a managed token",
+			secret:         "static",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+tc.secret)
+
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedStatus == http.StatusOK {
+				var identity auth.Identity
+				assert.NoError(t, json.NewDecoder(resp.Body).Decode(&identity))
+				assert.Equal(t, tc.expectedIdentity, identity)
+			}
+		})
+	}
+}
+
+func TestOptions_Validate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		annotation string
+		options    Options
+		wantErr    bool
+	}{
+		{
+			annotation: "valid",
+			options:    Options{Name: "ci", Scopes: []string{"read", "write"}, Namespaces: []string{"team-*"}, ExpiresIn: time.Hour},
+		},
+		{
+			annotation: "missing name",
+			options:    Options{},
+			wantErr:    true,
+		},
+		{
+			annotation: "unknown scope",
+			options:    Options{Name: "ci", Scopes: []string{"delete"}},
+			wantErr:    true,
+		},
+		{
+			annotation: "invalid namespace pattern",
+			options:    Options{Name: "ci", Namespaces: []string{"team-["}},
+			wantErr:    true,
+		},
+		{
+			annotation: "negative expiry",
+			options:    Options{Name: "ci", ExpiresIn: -time.Hour},
+			wantErr:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.options.Validate()
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+
+	_, adminSecret, err := Create(ctx, store, Options{Name: "admin", Owner: "admin@example.com", Scopes: []string{auth.ScopeAdmin}})
+	require.NoError(t, err)
+
+	_, writeSecret, err := Create(ctx, store, Options{Name: "write", Scopes: []string{auth.ScopeWrite}})
+	require.NoError(t, err)
+
+	app := fiber.New()
+	admin := app.Group("/v1/admin")
+	admin.Use(auth.Middleware(zerolog.Nop(), NewProvider(store)))
+	Register(store, admin, auth.RequireScope(zerolog.Nop(), auth.ScopeAdmin))
+
+	request := func(method, target, secret string, body interface{}) *http.Response {
+		var data []byte
+		if body != nil {
+			data, err = json.Marshal(body)
+			require.NoError(t, err)
+		}
+
+		req := httptest.NewRequest(method, target, bytes.NewReader(data))
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+secret)
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	// Tokens without the admin scope cannot manage tokens
+	resp := request(http.MethodGet, "/v1/admin/tokens", writeSecret, nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = request(http.MethodPost, "/v1/admin/tokens", adminSecret, createRequest{Name: "publish", Scopes: []string{"delete"}})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = request(http.MethodPost, "/v1/admin/tokens", adminSecret, createRequest{
+		Name:       "publish",
+		Scopes:     []string{auth.ScopeWrite},
+		Namespaces: []string{"team-a"},
+		ExpiresIn:  "24h",
+	})
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	var created createResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	assert.Equal(t, "admin@example.com", created.Owner)
+	assert.Equal(t, []string{"team-a"}, created.Namespaces)
+	assert.NotNil(t, created.ExpiresAt)
+	assert.True(t, created.matches(created.Secret))
+
+	resp = request(http.MethodGet, "/v1/admin/tokens", adminSecret, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var list listResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	assert.Len(t, list.Tokens, 3)
+
+	// Tokens that never expire and are not revoked have no such timestamps
+	for _, listed := range list.Tokens {
+		if listed.ID != created.ID {
+			assert.Nil(t, listed.ExpiresAt, listed.Name)
+		}
+		assert.Nil(t, listed.RevokedAt, listed.Name)
+	}
+
+	resp = request(http.MethodDelete, "/v1/admin/tokens/"+created.ID, adminSecret, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = request(http.MethodDelete, "/v1/admin/tokens/0000000000000000", adminSecret, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	revoked, err := store.Get(ctx, created.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, revoked.RevokedAt)
+}
@@ -0,0 +1,35 @@
+package token
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Register adds the token admin routes to the router, the middleware has to restrict them to admins.
+// The routes are named by their audit action.
+func Register(store Store, router fiber.Router, middleware ...fiber.Handler) {
+	router.Get("/tokens", withMiddleware(listEndpoint(store), middleware)...).Name("token.list")
+	router.Post("/tokens", withMiddleware(createEndpoint(store), middleware)...).Name("token.create")
+	router.Delete("/tokens/:id", withMiddleware(revokeEndpoint(store), middleware)...).Name("token.revoke")
+}
+
+func withMiddleware(endpoint fiber.Handler, middleware []fiber.Handler) []fiber.Handler {
+	return append(append([]fiber.Handler{}, middleware...), endpoint)
+}
+
+func errorHandler(c *fiber.Ctx, err error) error {
+	response := fiber.Map{
+		"errors": []string{err.Error()},
+	}
+
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &fiberErr):
+		return c.Status(fiberErr.Code).JSON(response)
+	case errors.Is(err, ErrTokenNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(response)
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(response)
+	}
+}
@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"context"
+	"io"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+	"github.com/MichielBijland/uncomplicated-registry/internal/module"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Service returns the service starting a span for every call, as a child of the span in the context.
+func Service(s module.Service, provider trace.TracerProvider) module.Service {
+	return &service{
+		Service: s,
+		tracer:  provider.Tracer(instrumentationName),
+	}
+}
+
+type service struct {
+	module.Service
+	tracer trace.Tracer
+}
+
+func (s *service) GetModule(ctx context.Context, namespace, name, provider, version string) (core.Module, error) {
+	ctx, span := s.start(ctx, "GetModule", namespace, name, provider, version)
+	res, err := s.Service.GetModule(ctx, namespace, name, provider, version)
+	end(span, err)
+
+	return res, err
+}
+
+func (s *service) DownloadModule(ctx context.Context, namespace, name, provider, version string) (core.Module, error) {
+	ctx, span := s.start(ctx, "DownloadModule", namespace, name, provider, version)
+	res, err := s.Service.DownloadModule(ctx, namespace, name, provider, version)
+	end(span, err)
+
+	return res, err
+}
+
+func (s *service) ListModuleVersions(ctx context.Context, namespace, name, provider string) ([]core.Module, error) {
+	ctx, span := s.start(ctx, "ListModuleVersions", namespace, name, provider, "")
+	res, err := s.Service.ListModuleVersions(ctx, namespace, name, provider)
+	if err == nil {
+		span.SetAttributes(attribute.Int("module.versions", len(res)))
+	}
+	end(span, err)
+
+	return res, err
+}
+
+func (s *service) GetModuleArchive(ctx context.Context, namespace, name, provider, version string) (io.Reader, error) {
+	ctx, span := s.start(ctx, "GetModuleArchive", namespace, name, provider, version)
+	res, err := s.Service.GetModuleArchive(ctx, namespace, name, provider, version)
+	end(span, err)
+
+	return res, err
+}
+
+func (s *service) PublishModule(ctx context.Context, namespace, name, provider, version string, body io.Reader, publish core.PublishMetadata) (core.Module, error) {
+	ctx, span := s.start(ctx, "PublishModule", namespace, name, provider, version)
+	res, err := s.Service.PublishModule(ctx, namespace, name, provider, version, body, publish)
+	end(span, err)
+
+	return res, err
+}
+
+// start starts the span of a call, the version is left out when empty.
+func (s *service) start(ctx context.Context, operation, namespace, name, provider, version string) (context.Context, trace.Span) {
+	attributes := []attribute.KeyValue{
+		attribute.String("module.namespace", namespace),
+		attribute.String("module.name", name),
+		attribute.String("module.provider", provider),
+	}
+	if version != "" {
+		attributes = append(attributes, attribute.String("module.version", version))
+	}
+
+	return s.tracer.Start(ctx, "module.Service/"+operation, trace.WithAttributes(attributes...))
+}
+
+// end records the error of a call and ends its span.
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
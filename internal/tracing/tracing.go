@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/version"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+)
+
+// ServiceName is the name the registry reports its spans under.
+const ServiceName = "uncomplicated-registry"
+
+// instrumentationName identifies the tracer of the registry.
+const instrumentationName = "github.com/MichielBijland/uncomplicated-registry"
+
+// propagator propagates the W3C trace context of the incoming requests.
+var propagator = propagation.TraceContext{}
+
+type config struct {
+	insecure    bool
+	sampleRatio float64
+}
+
+// Option provides additional options for the tracer provider.
+type Option func(*config)
+
+// WithInsecure exports the spans over plain HTTP instead of HTTPS.
+func WithInsecure(insecure bool) Option {
+	return func(c *config) {
+		c.insecure = insecure
+	}
+}
+
+// WithSampleRatio samples the given fraction of the traces started by the registry.
+// Traces started by the caller follow its sampling decision.
+func WithSampleRatio(ratio float64) Option {
+	return func(c *config) {
+		c.sampleRatio = ratio
+	}
+}
+
+// NewTracerProvider returns a tracer provider exporting the spans in batches to the OTLP/HTTP endpoint (host:port).
+// The provider should be shut down to flush the remaining spans.
+func NewTracerProvider(ctx context.Context, endpoint string, options ...Option) (*sdktrace.TracerProvider, error) {
+	c := &config{
+		sampleRatio: 1,
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	if c.sampleRatio < 0 || c.sampleRatio > 1 {
+		return nil, errors.Errorf("invalid sample ratio %v, it must be between 0 and 1", c.sampleRatio)
+	}
+
+	exporterOptions := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	if c.insecure {
+		exporterOptions = append(exporterOptions, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(ctx, exporterOptions...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to setup OTLP exporter")
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(ServiceName),
+		semconv.ServiceVersion(version.GetVersionMetadata().Version),
+	))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to setup tracing resource")
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.sampleRatio))),
+	), nil
+}
@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MichielBijland/uncomplicated-registry/internal/core"
+	"github.com/MichielBijland/uncomplicated-registry/internal/module"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	s := module.NewInmemStorage()
+	_, err := s.UploadModule(context.Background(), "acme", "vpc", "aws", "1.0.0", bytes.NewBufferString("data"), core.PublishMetadata{})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		annotation  string
+		path        string
+		traceparent string
+		status      int
+		spans       []string
+		serviceErr  bool
+	}{
+		{
+			annotation:  "continues the trace of the caller",
+			path:        "/v1/modules/acme/vpc/aws/versions",
+			traceparent: traceparent,
+			status:      http.StatusOK,
+			spans:       []string{"module.Service/ListModuleVersions", "GET /v1/modules/:namespace/:name/:provider/versions"},
+		},
+		{
+			annotation: "starts a new trace",
+			path:       "/v1/modules/acme/vpc/aws/1.0.0/download",
+			status:     http.StatusNoContent,
+			spans:      []string{"module.Service/DownloadModule", "GET /v1/modules/:namespace/:name/:provider/:version/download"},
+		},
+		{
+			annotation:  "records the error of the service",
+			path:        "/v1/modules/acme/vpc/aws/2.0.0",
+			traceparent: traceparent,
+			status:      http.StatusNotFound,
+			spans:       []string{"module.Service/GetModule", "GET /v1/modules/:namespace/:name/:provider/:version"},
+			serviceErr:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.annotation, func(t *testing.T) {
+			t.Parallel()
+
+			exporter := tracetest.NewInMemoryExporter()
+			provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+			app := fiber.New()
+			app.Use(Middleware(provider))
+			module.Register(Service(module.NewService(s), provider), app.Group("/v1/modules"))
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.traceparent != "" {
+				req.Header.Set("traceparent", tc.traceparent)
+			}
+
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			assert.Equal(t, tc.status, resp.StatusCode)
+
+			spans := exporter.GetSpans()
+			require.Len(t, spans, len(tc.spans))
+
+			// Spans are exported as they end, the request span ends last
+			server := spans[len(spans)-1]
+			assert.Equal(t, trace.SpanKindServer, server.SpanKind)
+			assert.Contains(t, server.Attributes, attribute.Int("http.response.status_code", tc.status))
+			assert.Contains(t, server.Attributes, attribute.String("url.path", tc.path))
+
+			if tc.traceparent != "" {
+				assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext.TraceID().String())
+				assert.Equal(t, "00f067aa0ba902b7", server.Parent.SpanID().String())
+			} else {
+				assert.False(t, server.Parent.IsValid())
+			}
+
+			for i, span := range spans {
+				assert.Equal(t, tc.spans[i], span.Name)
+				assert.Equal(t, server.SpanContext.TraceID(), span.SpanContext.TraceID())
+			}
+
+			// The service call is a child of the request
+			service := spans[len(spans)-2]
+			assert.Equal(t, server.SpanContext.SpanID(), service.Parent.SpanID())
+			assert.Contains(t, service.Attributes, attribute.String("module.namespace", "acme"))
+
+			if tc.serviceErr {
+				assert.Equal(t, codes.Error, service.Status.Code)
+			} else {
+				assert.Equal(t, codes.Unset, service.Status.Code)
+			}
+
+			// Only server errors fail the request
+			if tc.status >= http.StatusInternalServerError {
+				assert.Equal(t, codes.Error, server.Status.Code)
+			} else {
+				assert.Equal(t, codes.Unset, server.Status.Code)
+			}
+		})
+	}
+}
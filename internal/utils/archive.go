@@ -2,6 +2,7 @@ package utils
 
 import (
 	"archive/tar"
+	"archive/zip"
 	"bytes"
 	"compress/gzip"
 	"fmt"
@@ -17,24 +18,132 @@ import (
 // TODO: make this configurable and use this as default
 const allowedFilesRegex = `^.*\.(tf\.json|tftpl|tf)|README(\.md){0,1}|LICENSE$`
 
-func ArchiveModule(root string, logger zerolog.Logger) (io.Reader, error) {
-	allowed, err := regexp.Compile(allowedFilesRegex)
+var allowedFiles = regexp.MustCompile(allowedFilesRegex)
+
+// IsModuleFile reports whether a file with the given base name is included in module archives.
+func IsModuleFile(name string) bool {
+	return allowedFiles.MatchString(name)
+}
+
+// Module archive formats.
+const (
+	ArchiveFormatTarGz = "tar.gz"
+	ArchiveFormatTgz   = "tgz"
+	ArchiveFormatZip   = "zip"
+)
+
+// ArchiveFormats lists the supported module archive formats.
+var ArchiveFormats = []string{ArchiveFormatTarGz, ArchiveFormatTgz, ArchiveFormatZip}
+
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// DetectArchiveFormat returns the format of an archive based on its first bytes.
+// Gzip compressed archives are reported as the fallback if that is a gzip format, otherwise as tar.gz.
+// Content that is not recognized is reported as the fallback.
+func DetectArchiveFormat(header []byte, fallback string) string {
+	switch {
+	case bytes.HasPrefix(header, zipMagic):
+		return ArchiveFormatZip
+	case bytes.HasPrefix(header, gzipMagic):
+		if fallback == ArchiveFormatTgz {
+			return ArchiveFormatTgz
+		}
+		return ArchiveFormatTarGz
+	default:
+		return fallback
+	}
+}
+
+// ArchiveWriter adds files to an archive.
+type ArchiveWriter interface {
+	WriteFile(name string, fi os.FileInfo, r io.Reader) error
+	Close() error
+}
+
+type tarGzWriter struct {
+	gw *gzip.Writer
+	tw *tar.Writer
+}
+
+func (w *tarGzWriter) WriteFile(name string, fi os.FileInfo, r io.Reader) error {
+	// create a new dir/file header
+	header, err := tar.FileInfoHeader(fi, fi.Name())
 	if err != nil {
-		logger.Fatal().Err(err).Msg("invalid regex given")
-		return nil, err
+		return err
 	}
 
+	// update the name to correctly reflect the desired destination when untaring
+	header.Name = name
+
+	if err := w.tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w.tw, r)
+	return err
+}
+
+func (w *tarGzWriter) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+	return w.gw.Close()
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (w *zipWriter) WriteFile(name string, fi os.FileInfo, r io.Reader) error {
+	header, err := zip.FileInfoHeader(fi)
+	if err != nil {
+		return err
+	}
+
+	header.Name = filepath.ToSlash(name)
+	header.Method = zip.Deflate
+
+	f, err := w.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func (w *zipWriter) Close() error {
+	return w.zw.Close()
+}
+
+// NewArchiveWriter returns an ArchiveWriter writing an archive in the given format (tar.gz, tgz or zip) to w.
+func NewArchiveWriter(w io.Writer, format string) (ArchiveWriter, error) {
+	switch format {
+	case ArchiveFormatTarGz, ArchiveFormatTgz:
+		gw := gzip.NewWriter(w)
+		return &tarGzWriter{gw: gw, tw: tar.NewWriter(gw)}, nil
+	case ArchiveFormatZip:
+		return &zipWriter{zw: zip.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+// ArchiveModule archives the module in root in the given format (tar.gz, tgz or zip).
+func ArchiveModule(root string, format string, logger zerolog.Logger) (io.Reader, error) {
 	buf := new(bytes.Buffer)
-	// ensure the src actually exists before trying to tar it
+	// ensure the src actually exists before trying to archive it
 	if _, err := os.Stat(root); err != nil {
-		return buf, fmt.Errorf("unable to tar files - %v", err.Error())
+		return buf, fmt.Errorf("unable to archive files - %v", err.Error())
 	}
 
-	gw := gzip.NewWriter(buf)
-	defer gw.Close()
-
-	tw := tar.NewWriter(gw)
-	defer tw.Close()
+	aw, err := NewArchiveWriter(buf, format)
+	if err != nil {
+		return buf, err
+	}
 
 	err = filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
 		// return on any error
@@ -57,31 +166,20 @@ func ArchiveModule(root string, logger zerolog.Logger) (io.Reader, error) {
 
 		// only allow files that match the allowedFilesRegex pattern
 		if !fi.IsDir() {
-			if !allowed.MatchString(fi.Name()) {
+			if !IsModuleFile(fi.Name()) {
 				logger.Info().Msg("Skipping file: " + fi.Name() + " as it does not match the allowedFilesRegex pattern")
 				return nil
 			}
 		}
 
-		// create a new dir/file header
-		header, err := tar.FileInfoHeader(fi, fi.Name())
-		if err != nil {
-			return err
-		}
-
-		// update the name to correctly reflect the desired destination when untaring
-		header.Name = strings.TrimPrefix(strings.Replace(path, root, "", -1), string(filepath.Separator))
-
-		if err := tw.WriteHeader(header); err != nil {
-			return err
-		}
-
 		data, err := os.Open(path)
 		if err != nil {
 			return err
 		}
 
-		if _, err := io.Copy(tw, data); err != nil {
+		name := strings.TrimPrefix(strings.Replace(path, root, "", -1), string(filepath.Separator))
+		if err := aw.WriteFile(name, fi, data); err != nil {
+			data.Close()
 			return err
 		}
 
@@ -91,6 +189,9 @@ func ArchiveModule(root string, logger zerolog.Logger) (io.Reader, error) {
 
 		return nil
 	})
+	if err != nil {
+		return buf, err
+	}
 
-	return buf, err
+	return buf, aw.Close()
 }
@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectArchiveFormat(t *testing.T) {
+	testCases := []struct {
+		name     string
+		header   []byte
+		fallback string
+		expected string
+	}{
+		{
+			name:     "zip",
+			header:   []byte("PK\x03\x04"),
+			fallback: ArchiveFormatTarGz,
+			expected: ArchiveFormatZip,
+		},
+		{
+			name:     "gzip",
+			header:   []byte{0x1f, 0x8b, 0x08, 0x00},
+			fallback: ArchiveFormatZip,
+			expected: ArchiveFormatTarGz,
+		},
+		{
+			name:     "gzip with tgz fallback",
+			header:   []byte{0x1f, 0x8b, 0x08, 0x00},
+			fallback: ArchiveFormatTgz,
+			expected: ArchiveFormatTgz,
+		},
+		{
+			name:     "unknown",
+			header:   []byte("foo"),
+			fallback: ArchiveFormatTgz,
+			expected: ArchiveFormatTgz,
+		},
+		{
+			name:     "empty",
+			fallback: ArchiveFormatTarGz,
+			expected: ArchiveFormatTarGz,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expected, DetectArchiveFormat(tc.header, tc.fallback))
+		})
+	}
+}
+
+func TestArchiveModule(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"main.tf":             `name = "foo"`,
+		"README.md":           "# foo",
+		"notes.txt":           "foo",
+		"modules/bar/main.tf": `name = "bar"`,
+	}
+	for name, content := range files {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0o755))
+		assert.NoError(t, os.WriteFile(filepath.Join(root, name), []byte(content), 0o644))
+	}
+
+	expected := []string{"README.md", "main.tf", "modules/bar/main.tf"}
+
+	testCases := []struct {
+		format      string
+		list        func(t *testing.T, data []byte) []string
+		expectError bool
+	}{
+		{
+			format: ArchiveFormatTarGz,
+			list:   listTarGz,
+		},
+		{
+			format: ArchiveFormatTgz,
+			list:   listTarGz,
+		},
+		{
+			format: ArchiveFormatZip,
+			list:   listZip,
+		},
+		{
+			format:      "rar",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.format, func(t *testing.T) {
+			t.Parallel()
+
+			r, err := ArchiveModule(root, tc.format, zerolog.Nop())
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			data, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.format, DetectArchiveFormat(data, tc.format))
+			assert.Equal(t, expected, tc.list(t, data))
+		})
+	}
+}
+
+func listTarGz(t *testing.T, data []byte) []string {
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	assert.NoError(t, err)
+
+	var names []string
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func listZip(t *testing.T, data []byte) []string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+
+	sort.Strings(names)
+	return names
+}